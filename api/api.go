@@ -0,0 +1,475 @@
+// Package api exposes the latest scrape results of a kag.Monitor as JSON over
+// HTTP.  Response bodies mirror the shapes used by Burrow's v3 REST API so
+// that existing Burrow dashboards and scripts can be pointed at kag.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/savaki/kag"
+)
+
+// Source provides the most recent Snapshot e.g. *kag.Monitor
+type Source interface {
+	// Snapshot returns the most recent scrape or nil if none has completed
+	Snapshot() *kag.Snapshot
+}
+
+// MultiSource may optionally be implemented by a Source that monitors more
+// than one cluster.  The v1 endpoints serve the first cluster unless one is
+// selected with ?cluster=name.
+type MultiSource interface {
+	// Snapshots returns the most recent scrape of each cluster for which one
+	// has completed
+	Snapshots() []*kag.Snapshot
+}
+
+type request struct {
+	URL  string `json:"url"`
+	Host string `json:"host"`
+}
+
+type envelope struct {
+	Error   bool    `json:"error"`
+	Message string  `json:"message"`
+	Request request `json:"request"`
+}
+
+type clustersResponse struct {
+	envelope
+	Clusters []string `json:"clusters"`
+}
+
+type consumersResponse struct {
+	envelope
+	Consumers []string `json:"consumers"`
+}
+
+type topicsResponse struct {
+	envelope
+	Topics []string `json:"topics"`
+}
+
+type ConsumerOffset struct {
+	Offset    int64 `json:"offset"`
+	Timestamp int64 `json:"timestamp"`
+	Lag       int64 `json:"lag"`
+}
+
+type ConsumerPartition struct {
+	Offsets    []ConsumerOffset `json:"offsets"`
+	Owner      string           `json:"owner"`
+	ClientID   string           `json:"client_id"`
+	CurrentLag int64            `json:"current-lag"`
+}
+
+type consumerDetailResponse struct {
+	envelope
+	Topics map[string][]ConsumerPartition `json:"topics"`
+}
+
+type PartitionStatus struct {
+	Topic      string          `json:"topic"`
+	Partition  int32           `json:"partition"`
+	Owner      string          `json:"owner"`
+	ClientID   string          `json:"client_id"`
+	Status     string          `json:"status"`
+	Start      *ConsumerOffset `json:"start"`
+	End        *ConsumerOffset `json:"end"`
+	CurrentLag int64           `json:"current_lag"`
+	Complete   float32         `json:"complete"`
+
+	// TimeLagMS holds the age of the oldest unconsumed record; only present
+	// when kag reads time lag
+	TimeLagMS *int64 `json:"time_lag_ms,omitempty"`
+
+	// ProduceRate, ConsumeRate, and CatchUp are present from the second
+	// scrape on.  CatchUp is a duration e.g. 1m30s or "never".
+	ProduceRate *float64 `json:"produce_rate,omitempty"`
+	ConsumeRate *float64 `json:"consume_rate,omitempty"`
+	CatchUp     string   `json:"catch_up,omitempty"`
+
+	// RetentionHeadroom and TimeToDataLoss are present from the second scrape
+	// on for lagging partitions.  TimeToDataLoss is a duration, "never", or
+	// "lost".  Partitions in alert report a status of WARN.
+	RetentionHeadroom *int64 `json:"retention_headroom,omitempty"`
+	TimeToDataLoss    string `json:"time_to_data_loss,omitempty"`
+}
+
+type ConsumerGroupStatus struct {
+	Cluster        string             `json:"cluster"`
+	Group          string             `json:"group"`
+	Status         string             `json:"status"`
+	Complete       float32            `json:"complete"`
+	Partitions     []*PartitionStatus `json:"partitions"`
+	PartitionCount int                `json:"partition_count"`
+	Maxlag         *PartitionStatus   `json:"maxlag"`
+	TotalLag       uint64             `json:"totallag"`
+}
+
+type consumerStatusResponse struct {
+	envelope
+	Status ConsumerGroupStatus `json:"status"`
+}
+
+type clusterHealthResponse struct {
+	envelope
+	Health kag.ClusterHealth `json:"health"`
+}
+
+type topicDetailResponse struct {
+	envelope
+	Offsets []int64 `json:"offsets"`
+}
+
+const (
+	statusOK       = "OK"
+	statusWarn     = "WARN"
+	statusNotFound = "NOTFOUND"
+)
+
+type handler struct {
+	source Source
+	host   string
+}
+
+// New returns an http.Handler that serves the following endpoints
+//
+//	/v1/clusters
+//	/v1/cluster/health
+//	/v1/groups
+//	/v1/groups/{group}
+//	/v1/groups/{group}/lag
+//	/v1/topics
+//	/v1/topics/{topic}
+//
+// as well as the equivalent Burrow v3 paths under /v3/kafka
+func New(source Source) http.Handler {
+	host, _ := os.Hostname()
+	return &handler{
+		source: source,
+		host:   host,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.writeError(w, req, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(segments) < 2 {
+		h.writeError(w, req, http.StatusNotFound, "invalid request type")
+		return
+	}
+
+	snapshots := h.snapshots()
+	if len(snapshots) == 0 {
+		h.writeError(w, req, http.StatusServiceUnavailable, "no scrape has completed")
+		return
+	}
+
+	switch segments[0] {
+	case "v1":
+		snapshot := snapshots[0]
+		if cluster := req.URL.Query().Get("cluster"); cluster != "" {
+			if snapshot = findCluster(snapshots, cluster); snapshot == nil {
+				h.writeError(w, req, http.StatusNotFound, "cluster not found")
+				return
+			}
+		}
+		h.serveV1(w, req, snapshots, snapshot, segments[1:])
+	case "v3":
+		h.serveV3(w, req, snapshots, segments[1:])
+	default:
+		h.writeError(w, req, http.StatusNotFound, "invalid request type")
+	}
+}
+
+// snapshots returns the snapshots of every cluster served
+func (h *handler) snapshots() []*kag.Snapshot {
+	if v, ok := h.source.(MultiSource); ok {
+		return v.Snapshots()
+	}
+	if snapshot := h.source.Snapshot(); snapshot != nil {
+		return []*kag.Snapshot{snapshot}
+	}
+	return nil
+}
+
+// findCluster returns the snapshot of the named cluster or nil if none
+func findCluster(snapshots []*kag.Snapshot, cluster string) *kag.Snapshot {
+	for _, snapshot := range snapshots {
+		if snapshot.Cluster == cluster {
+			return snapshot
+		}
+	}
+	return nil
+}
+
+func (h *handler) serveV1(w http.ResponseWriter, req *http.Request, snapshots []*kag.Snapshot, snapshot *kag.Snapshot, segments []string) {
+	switch {
+	case len(segments) == 1 && segments[0] == "clusters":
+		h.clusters(w, req, snapshots)
+	case len(segments) == 2 && segments[0] == "cluster" && segments[1] == "health":
+		h.clusterHealth(w, req, snapshot)
+	case len(segments) == 1 && segments[0] == "groups":
+		h.consumers(w, req, snapshot)
+	case len(segments) == 2 && segments[0] == "groups":
+		h.consumerDetail(w, req, snapshot, segments[1])
+	case len(segments) == 3 && segments[0] == "groups" && segments[2] == "lag":
+		h.consumerStatus(w, req, snapshot, segments[1])
+	case len(segments) == 1 && segments[0] == "topics":
+		h.topics(w, req, snapshot)
+	case len(segments) == 2 && segments[0] == "topics":
+		h.topicDetail(w, req, snapshot, segments[1])
+	default:
+		h.writeError(w, req, http.StatusNotFound, "invalid request type")
+	}
+}
+
+// serveV3 handles the Burrow compatible paths of the form /v3/kafka/{cluster}/...
+func (h *handler) serveV3(w http.ResponseWriter, req *http.Request, snapshots []*kag.Snapshot, segments []string) {
+	if segments[0] != "kafka" {
+		h.writeError(w, req, http.StatusNotFound, "invalid request type")
+		return
+	}
+	if len(segments) == 1 {
+		h.clusters(w, req, snapshots)
+		return
+	}
+	snapshot := findCluster(snapshots, segments[1])
+	if snapshot == nil {
+		h.writeError(w, req, http.StatusNotFound, "cluster not found")
+		return
+	}
+
+	segments = segments[2:]
+	switch {
+	case len(segments) == 1 && segments[0] == "consumer":
+		h.consumers(w, req, snapshot)
+	case len(segments) == 2 && segments[0] == "consumer":
+		h.consumerDetail(w, req, snapshot, segments[1])
+	case len(segments) == 3 && segments[0] == "consumer" && (segments[2] == "lag" || segments[2] == "status"):
+		h.consumerStatus(w, req, snapshot, segments[1])
+	case len(segments) == 1 && segments[0] == "topic":
+		h.topics(w, req, snapshot)
+	case len(segments) == 2 && segments[0] == "topic":
+		h.topicDetail(w, req, snapshot, segments[1])
+	default:
+		h.writeError(w, req, http.StatusNotFound, "invalid request type")
+	}
+}
+
+func (h *handler) clusters(w http.ResponseWriter, req *http.Request, snapshots []*kag.Snapshot) {
+	clusters := make([]string, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		clusters = append(clusters, snapshot.Cluster)
+	}
+	writeJSON(w, http.StatusOK, clustersResponse{
+		envelope: h.envelope(req, "cluster list returned"),
+		Clusters: clusters,
+	})
+}
+
+func (h *handler) consumers(w http.ResponseWriter, req *http.Request, snapshot *kag.Snapshot) {
+	writeJSON(w, http.StatusOK, consumersResponse{
+		envelope:  h.envelope(req, "consumer list returned"),
+		Consumers: nonNil(snapshot.GroupIDs()),
+	})
+}
+
+func (h *handler) consumerDetail(w http.ResponseWriter, req *http.Request, snapshot *kag.Snapshot, groupID string) {
+	topics, ok := snapshot.Groups[groupID]
+	if !ok {
+		h.writeError(w, req, http.StatusNotFound, "consumer group not found")
+		return
+	}
+
+	timestamp := snapshot.Time.UnixNano() / 1e6
+	content := map[string][]ConsumerPartition{}
+	for topic, partitions := range topics {
+		items := make([]ConsumerPartition, len(snapshot.Topics[topic]))
+		for partition, offset := range partitions {
+			if int(partition) >= len(items) {
+				continue
+			}
+			lag := snapshot.Lag[groupID][topic][partition]
+			items[partition] = ConsumerPartition{
+				Offsets:    []ConsumerOffset{{Offset: offset, Timestamp: timestamp, Lag: lag}},
+				CurrentLag: lag,
+			}
+		}
+		content[topic] = items
+	}
+
+	writeJSON(w, http.StatusOK, consumerDetailResponse{
+		envelope: h.envelope(req, "consumer detail returned"),
+		Topics:   content,
+	})
+}
+
+func (h *handler) consumerStatus(w http.ResponseWriter, req *http.Request, snapshot *kag.Snapshot, groupID string) {
+	topics, ok := snapshot.Groups[groupID]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, consumerStatusResponse{
+			envelope: envelope{Error: true, Message: "consumer group not found", Request: h.request(req)},
+			Status: ConsumerGroupStatus{
+				Cluster:    snapshot.Cluster,
+				Group:      groupID,
+				Status:     statusNotFound,
+				Partitions: []*PartitionStatus{},
+			},
+		})
+		return
+	}
+
+	timestamp := snapshot.Time.UnixNano() / 1e6
+	status := ConsumerGroupStatus{
+		Cluster:    snapshot.Cluster,
+		Group:      groupID,
+		Status:     statusOK,
+		Complete:   1,
+		Partitions: []*PartitionStatus{},
+	}
+	for topic, partitions := range topics {
+		for partition, offset := range partitions {
+			lag, ok := snapshot.Lag[groupID][topic][partition]
+			if !ok {
+				continue
+			}
+
+			item := &PartitionStatus{
+				Topic:      topic,
+				Partition:  partition,
+				Status:     statusOK,
+				Start:      &ConsumerOffset{Offset: offset, Timestamp: timestamp, Lag: lag},
+				End:        &ConsumerOffset{Offset: offset, Timestamp: timestamp, Lag: lag},
+				CurrentLag: lag,
+				Complete:   1,
+			}
+			if v, ok := snapshot.TimeLag[groupID][topic][partition]; ok {
+				ms := int64(v / time.Millisecond)
+				item.TimeLagMS = &ms
+			}
+			if v, ok := snapshot.Forecast[groupID][topic][partition]; ok {
+				produceRate, consumeRate := v.ProduceRate, v.ConsumeRate
+				item.ProduceRate = &produceRate
+				item.ConsumeRate = &consumeRate
+				item.CatchUp = v.CatchUp.String()
+				if v.Never {
+					item.CatchUp = "never"
+				}
+			}
+			if v, ok := snapshot.Retention[groupID][topic][partition]; ok {
+				headroom := v.Headroom
+				item.RetentionHeadroom = &headroom
+				switch {
+				case v.Lost:
+					item.TimeToDataLoss = "lost"
+				case v.Never:
+					item.TimeToDataLoss = "never"
+				default:
+					item.TimeToDataLoss = v.TimeToLoss.String()
+				}
+				if v.Alert {
+					item.Status = statusWarn
+					status.Status = statusWarn
+				}
+			}
+			status.Partitions = append(status.Partitions, item)
+			status.TotalLag += uint64(lag)
+			if status.Maxlag == nil || lag > status.Maxlag.CurrentLag {
+				status.Maxlag = item
+			}
+		}
+	}
+	sort.Slice(status.Partitions, func(i, j int) bool {
+		a, b := status.Partitions[i], status.Partitions[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		return a.Partition < b.Partition
+	})
+	status.PartitionCount = len(status.Partitions)
+
+	writeJSON(w, http.StatusOK, consumerStatusResponse{
+		envelope: h.envelope(req, "consumer status returned"),
+		Status:   status,
+	})
+}
+
+func (h *handler) clusterHealth(w http.ResponseWriter, req *http.Request, snapshot *kag.Snapshot) {
+	writeJSON(w, http.StatusOK, clusterHealthResponse{
+		envelope: h.envelope(req, "cluster health returned"),
+		Health:   snapshot.ClusterHealth(),
+	})
+}
+
+func (h *handler) topics(w http.ResponseWriter, req *http.Request, snapshot *kag.Snapshot) {
+	writeJSON(w, http.StatusOK, topicsResponse{
+		envelope: h.envelope(req, "topic list returned"),
+		Topics:   nonNil(snapshot.TopicNames()),
+	})
+}
+
+func (h *handler) topicDetail(w http.ResponseWriter, req *http.Request, snapshot *kag.Snapshot, topic string) {
+	partitions, ok := snapshot.Topics[topic]
+	if !ok {
+		h.writeError(w, req, http.StatusNotFound, "topic not found")
+		return
+	}
+
+	offsets := make([]int64, len(partitions))
+	for partition, offset := range snapshot.Newest[topic] {
+		if int(partition) < len(offsets) {
+			offsets[partition] = offset
+		}
+	}
+
+	writeJSON(w, http.StatusOK, topicDetailResponse{
+		envelope: h.envelope(req, "topic offsets returned"),
+		Offsets:  offsets,
+	})
+}
+
+func (h *handler) request(req *http.Request) request {
+	return request{
+		URL:  req.URL.Path,
+		Host: h.host,
+	}
+}
+
+func (h *handler) envelope(req *http.Request, message string) envelope {
+	return envelope{
+		Message: message,
+		Request: h.request(req),
+	}
+}
+
+func (h *handler) writeError(w http.ResponseWriter, req *http.Request, code int, message string) {
+	writeJSON(w, code, envelope{
+		Error:   true,
+		Message: message,
+		Request: h.request(req),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func nonNil(ss []string) []string {
+	if ss == nil {
+		return []string{}
+	}
+	return ss
+}
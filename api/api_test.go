@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/savaki/kag"
+	"github.com/tj/assert"
+)
+
+type sourceFunc func() *kag.Snapshot
+
+func (fn sourceFunc) Snapshot() *kag.Snapshot {
+	return fn()
+}
+
+func TestHandler(t *testing.T) {
+	snapshot := &kag.Snapshot{
+		Cluster: "local",
+		Time:    time.Now(),
+		Topics: map[string][]kag.PartitionMetadata{
+			"topic": {{Partition: 0}, {Partition: 1}},
+		},
+		Newest: map[string]map[int32]int64{
+			"topic": {0: 10, 1: 20},
+		},
+		Groups: map[string]map[string]map[int32]int64{
+			"group": {"topic": {0: 8, 1: 20}},
+		},
+		Lag: map[string]map[string]map[int32]int64{
+			"group": {"topic": {0: 2, 1: 0}},
+		},
+	}
+	handler := New(sourceFunc(func() *kag.Snapshot { return snapshot }))
+
+	testCases := map[string]struct {
+		Path string
+		Code int
+		Key  string
+		Want string
+	}{
+		"clusters": {
+			Path: "/v1/clusters",
+			Code: http.StatusOK,
+			Key:  "clusters",
+			Want: `["local"]`,
+		},
+		"groups": {
+			Path: "/v1/groups",
+			Code: http.StatusOK,
+			Key:  "consumers",
+			Want: `["group"]`,
+		},
+		"topic": {
+			Path: "/v1/topics/topic",
+			Code: http.StatusOK,
+			Key:  "offsets",
+			Want: `[10,20]`,
+		},
+		"burrow topic": {
+			Path: "/v3/kafka/local/topic/topic",
+			Code: http.StatusOK,
+			Key:  "offsets",
+			Want: `[10,20]`,
+		},
+		"cluster health": {
+			Path: "/v1/cluster/health",
+			Code: http.StatusOK,
+			Key:  "health",
+			Want: `{"under_replicated":[],"offline":[],"non_preferred_leader":[],"brokers":[{"node_id":0,"partitions":0,"leaders":2}]}`,
+		},
+		"unknown group": {
+			Path: "/v1/groups/missing",
+			Code: http.StatusNotFound,
+			Key:  "error",
+			Want: `true`,
+		},
+		"unknown cluster": {
+			Path: "/v3/kafka/other/consumer",
+			Code: http.StatusNotFound,
+			Key:  "error",
+			Want: `true`,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.Path, nil))
+			assert.Equal(t, tc.Code, w.Code)
+
+			var body map[string]json.RawMessage
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tc.Want, string(body[tc.Key]))
+		})
+	}
+
+	t.Run("lag", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/groups/group/lag", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var body consumerStatusResponse
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, uint64(2), body.Status.TotalLag)
+		assert.Equal(t, 2, body.Status.PartitionCount)
+		assert.Equal(t, int32(0), body.Status.Maxlag.Partition)
+	})
+
+	t.Run("no snapshot", func(t *testing.T) {
+		handler := New(sourceFunc(func() *kag.Snapshot { return nil }))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/groups", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
+
+type multiSource []*kag.Snapshot
+
+func (m multiSource) Snapshot() *kag.Snapshot {
+	return m[0]
+}
+
+func (m multiSource) Snapshots() []*kag.Snapshot {
+	return m
+}
+
+func TestMultiSource(t *testing.T) {
+	handler := New(multiSource{
+		{
+			Cluster: "a",
+			Topics:  map[string][]kag.PartitionMetadata{"topic": {{Partition: 0}}},
+			Newest:  map[string]map[int32]int64{"topic": {0: 1}},
+		},
+		{
+			Cluster: "b",
+			Topics:  map[string][]kag.PartitionMetadata{"topic": {{Partition: 0}}},
+			Newest:  map[string]map[int32]int64{"topic": {0: 2}},
+		},
+	})
+
+	testCases := map[string]struct {
+		Path string
+		Code int
+		Key  string
+		Want string
+	}{
+		"clusters": {
+			Path: "/v1/clusters",
+			Code: http.StatusOK,
+			Key:  "clusters",
+			Want: `["a","b"]`,
+		},
+		"first cluster": {
+			Path: "/v1/topics/topic",
+			Code: http.StatusOK,
+			Key:  "offsets",
+			Want: `[1]`,
+		},
+		"selected cluster": {
+			Path: "/v1/topics/topic?cluster=b",
+			Code: http.StatusOK,
+			Key:  "offsets",
+			Want: `[2]`,
+		},
+		"unknown cluster": {
+			Path: "/v1/topics/topic?cluster=c",
+			Code: http.StatusNotFound,
+			Key:  "error",
+			Want: `true`,
+		},
+		"burrow cluster": {
+			Path: "/v3/kafka/b/topic/topic",
+			Code: http.StatusOK,
+			Key:  "offsets",
+			Want: `[2]`,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.Path, nil))
+			assert.Equal(t, tc.Code, w.Code)
+
+			var body map[string]json.RawMessage
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tc.Want, string(body[tc.Key]))
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/savaki/kag"
+)
+
+const (
+	// LivenessPath reports whether the polling goroutine is making progress
+	LivenessPath = "/healthz"
+
+	// ReadinessPath reports whether a scrape has completed recently
+	ReadinessPath = "/readyz"
+)
+
+// HealthSource provides the current kag.Health e.g. *kag.Monitor
+type HealthSource interface {
+	Health() kag.Health
+}
+
+type healthResponse struct {
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+	LastScrape   string `json:"last_scrape,omitempty"`
+	LastProgress string `json:"last_progress,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+	Standby      bool   `json:"standby,omitempty"`
+}
+
+type healthHandler struct {
+	source HealthSource
+}
+
+// NewHealth returns an http.Handler that serves the liveness and readiness
+// probes at LivenessPath and ReadinessPath.  Healthy probes return 200;
+// unhealthy probes return 503.
+func NewHealth(source HealthSource) http.Handler {
+	return &healthHandler{source: source}
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var (
+		health = h.source.Health()
+		now    = time.Now()
+		err    error
+	)
+
+	switch req.URL.Path {
+	case LivenessPath:
+		err = health.Live(now)
+	case ReadinessPath:
+		err = health.Ready(now)
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	resp := healthResponse{
+		Status:       "ok",
+		LastScrape:   formatTime(health.LastScrape),
+		LastProgress: formatTime(health.LastProgress),
+		Standby:      health.Standby,
+	}
+	if health.LastError != nil {
+		resp.LastError = health.LastError.Error()
+	}
+
+	code := http.StatusOK
+	if err != nil {
+		code = http.StatusServiceUnavailable
+		resp.Status = "unavailable"
+		resp.Error = err.Error()
+	}
+
+	writeJSON(w, code, resp)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savaki/kag"
+)
+
+const (
+	// HistoryPath serves queries over the history recorded by kag
+	HistoryPath = "/v1/history"
+
+	// GrafanaPath is the root of the Grafana JSON datasource
+	GrafanaPath = "/grafana/"
+)
+
+// defaultHistoryRange is the range queried when no from is given
+const defaultHistoryRange = time.Hour
+
+// targetSeparator separates the fields of a Grafana target e.g.
+// lag|group|topic|partition or newest|topic|partition
+const targetSeparator = "|"
+
+// HistorySource provides the recorded history e.g. *kag.Monitor
+type HistorySource interface {
+	// History returns the recorded history or nil if none is recorded
+	History() *kag.History
+}
+
+// ClusterHistorySource may optionally be implemented by a HistorySource that
+// records more than one cluster.  Requests select a cluster other than the
+// first with ?cluster=name.
+type ClusterHistorySource interface {
+	// ClusterHistory returns the history of the named cluster or nil if none
+	// is recorded
+	ClusterHistory(cluster string) *kag.History
+}
+
+type historyResponse struct {
+	envelope
+	Metric    string             `json:"metric"`
+	Group     string             `json:"group,omitempty"`
+	Topic     string             `json:"topic,omitempty"`
+	Partition *int32             `json:"partition,omitempty"`
+	Points    []kag.HistoryPoint `json:"points"`
+}
+
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type grafanaTarget struct {
+	Target string `json:"target"`
+	RefID  string `json:"refId"`
+}
+
+type grafanaQuery struct {
+	Range      grafanaRange    `json:"range"`
+	IntervalMs int64           `json:"intervalMs"`
+	Targets    []grafanaTarget `json:"targets"`
+}
+
+type grafanaSeries struct {
+	Target     string     `json:"target"`
+	Datapoints [][2]int64 `json:"datapoints"`
+}
+
+type historyHandler struct {
+	handler
+	source HistorySource
+}
+
+// NewHistory returns an http.Handler that serves
+//
+//	/v1/history?metric=lag&group=G&topic=T&partition=P&from=F&to=T&step=S
+//
+// where from and to are RFC3339 times or ms since epoch and step is a
+// duration e.g. 1m, along with a Grafana JSON datasource under /grafana/
+// whose targets take the form lag|group[|topic[|partition]],
+// committed|group[|topic[|partition]], newest|topic[|partition], or
+// oldest|topic[|partition].
+func NewHistory(source HistorySource) http.Handler {
+	host, _ := os.Hostname()
+	return &historyHandler{
+		handler: handler{host: host},
+		source:  source,
+	}
+}
+
+func (h *historyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	history := h.source.History()
+	if cluster := req.URL.Query().Get("cluster"); cluster != "" {
+		history = nil
+		if v, ok := h.source.(ClusterHistorySource); ok {
+			history = v.ClusterHistory(cluster)
+		}
+	}
+	if history == nil {
+		h.writeError(w, req, http.StatusNotFound, "history is not being recorded")
+		return
+	}
+
+	switch path := strings.TrimSuffix(req.URL.Path, "/"); path {
+	case HistoryPath:
+		h.query(w, req, history)
+	case strings.TrimSuffix(GrafanaPath, "/"):
+		writeJSON(w, http.StatusOK, h.envelope(req, "ok"))
+	case GrafanaPath + "search":
+		h.grafanaSearch(w, req, history)
+	case GrafanaPath + "query":
+		h.grafanaQuery(w, req, history)
+	case GrafanaPath + "annotations":
+		writeJSON(w, http.StatusOK, []struct{}{})
+	default:
+		h.writeError(w, req, http.StatusNotFound, "invalid request type")
+	}
+}
+
+func parseTime(s string, fallback time.Time) (time.Time, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func (h *historyHandler) query(w http.ResponseWriter, req *http.Request, history *kag.History) {
+	if req.Method != http.MethodGet {
+		h.writeError(w, req, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	values := req.URL.Query()
+	q := kag.HistoryQuery{
+		Metric:    values.Get("metric"),
+		Group:     values.Get("group"),
+		Topic:     values.Get("topic"),
+		Partition: -1,
+	}
+
+	var err error
+	if q.To, err = parseTime(values.Get("to"), time.Now()); err != nil {
+		h.writeError(w, req, http.StatusBadRequest, "invalid to")
+		return
+	}
+	if q.From, err = parseTime(values.Get("from"), q.To.Add(-defaultHistoryRange)); err != nil {
+		h.writeError(w, req, http.StatusBadRequest, "invalid from")
+		return
+	}
+	if v := values.Get("step"); v != "" {
+		if q.Step, err = time.ParseDuration(v); err != nil {
+			h.writeError(w, req, http.StatusBadRequest, "invalid step")
+			return
+		}
+	}
+	if v := values.Get("partition"); v != "" {
+		partition, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			h.writeError(w, req, http.StatusBadRequest, "invalid partition")
+			return
+		}
+		q.Partition = int32(partition)
+	}
+
+	points, err := history.Query(q)
+	if err != nil {
+		h.writeError(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := historyResponse{
+		envelope: h.envelope(req, "history returned"),
+		Metric:   q.Metric,
+		Group:    q.Group,
+		Topic:    q.Topic,
+		Points:   points,
+	}
+	if q.Partition >= 0 {
+		resp.Partition = &q.Partition
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parseTarget parses a Grafana target into a query
+func parseTarget(target string) (kag.HistoryQuery, error) {
+	q := kag.HistoryQuery{Partition: -1}
+
+	fields := strings.Split(target, targetSeparator)
+	q.Metric = fields[0]
+	fields = fields[1:]
+
+	if q.Metric == kag.MetricLag || q.Metric == kag.MetricCommitted {
+		if len(fields) > 0 {
+			q.Group, fields = fields[0], fields[1:]
+		}
+	}
+	if len(fields) > 0 {
+		q.Topic, fields = fields[0], fields[1:]
+	}
+	if len(fields) > 0 {
+		partition, err := strconv.ParseInt(fields[0], 10, 32)
+		if err != nil {
+			return kag.HistoryQuery{}, err
+		}
+		q.Partition, fields = int32(partition), fields[1:]
+	}
+	if len(fields) > 0 {
+		return kag.HistoryQuery{}, strconv.ErrSyntax
+	}
+
+	return q, q.Validate()
+}
+
+func (h *historyHandler) grafanaSearch(w http.ResponseWriter, req *http.Request, history *kag.History) {
+	var body grafanaTarget
+	json.NewDecoder(req.Body).Decode(&body)
+
+	sample, ok, err := history.Latest()
+	if err != nil {
+		h.writeError(w, req, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	targets := []string{}
+	add := func(fields ...string) {
+		target := strings.Join(fields, targetSeparator)
+		if strings.Contains(target, body.Target) {
+			targets = append(targets, target)
+		}
+	}
+	if ok {
+		for groupID, topics := range sample.Groups {
+			add(kag.MetricLag, groupID)
+			for topic := range topics {
+				add(kag.MetricLag, groupID, topic)
+				add(kag.MetricCommitted, groupID, topic)
+			}
+		}
+		for topic := range sample.Newest {
+			add(kag.MetricNewest, topic)
+			add(kag.MetricOldest, topic)
+		}
+	}
+	sort.Strings(targets)
+
+	writeJSON(w, http.StatusOK, targets)
+}
+
+func (h *historyHandler) grafanaQuery(w http.ResponseWriter, req *http.Request, history *kag.History) {
+	var body grafanaQuery
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		h.writeError(w, req, http.StatusBadRequest, "invalid query")
+		return
+	}
+
+	series := []grafanaSeries{}
+	for _, target := range body.Targets {
+		q, err := parseTarget(target.Target)
+		if err != nil {
+			h.writeError(w, req, http.StatusBadRequest, "invalid target, "+target.Target)
+			return
+		}
+		q.From = body.Range.From
+		q.To = body.Range.To
+		q.Step = time.Duration(body.IntervalMs) * time.Millisecond
+
+		points, err := history.Query(q)
+		if err != nil {
+			h.writeError(w, req, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		item := grafanaSeries{Target: target.Target, Datapoints: [][2]int64{}}
+		for _, p := range points {
+			item.Datapoints = append(item.Datapoints, [2]int64{p.Value, p.Time.UnixNano() / int64(time.Millisecond)})
+		}
+		series = append(series, item)
+	}
+
+	writeJSON(w, http.StatusOK, series)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savaki/kag"
+	"github.com/tj/assert"
+)
+
+type historyFunc func() *kag.History
+
+func (fn historyFunc) History() *kag.History {
+	return fn()
+}
+
+func TestHistoryHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kag-history")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	history, err := kag.OpenHistory(dir, kag.HistoryRetention{})
+	assert.Nil(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	assert.Nil(t, history.Append(&kag.Snapshot{
+		Time:   now,
+		Newest: map[string]map[int32]int64{"topic": {0: 10}},
+		Groups: map[string]map[string]map[int32]int64{"group": {"topic": {0: 8}}},
+		Lag:    map[string]map[string]map[int32]int64{"group": {"topic": {0: 2}}},
+	}))
+
+	handler := NewHistory(historyFunc(func() *kag.History { return history }))
+
+	t.Run("query", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, HistoryPath+"?metric=lag&group=group", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var body historyResponse
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Len(t, body.Points, 1)
+		assert.Equal(t, int64(2), body.Points[0].Value)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, HistoryPath+"?metric=lag", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("grafana search", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, GrafanaPath+"search", strings.NewReader(`{"target":"lag"}`)))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `["lag|group","lag|group|topic"]`, strings.TrimSpace(w.Body.String()))
+	})
+
+	t.Run("grafana query", func(t *testing.T) {
+		body := `{"range":{"from":"` + now.Add(-time.Hour).Format(time.RFC3339) + `","to":"` + now.Add(time.Minute).Format(time.RFC3339) + `"},` +
+			`"intervalMs":1000,"targets":[{"target":"lag|group|topic|0","refId":"A"}]}`
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, GrafanaPath+"query", strings.NewReader(body)))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var series []grafanaSeries
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &series))
+		assert.Len(t, series, 1)
+		assert.Equal(t, [][2]int64{{2, now.UnixNano() / int64(time.Millisecond)}}, series[0].Datapoints)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		handler := NewHistory(historyFunc(func() *kag.History { return nil }))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, HistoryPath, nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
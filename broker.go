@@ -7,13 +7,13 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
-	"github.com/savaki/franz"
+	"github.com/savaki/kag/internal/wire"
 	"golang.org/x/sync/errgroup"
 )
 
 type broker struct {
 	nodeID int32
-	conn   *franz.Conn
+	conn   *wire.Conn
 	w      io.Writer
 }
 
@@ -28,19 +28,40 @@ func (b *broker) debug(layout string, args ...interface{}) {
 	fmt.Fprintf(b.w, layout, args...)
 }
 
-// fetchTopicOffsets => offset -1 for newest, -2 for oldest
-func (b *broker) fetchTopicOffsets(metadata *franz.MetadataResponseV0, offset int64) (topicOffsets, error) {
-	input := makeListOffsetRequestV1(b.nodeID, metadata, offset)
+// listOffsets returns the offsets of the partitions led by this broker as of
+// offset, a timestamp in ms or -1 for newest and -2 for oldest
+func (b *broker) listOffsets(metadata *wire.MetadataResponse, offset int64) (wire.ListOffsetsResponse, error) {
+	input := makeListOffsetsRequest(b.nodeID, metadata, offset)
+	if len(input.Topics) == 0 {
+		return wire.ListOffsetsResponse{}, nil
+	}
+
+	if offset >= 0 {
+		// version 0 returns segment boundaries rather than the offset of the
+		// first record at or after the timestamp
+		if version, err := b.conn.Version(wire.ListOffsetsKey); err == nil && version < 1 {
+			return wire.ListOffsetsResponse{}, errors.Errorf("broker, %v, does not support offsets by timestamp; requires kafka 0.10.1", b.conn.RemoteAddr())
+		}
+	}
+
+	var resp wire.ListOffsetsResponse
+	if err := request(b.conn, wire.ListOffsetsKey, input.Encode, resp.Decode); err != nil {
+		return wire.ListOffsetsResponse{}, errors.Wrapf(err, "unable to list offsets for broker, %v", b.conn.RemoteAddr())
+	}
+	return resp, nil
+}
 
+// fetchTopicOffsets => offset -1 for newest, -2 for oldest
+func (b *broker) fetchTopicOffsets(metadata *wire.MetadataResponse, offset int64) (topicOffsets, error) {
 	b.debug("fetching topic offsets for broker, %v", b.nodeID)
-	resp, err := b.conn.ListOffsetsV1(input)
+	resp, err := b.listOffsets(metadata, offset)
 	if err != nil {
-		return nil, errors.Wrapf(err, "unable to list offsets for broker, %v", b.conn.RemoteAddr())
+		return nil, err
 	}
 
 	offsets := topicOffsets{}
-	for _, t := range resp.Responses {
-		for _, p := range t.PartitionResponses {
+	for _, t := range resp.Topics {
+		for _, p := range t.Partitions {
 			offsets.add(t.Topic, p.Partition, p.Offset)
 		}
 	}
@@ -48,9 +69,11 @@ func (b *broker) fetchTopicOffsets(metadata *franz.MetadataResponseV0, offset in
 	return offsets, nil
 }
 
-func (b *broker) fetchGroupOffsets(topics []franz.OffsetFetchRequestV3Topic) (groupOffsets, error) {
-	resp, err := b.conn.ListGroupsV1(franz.ListGroupsRequestV1{})
-	if err != nil {
+// fetchGroupOffsets fetches the offsets of the groups coordinated by the
+// broker for which include returns true
+func (b *broker) fetchGroupOffsets(topics []wire.OffsetFetchTopic, include func(groupID string) bool) (groupOffsets, error) {
+	var resp wire.ListGroupsResponse
+	if err := request(b.conn, wire.ListGroupsKey, nil, resp.Decode); err != nil {
 		return nil, errors.Wrapf(err, "unable to list groups for broker, %v", b.conn.RemoteAddr())
 	}
 
@@ -58,17 +81,22 @@ func (b *broker) fetchGroupOffsets(topics []franz.OffsetFetchRequestV3Topic) (gr
 
 	b.debug("fetching group offsets for broker, %v", b.nodeID)
 	for _, group := range resp.Groups {
-		offsetFetch, err := b.conn.OffsetFetchV3(franz.OffsetFetchRequestV3{
+		if !include(group.GroupID) {
+			continue
+		}
+
+		var offsetFetch wire.OffsetFetchResponse
+		req := wire.OffsetFetchRequest{
 			GroupID: group.GroupID,
 			Topics:  topics,
-		})
-		if err != nil {
+		}
+		if err := request(b.conn, wire.OffsetFetchKey, req.Encode, offsetFetch.Decode); err != nil {
 			return nil, errors.Wrapf(err, "unable to fetch offset for consumer group, %v", group.GroupID)
 		}
 
-		for _, r := range removeEmpty(offsetFetch.Responses) {
-			for _, pr := range r.PartitionResponses {
-				offsets.add(group.GroupID, r.Topic, pr.Partition, pr.Offset)
+		for _, r := range removeEmpty(offsetFetch.Topics) {
+			for _, p := range r.Partitions {
+				offsets.add(group.GroupID, r.Topic, p.Partition, p.Offset)
 			}
 		}
 	}
@@ -80,7 +108,7 @@ func (b *broker) Close() error {
 	return b.conn.Close()
 }
 
-func newBroker(nodeID int32, conn *franz.Conn, debug io.Writer) *broker {
+func newBroker(nodeID int32, conn *wire.Conn, debug io.Writer) *broker {
 	return &broker{
 		nodeID: nodeID,
 		conn:   conn,
@@ -90,7 +118,7 @@ func newBroker(nodeID int32, conn *franz.Conn, debug io.Writer) *broker {
 
 type brokerArray []*broker
 
-func (b brokerArray) fetchTopicOffsets(ctx context.Context, metadata *franz.MetadataResponseV0, offset int64) (topicOffsets, error) {
+func (b brokerArray) fetchTopicOffsets(ctx context.Context, metadata *wire.MetadataResponse, offset int64) (topicOffsets, error) {
 	results := make(chan topicOffsets, len(b))
 
 	group := &errgroup.Group{}
@@ -104,7 +132,9 @@ func (b brokerArray) fetchTopicOffsets(ctx context.Context, metadata *franz.Meta
 			return err
 		})
 	}
-	group.Wait()
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
 	close(results)
 
 	all := topicOffsets{}
@@ -119,7 +149,7 @@ func (b brokerArray) fetchTopicOffsets(ctx context.Context, metadata *franz.Meta
 	return all, nil
 }
 
-func (b brokerArray) fetchGroupOffsets(ctx context.Context, metadata *franz.MetadataResponseV0) (groupOffsets, error) {
+func (b brokerArray) fetchGroupOffsets(ctx context.Context, metadata *wire.MetadataResponse, include func(groupID string) bool) (groupOffsets, error) {
 	results := make(chan groupOffsets, len(b))
 
 	topics := makeTopics(metadata.Topics)
@@ -128,14 +158,16 @@ func (b brokerArray) fetchGroupOffsets(ctx context.Context, metadata *franz.Meta
 	for _, item := range b {
 		broker := item
 		group.Go(func() error {
-			offsets, err := broker.fetchGroupOffsets(topics)
+			offsets, err := broker.fetchGroupOffsets(topics, include)
 			if err == nil {
 				results <- offsets
 			}
 			return err
 		})
 	}
-	group.Wait()
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
 	close(results)
 
 	all := groupOffsets{}
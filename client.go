@@ -0,0 +1,347 @@
+package kag
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/savaki/franz"
+	"github.com/savaki/kag/internal/wire"
+	"golang.org/x/sync/errgroup"
+)
+
+// Client performs one-shot requests against a kafka cluster.  Unlike Monitor,
+// Client holds no connections between calls.
+type Client struct {
+	config          Config
+	discovered      *discovered
+	timeLagWarnings *timeLagWarnings
+}
+
+// NewClient returns a Client for the cluster described by config
+func NewClient(config Config) *Client {
+	config = applyDefaults(config)
+
+	return &Client{
+		config:          config,
+		discovered:      &discovered{},
+		timeLagWarnings: &timeLagWarnings{},
+	}
+}
+
+func applyDefaults(config Config) Config {
+	if config.Observer == nil {
+		config.Observer = Nop
+	}
+	if config.Interval == 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.Cluster == "" {
+		config.Cluster = DefaultCluster
+	}
+	if config.FetchMaxBytes == 0 {
+		config.FetchMaxBytes = DefaultFetchMaxBytes
+	}
+	if config.DiscoveryInterval == 0 {
+		config.DiscoveryInterval = DefaultDiscoveryInterval
+	}
+	return config
+}
+
+func (c *Client) debug(format string, args ...interface{}) {
+	if c.config.Debug == nil {
+		return
+	}
+
+	fmt.Fprintf(c.config.Debug, format, args...)
+	if !strings.HasSuffix(format, "\n") {
+		io.WriteString(c.config.Debug, "\n")
+	}
+}
+
+func (c *Client) connectAny(ctx context.Context) (*wire.Conn, error) {
+	var last error
+	for _, broker := range c.bootstrap(ctx) {
+		conn, err := c.dial(ctx, broker)
+		if err == nil {
+			c.debug("connected to broker, %v", broker)
+			return conn, nil
+		}
+		c.debug("unable to connect to broker, %v: %v", broker, err)
+		last = err
+	}
+	c.rediscover()
+
+	if last != nil {
+		return nil, errors.Wrapf(last, "unable to connect to any broker")
+	}
+	return nil, errors.Errorf("unable to connect to any broker")
+}
+
+// session holds a connection to every broker in the cluster
+type session struct {
+	client     *Client
+	conn       *wire.Conn
+	brokers    brokerArray
+	brokerList []wire.MetadataBroker
+
+	// shard restricts the scrape to a share of the cluster when
+	// Config.ShardGroup is set
+	shard *shard
+
+	// fetchers and timestamps are only used when Config.TimeLag is enabled
+	mutex      sync.Mutex
+	fetchers   map[int32]*wire.Conn
+	timestamps timestampCache
+
+	// retired holds the requests sent over fetchers closed since the stats
+	// were last read
+	retired map[int32]wire.Stats
+}
+
+func (c *Client) openSession(ctx context.Context) (*session, error) {
+	conn, err := c.connectAny(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := requestMetadata(conn)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "unable to retrieve initial metadata")
+	}
+
+	s := &session{
+		client: c,
+		conn:   conn,
+	}
+
+	for _, broker := range metadata.Brokers {
+		addr := fmt.Sprintf("%v:%v", broker.Host, broker.Port)
+		c.debug("starting monitoring for broker, %v", addr)
+		conn, err := c.dial(ctx, addr)
+		if err != nil {
+			s.Close()
+			return nil, errors.Wrapf(err, "unable to connect to broker, %v", addr)
+		}
+		s.brokers = append(s.brokers, newBroker(broker.NodeID, conn, c.config.Debug))
+	}
+
+	s.brokerList = metadata.Brokers
+	sort.Slice(s.brokerList, func(i, j int) bool { return s.brokerList[i].NodeID < s.brokerList[j].NodeID })
+
+	return s, nil
+}
+
+// scrape retrieves the newest, oldest, and committed offsets across the cluster
+func (s *session) scrape(ctx context.Context) (*Snapshot, error) {
+	s.client.debug("retrieving metadata")
+	metadata, err := requestMetadata(s.conn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to retrieve metadata")
+	}
+
+	found := metadata.Brokers
+	sort.Slice(found, func(i, j int) bool { return found[i].NodeID < found[j].NodeID })
+	if !reflect.DeepEqual(s.brokerList, found) {
+		return nil, errors.Errorf("detected change in broker list")
+	}
+
+	// when sharding by topic, only the offsets of owned topics are fetched
+	owned := s.shard.filterMetadata(metadata)
+
+	s.client.debug("fetching consumer group offsets")
+	groupOffsets, err := s.brokers.fetchGroupOffsets(ctx, owned, s.shard.ownsGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	s.client.debug("fetching newest topic offsets")
+	newest, err := s.brokers.fetchTopicOffsets(ctx, owned, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	s.client.debug("fetching oldest topic offsets")
+	oldest, err := s.brokers.fetchTopicOffsets(ctx, owned, -2)
+	if err != nil {
+		return nil, err
+	}
+
+	s.client.config.filter(newest, oldest, groupOffsets)
+
+	snapshot := makeSnapshot(s.client.config.Cluster, metadata, newest, oldest, groupOffsets)
+	if s.client.config.TimeLag {
+		s.client.debug("reading time lag")
+		snapshot.TimeLag = s.readTimeLag(ctx, snapshot)
+	}
+
+	return snapshot, nil
+}
+
+// offsetsAt returns, for each topic partition, the offset of the first record
+// whose timestamp is at or after t (ms since epoch) or -1 if there is none.
+// The sentinels -1 (newest) and -2 (oldest) may also be used for t.  Unlike a
+// scrape, offsetsAt fails if the offsets of any partition cannot be listed.
+func (s *session) offsetsAt(ctx context.Context, t int64) (topicOffsets, error) {
+	metadata, err := requestMetadata(s.conn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to retrieve metadata")
+	}
+
+	results := make(chan wire.ListOffsetsResponse, len(s.brokers))
+
+	group, _ := errgroup.WithContext(ctx)
+	for _, item := range s.brokers {
+		broker := item
+		group.Go(func() error {
+			resp, err := broker.listOffsets(metadata, t)
+			if err == nil {
+				results <- resp
+			}
+			return err
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	close(results)
+
+	offsets := topicOffsets{}
+	for resp := range results {
+		for _, topic := range resp.Topics {
+			for _, p := range topic.Partitions {
+				if p.ErrorCode != 0 {
+					return nil, errors.Wrapf(franz.Error(p.ErrorCode), "unable to list offsets for %v/%v", topic.Topic, p.Partition)
+				}
+				offsets.add(topic.Topic, p.Partition, p.Offset)
+			}
+		}
+	}
+	return offsets, nil
+}
+
+func (s *session) Close() error {
+	for nodeID := range s.fetchers {
+		s.closeFetcher(nodeID)
+	}
+	s.brokers.Close()
+	return s.conn.Close()
+}
+
+// Scrape performs a single scrape of the cluster
+func (c *Client) Scrape(ctx context.Context) (*Snapshot, error) {
+	s, err := c.openSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	return s.scrape(ctx)
+}
+
+// GroupMember describes a single member of a consumer group
+type GroupMember struct {
+	MemberID   string `json:"member_id"`
+	ClientID   string `json:"client_id"`
+	ClientHost string `json:"client_host"`
+
+	// Assignments holds the partitions assigned to the member by topic; only
+	// populated for groups using the consumer protocol
+	Assignments map[string][]int32 `json:"assignments,omitempty"`
+}
+
+// GroupDescription describes the state and membership of a consumer group
+type GroupDescription struct {
+	GroupID      string         `json:"group"`
+	State        string         `json:"state"`
+	ProtocolType string         `json:"protocol_type"`
+	Protocol     string         `json:"protocol"`
+	Coordinator  BrokerMetadata `json:"coordinator"`
+	Members      []GroupMember  `json:"members"`
+}
+
+// findCoordinator returns the broker that coordinates the consumer group
+func (c *Client) findCoordinator(ctx context.Context, groupID string) (BrokerMetadata, error) {
+	conn, err := c.connectAny(ctx)
+	if err != nil {
+		return BrokerMetadata{}, err
+	}
+	defer conn.Close()
+
+	var resp wire.FindCoordinatorResponse
+	req := wire.FindCoordinatorRequest{GroupID: groupID}
+	if err := request(conn, wire.FindCoordinatorKey, req.Encode, resp.Decode); err != nil {
+		return BrokerMetadata{}, errors.Wrapf(err, "unable to find coordinator for consumer group, %v", groupID)
+	}
+	if resp.ErrorCode != 0 {
+		return BrokerMetadata{}, errors.Wrapf(franz.Error(resp.ErrorCode), "unable to find coordinator for consumer group, %v", groupID)
+	}
+
+	return BrokerMetadata{
+		NodeID: resp.NodeID,
+		Host:   resp.Host,
+		Port:   resp.Port,
+	}, nil
+}
+
+// DescribeGroup retrieves the state and membership of a consumer group from
+// the group's coordinator
+func (c *Client) DescribeGroup(ctx context.Context, groupID string) (GroupDescription, error) {
+	broker, err := c.findCoordinator(ctx, groupID)
+	if err != nil {
+		return GroupDescription{}, err
+	}
+
+	addr := fmt.Sprintf("%v:%v", broker.Host, broker.Port)
+	coordinator, err := c.dial(ctx, addr)
+	if err != nil {
+		return GroupDescription{}, errors.Wrapf(err, "unable to connect to coordinator, %v", addr)
+	}
+	defer coordinator.Close()
+
+	var groups wire.DescribeGroupsResponse
+	req := wire.DescribeGroupsRequest{GroupIDs: []string{groupID}}
+	if err := request(coordinator, wire.DescribeGroupsKey, req.Encode, groups.Decode); err != nil {
+		return GroupDescription{}, errors.Wrapf(err, "unable to describe consumer group, %v", groupID)
+	}
+	if len(groups.Groups) != 1 {
+		return GroupDescription{}, errors.Errorf("unable to describe consumer group, %v", groupID)
+	}
+
+	group := groups.Groups[0]
+	if group.ErrorCode != 0 {
+		return GroupDescription{}, errors.Wrapf(franz.Error(group.ErrorCode), "unable to describe consumer group, %v", groupID)
+	}
+
+	description := GroupDescription{
+		GroupID:      group.GroupID,
+		State:        group.State,
+		ProtocolType: group.ProtocolType,
+		Protocol:     group.Protocol,
+		Coordinator:  broker,
+	}
+	for _, member := range group.Members {
+		item := GroupMember{
+			MemberID:   member.MemberID,
+			ClientID:   member.ClientID,
+			ClientHost: member.ClientHost,
+		}
+		if group.ProtocolType == "consumer" {
+			if item.Assignments, err = decodeMemberAssignment(member.Assignment); err != nil {
+				return GroupDescription{}, errors.Wrapf(err, "unable to decode assignment of member, %v", member.MemberID)
+			}
+		}
+		description.Members = append(description.Members, item)
+	}
+	sort.Slice(description.Members, func(i, j int) bool {
+		return description.Members[i].MemberID < description.Members[j].MemberID
+	})
+
+	return description, nil
+}
@@ -0,0 +1,103 @@
+package kag
+
+import (
+	"sort"
+)
+
+// TopicPartition identifies a single partition of a topic
+type TopicPartition struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+}
+
+// BrokerLoad holds the number of partitions hosted and led by a broker
+type BrokerLoad struct {
+	NodeID     int32 `json:"node_id"`
+	Partitions int   `json:"partitions"`
+	Leaders    int   `json:"leaders"`
+}
+
+// ClusterHealth summarizes the replication state of the cluster as reported
+// by the topic metadata
+type ClusterHealth struct {
+	// UnderReplicated holds the partitions with fewer in sync replicas than
+	// assigned replicas
+	UnderReplicated []TopicPartition `json:"under_replicated"`
+
+	// Offline holds the partitions that have no leader
+	Offline []TopicPartition `json:"offline"`
+
+	// NonPreferredLeader holds the partitions led by a broker other than the
+	// first assigned replica
+	NonPreferredLeader []TopicPartition `json:"non_preferred_leader"`
+
+	// Brokers holds the partition and leader counts of each broker sorted by
+	// NodeID
+	Brokers []BrokerLoad `json:"brokers"`
+}
+
+// ClusterObserver may optionally be implemented by an Observer to receive
+// the health of the cluster after each scrape
+type ClusterObserver interface {
+	ObserveClusterHealth(health ClusterHealth)
+}
+
+// ClusterHealth computes the replication state of the cluster from the
+// partition metadata of the snapshot
+func (s *Snapshot) ClusterHealth() ClusterHealth {
+	health := ClusterHealth{
+		UnderReplicated:    []TopicPartition{},
+		Offline:            []TopicPartition{},
+		NonPreferredLeader: []TopicPartition{},
+		Brokers:            []BrokerLoad{},
+	}
+
+	loads := map[int32]*BrokerLoad{}
+	load := func(nodeID int32) *BrokerLoad {
+		v, ok := loads[nodeID]
+		if !ok {
+			v = &BrokerLoad{NodeID: nodeID}
+			loads[nodeID] = v
+		}
+		return v
+	}
+	for _, broker := range s.Brokers {
+		load(broker.NodeID)
+	}
+
+	for _, topic := range s.TopicNames() {
+		for _, p := range s.Topics[topic] {
+			tp := TopicPartition{Topic: topic, Partition: p.Partition}
+
+			if len(p.Isr) < len(p.Replicas) {
+				health.UnderReplicated = append(health.UnderReplicated, tp)
+			}
+			if p.Leader < 0 {
+				health.Offline = append(health.Offline, tp)
+			} else {
+				load(p.Leader).Leaders++
+				if len(p.Replicas) > 0 && p.Replicas[0] != p.Leader {
+					health.NonPreferredLeader = append(health.NonPreferredLeader, tp)
+				}
+			}
+			for _, replica := range p.Replicas {
+				load(replica).Partitions++
+			}
+		}
+	}
+
+	for _, v := range loads {
+		health.Brokers = append(health.Brokers, *v)
+	}
+	sort.Slice(health.Brokers, func(i, j int) bool { return health.Brokers[i].NodeID < health.Brokers[j].NodeID })
+
+	return health
+}
+
+// publishClusterHealth publishes the health of the cluster to the observer if
+// it implements ClusterObserver
+func publishClusterHealth(observer Observer, snapshot *Snapshot) {
+	if v, ok := observer.(ClusterObserver); ok {
+		v.ObserveClusterHealth(snapshot.ClusterHealth())
+	}
+}
@@ -0,0 +1,32 @@
+package kag
+
+import (
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestClusterHealth(t *testing.T) {
+	snapshot := &Snapshot{
+		Brokers: []BrokerMetadata{{NodeID: 1}, {NodeID: 2}, {NodeID: 3}},
+		Topics: map[string][]PartitionMetadata{
+			"a": {
+				{Partition: 0, Leader: 1, Replicas: []int32{1, 2}, Isr: []int32{1, 2}},
+				{Partition: 1, Leader: 1, Replicas: []int32{2, 1}, Isr: []int32{1}},
+			},
+			"b": {
+				{Partition: 0, Leader: -1, Replicas: []int32{2}, Isr: []int32{}},
+			},
+		},
+	}
+
+	health := snapshot.ClusterHealth()
+	assert.Equal(t, []TopicPartition{{Topic: "a", Partition: 1}, {Topic: "b", Partition: 0}}, health.UnderReplicated)
+	assert.Equal(t, []TopicPartition{{Topic: "b", Partition: 0}}, health.Offline)
+	assert.Equal(t, []TopicPartition{{Topic: "a", Partition: 1}}, health.NonPreferredLeader)
+	assert.Equal(t, []BrokerLoad{
+		{NodeID: 1, Partitions: 2, Leaders: 2},
+		{NodeID: 2, Partitions: 3, Leaders: 0},
+		{NodeID: 3, Partitions: 0, Leaders: 0},
+	}, health.Brokers)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/savaki/kag"
+)
+
+// fleet runs a kag.Monitor per configured cluster and applies configuration
+// changes to the running monitors
+type fleet struct {
+	mutex    sync.Mutex
+	names    []string
+	clusters map[string]*fleetMember
+	certs    tlsCache
+
+	// config holds the configuration last applied
+	config *fileConfig
+}
+
+type fleetMember struct {
+	monitor    *kag.Monitor
+	observer   kag.Observer
+	history    *kag.History
+	historyDir string
+	retention  kag.HistoryRetention
+	recorder   *kag.Recorder
+	recordFile string
+}
+
+// close stops the monitor and closes the observers and history of the member
+func (m *fleetMember) close() {
+	m.monitor.Close()
+	closeObserver(m.observer)
+	if m.recorder != nil {
+		m.recorder.Close()
+	}
+	if m.history != nil {
+		m.history.Close()
+	}
+}
+
+// scrapeGrace returns how long a scrape already running when the config of
+// a monitor changes may continue to publish to the previous observer
+func scrapeGrace(config kag.Config) time.Duration {
+	if config.Interval == 0 {
+		return kag.DefaultInterval
+	}
+	return config.Interval
+}
+
+// retire closes the observer, recorder, and history replaced by a config
+// change once the scrapes that began before the change have had time to
+// publish.  recorder and history may be nil.
+func retire(observer kag.Observer, recorder *kag.Recorder, history *kag.History, grace time.Duration) {
+	time.AfterFunc(grace, func() {
+		closeObserver(observer)
+		if recorder != nil {
+			recorder.Close()
+		}
+		if history != nil {
+			history.Close()
+		}
+	})
+}
+
+func newFleet() *fleet {
+	return &fleet{
+		clusters: map[string]*fleetMember{},
+		certs:    tlsCache{},
+	}
+}
+
+// apply starts, reconfigures, and stops monitors to match the configuration.
+// If the configuration can't be applied, the running monitors are left
+// unchanged.
+func (f *fleet) apply(c *fileConfig) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.update(c)
+}
+
+// reloadCerts re-reads the tls certificates whose reload interval has
+// elapsed and, if any changed, reconnects the monitors using them
+func (f *fleet) reloadCerts() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	changed, err := f.certs.reload(time.Now())
+	if changed && f.config != nil {
+		if err := f.update(f.config); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// update applies the configuration; the caller must hold the mutex
+func (f *fleet) update(c *fileConfig) error {
+	// certificates no longer used by any cluster are dropped
+	certs := tlsCache{}
+	for _, cluster := range c.Clusters {
+		if v, ok := f.certs[cluster.TLS]; ok {
+			certs[cluster.TLS] = v
+		}
+	}
+
+	type pending struct {
+		config kag.Config
+		member *fleetMember
+	}
+
+	var items []pending
+	abort := func(err error) error {
+		for _, item := range items {
+			closeObserver(item.member.observer)
+			v, ok := f.clusters[item.config.Cluster]
+			if item.member.recorder != nil && (!ok || v.recorder != item.member.recorder) {
+				item.member.recorder.Close()
+			}
+			if item.member.history != nil && (!ok || v.history != item.member.history) {
+				item.member.history.Close()
+			}
+		}
+		return err
+	}
+
+	for _, cluster := range c.Clusters {
+		observer, err := c.newObserver(cluster.Name)
+		if err != nil {
+			return abort(err)
+		}
+		member := &fleetMember{
+			observer:   observer,
+			historyDir: cluster.HistoryDir,
+			retention:  c.History.retention(),
+			recordFile: cluster.RecordFile,
+		}
+		items = append(items, pending{config: kag.Config{Cluster: cluster.Name}, member: member})
+
+		if member.recordFile != "" {
+			if v, ok := f.clusters[cluster.Name]; ok && v.recordFile == member.recordFile {
+				member.recorder = v.recorder
+			} else if member.recorder, err = kag.OpenRecorder(member.recordFile); err != nil {
+				return abort(err)
+			}
+			observer = kag.MultiObserver(observer, member.recorder)
+		}
+
+		config, err := c.kagConfig(cluster, observer, certs)
+		if err != nil {
+			return abort(err)
+		}
+
+		if member.historyDir != "" {
+			if v, ok := f.clusters[cluster.Name]; ok && v.historyDir == member.historyDir {
+				// a changed retention is applied to the open history once
+				// the config has been validated
+				member.history = v.history
+			} else if member.history, err = kag.OpenHistory(member.historyDir, member.retention); err != nil {
+				return abort(err)
+			}
+		}
+		config.History = member.history
+		items[len(items)-1].config = config
+	}
+
+	var names []string
+	clusters := map[string]*fleetMember{}
+	for _, item := range items {
+		name := item.config.Cluster
+		names = append(names, name)
+		clusters[name] = item.member
+
+		existing, ok := f.clusters[name]
+		if !ok {
+			item.member.monitor = kag.New(item.config)
+			continue
+		}
+
+		item.member.monitor = existing.monitor
+		if err := existing.monitor.SetConfig(item.config); err != nil {
+			return abort(err)
+		}
+		recorder := existing.recorder
+		if recorder == item.member.recorder {
+			recorder = nil
+		}
+		history := existing.history
+		if history == item.member.history {
+			history = nil
+			if item.member.history != nil && existing.retention != item.member.retention {
+				if err := item.member.history.SetRetention(item.member.retention); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+			}
+		}
+		retire(existing.observer, recorder, history, scrapeGrace(item.config))
+	}
+
+	for name, member := range f.clusters {
+		if _, ok := clusters[name]; !ok {
+			member.close()
+		}
+	}
+
+	f.names = names
+	f.clusters = clusters
+	f.certs = certs
+	f.config = c
+	return nil
+}
+
+// Close stops every monitor
+func (f *fleet) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, member := range f.clusters {
+		member.close()
+	}
+	f.names = nil
+	f.clusters = map[string]*fleetMember{}
+	return nil
+}
+
+// members returns the members in configuration order
+func (f *fleet) members() []*fleetMember {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	members := make([]*fleetMember, 0, len(f.names))
+	for _, name := range f.names {
+		members = append(members, f.clusters[name])
+	}
+	return members
+}
+
+// Snapshot returns the most recent scrape of the first cluster
+func (f *fleet) Snapshot() *kag.Snapshot {
+	if members := f.members(); len(members) > 0 {
+		return members[0].monitor.Snapshot()
+	}
+	return nil
+}
+
+// Snapshots returns the most recent scrape of each cluster
+func (f *fleet) Snapshots() []*kag.Snapshot {
+	var snapshots []*kag.Snapshot
+	for _, member := range f.members() {
+		if snapshot := member.monitor.Snapshot(); snapshot != nil {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots
+}
+
+// Health returns the health of the least healthy cluster
+func (f *fleet) Health() kag.Health {
+	members := f.members()
+	if len(members) == 0 {
+		return kag.Health{}
+	}
+
+	now := time.Now()
+	health := members[0].monitor.Health()
+	for _, member := range members {
+		v := member.monitor.Health()
+		if v.Live(now) != nil {
+			return v
+		}
+		if v.Ready(now) != nil && health.Ready(now) == nil {
+			health = v
+		}
+	}
+	return health
+}
+
+// History returns the history of the first cluster
+func (f *fleet) History() *kag.History {
+	if members := f.members(); len(members) > 0 {
+		return members[0].history
+	}
+	return nil
+}
+
+// ClusterHistory returns the history of the named cluster
+func (f *fleet) ClusterHistory(cluster string) *kag.History {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if member, ok := f.clusters[cluster]; ok {
+		return member.history
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/savaki/kag/api"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// health probes the liveness or readiness endpoint of a kag instance
+// listening on --http-addr, or the http_addr of --config, e.g. for use as a
+// container HEALTHCHECK
+func health(_ *cli.Context) error {
+	addr := opts.HTTPAddr
+	if opts.Config != "" {
+		config, err := readConfigFile(opts.Config)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 2)
+		}
+		addr = config.HTTPAddr
+	}
+	if addr == "" {
+		return cli.NewExitError("--http-addr, or http_addr in --config, must be set to probe health", 2)
+	}
+
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+
+	path := api.ReadinessPath
+	if opts.Health.Live {
+		path = api.LivenessPath
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Health.Timeout)
+	defer cancel()
+
+	u := "http://" + addr + path
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	req = req.WithContext(ctx)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("unable to probe %v: %v", u, err), 1)
+	}
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return cli.NewExitError(strings.TrimSpace(string(data)), 1)
+	}
+
+	fmt.Println(strings.TrimSpace(string(data)))
+	return nil
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/savaki/kag"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var inspectCommands = []cli.Command{
+	{
+		Name:   "lag",
+		Usage:  "scrape the cluster once and print consumer lag",
+		Action: lagAction,
+		Before: checkOutput,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:        "group",
+				Usage:       "optional consumer group to restrict output to",
+				Destination: &opts.Lag.Group,
+			},
+			cli.StringFlag{
+				Name:        "topic",
+				Usage:       "optional topic to restrict output to",
+				Destination: &opts.Lag.Topic,
+			},
+			outputFlag,
+		},
+	},
+	{
+		Name:  "groups",
+		Usage: "inspect consumer groups",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "list consumer groups with committed offsets",
+				Action: groupsListAction,
+				Before: checkOutput,
+				Flags:  []cli.Flag{outputFlag},
+			},
+			{
+				Name:      "describe",
+				Usage:     "describe the state, members, and offsets of a consumer group",
+				ArgsUsage: "GROUP",
+				Action:    groupsDescribeAction,
+				Before:    checkOutput,
+				Flags:     []cli.Flag{outputFlag},
+			},
+		},
+	},
+	{
+		Name:   "cluster",
+		Usage:  "print under replicated, offline, and non-preferred leader partitions and the load of each broker",
+		Action: clusterAction,
+		Before: checkOutput,
+		Flags:  []cli.Flag{outputFlag},
+	},
+	{
+		Name:  "topics",
+		Usage: "inspect topics",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "list topics",
+				Action: topicsListAction,
+				Before: checkOutput,
+				Flags:  []cli.Flag{outputFlag},
+			},
+			{
+				Name:      "describe",
+				Usage:     "describe the partitions, leaders, replicas, and isr of a topic",
+				ArgsUsage: "TOPIC",
+				Action:    topicsDescribeAction,
+				Before:    checkOutput,
+				Flags:     []cli.Flag{outputFlag},
+			},
+		},
+	},
+}
+
+func scrape() (*kag.Snapshot, error) {
+	config, err := newConfig(kag.Nop)
+	if err != nil {
+		return nil, err
+	}
+	return kag.NewClient(config).Scrape(context.Background())
+}
+
+func sortedPartitions(partitions map[int32]int64) []int32 {
+	var ids []int32
+	for id := range partitions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func sortedTopics(topics map[string]map[int32]int64) []string {
+	var names []string
+	for name := range topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type partitionLag struct {
+	Group     string `json:"group"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Committed int64  `json:"committed"`
+	Newest    int64  `json:"newest"`
+	Lag       int64  `json:"lag"`
+	TimeLagMS *int64 `json:"time_lag_ms,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+}
+
+func makePartitionLags(snapshot *kag.Snapshot, groupID, topicFilter string) []partitionLag {
+	var items []partitionLag
+	topics := snapshot.Lag[groupID]
+	for _, topic := range sortedTopics(topics) {
+		if topicFilter != "" && topic != topicFilter {
+			continue
+		}
+		for _, partition := range sortedPartitions(topics[topic]) {
+			item := partitionLag{
+				Group:     groupID,
+				Topic:     topic,
+				Partition: partition,
+				Committed: snapshot.Groups[groupID][topic][partition],
+				Newest:    snapshot.Newest[topic][partition],
+				Lag:       topics[topic][partition],
+			}
+			if v, ok := snapshot.TimeLag[groupID][topic][partition]; ok {
+				ms := int64(v / time.Millisecond)
+				item.TimeLagMS = &ms
+			}
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func lagAction(_ *cli.Context) error {
+	snapshot, err := scrape()
+	check(err)
+
+	var items []partitionLag
+	for _, groupID := range snapshot.GroupIDs() {
+		if opts.Lag.Group != "" && groupID != opts.Lag.Group {
+			continue
+		}
+		items = append(items, makePartitionLags(snapshot, groupID, opts.Lag.Topic)...)
+	}
+
+	t := table{Header: []string{"GROUP", "TOPIC", "PARTITION", "COMMITTED", "NEWEST", "LAG"}}
+	if opts.TimeLag {
+		t.Header = append(t.Header, "TIME LAG")
+	}
+	for _, item := range items {
+		if !opts.TimeLag {
+			t.add(item.Group, item.Topic, item.Partition, item.Committed, item.Newest, item.Lag)
+			continue
+		}
+
+		timeLag := "-"
+		if item.TimeLagMS != nil {
+			timeLag = (time.Duration(*item.TimeLagMS) * time.Millisecond).String()
+		}
+		t.add(item.Group, item.Topic, item.Partition, item.Committed, item.Newest, item.Lag, timeLag)
+	}
+	if items == nil {
+		items = []partitionLag{}
+	}
+	return render(os.Stdout, t, items)
+}
+
+type groupSummary struct {
+	Group      string `json:"group"`
+	Topics     int    `json:"topics"`
+	Partitions int    `json:"partitions"`
+	TotalLag   int64  `json:"total_lag"`
+}
+
+func groupsListAction(_ *cli.Context) error {
+	snapshot, err := scrape()
+	check(err)
+
+	items := []groupSummary{}
+	t := table{Header: []string{"GROUP", "TOPICS", "PARTITIONS", "TOTAL LAG"}}
+	for _, groupID := range snapshot.GroupIDs() {
+		item := groupSummary{
+			Group:    groupID,
+			Topics:   len(snapshot.Groups[groupID]),
+			TotalLag: snapshot.TotalLag(groupID),
+		}
+		for _, partitions := range snapshot.Groups[groupID] {
+			item.Partitions += len(partitions)
+		}
+		items = append(items, item)
+		t.add(item.Group, item.Topics, item.Partitions, item.TotalLag)
+	}
+	return render(os.Stdout, t, items)
+}
+
+type groupDetail struct {
+	kag.GroupDescription
+	TotalLag   int64          `json:"total_lag"`
+	Partitions []partitionLag `json:"partitions"`
+}
+
+func groupsDescribeAction(c *cli.Context) error {
+	groupID := c.Args().First()
+	if groupID == "" {
+		return cli.NewExitError("usage: kag groups describe GROUP", 2)
+	}
+
+	config, err := newConfig(kag.Nop)
+	check(err)
+
+	client := kag.NewClient(config)
+	description, err := client.DescribeGroup(context.Background(), groupID)
+	check(err)
+
+	snapshot, err := client.Scrape(context.Background())
+	check(err)
+
+	owners := map[string]map[int32]string{}
+	for _, member := range description.Members {
+		for topic, partitions := range member.Assignments {
+			if owners[topic] == nil {
+				owners[topic] = map[int32]string{}
+			}
+			for _, partition := range partitions {
+				owners[topic][partition] = fmt.Sprintf("%v %v", member.ClientID, member.ClientHost)
+			}
+		}
+	}
+
+	detail := groupDetail{
+		GroupDescription: description,
+		TotalLag:         snapshot.TotalLag(groupID),
+		Partitions:       makePartitionLags(snapshot, groupID, ""),
+	}
+	for index, item := range detail.Partitions {
+		detail.Partitions[index].Owner = owners[item.Topic][item.Partition]
+	}
+
+	if opts.Output == outputTable {
+		fmt.Printf("Group:        %v\n", description.GroupID)
+		fmt.Printf("State:        %v\n", description.State)
+		fmt.Printf("Protocol:     %v/%v\n", description.ProtocolType, description.Protocol)
+		fmt.Printf("Coordinator:  %v (%v:%v)\n", description.Coordinator.NodeID, description.Coordinator.Host, description.Coordinator.Port)
+		fmt.Printf("Members:      %v\n", len(description.Members))
+		fmt.Printf("Total Lag:    %v\n", detail.TotalLag)
+		fmt.Println()
+	}
+
+	t := table{Header: []string{"TOPIC", "PARTITION", "COMMITTED", "NEWEST", "LAG", "OWNER"}}
+	for _, item := range detail.Partitions {
+		t.add(item.Topic, item.Partition, item.Committed, item.Newest, item.Lag, item.Owner)
+	}
+	return render(os.Stdout, t, detail)
+}
+
+type topicSummary struct {
+	Topic             string `json:"topic"`
+	Partitions        int    `json:"partitions"`
+	ReplicationFactor int    `json:"replication_factor"`
+	Records           int64  `json:"records"`
+}
+
+func topicsListAction(_ *cli.Context) error {
+	snapshot, err := scrape()
+	check(err)
+
+	items := []topicSummary{}
+	t := table{Header: []string{"TOPIC", "PARTITIONS", "REPLICATION FACTOR", "RECORDS"}}
+	for _, topic := range snapshot.TopicNames() {
+		partitions := snapshot.Topics[topic]
+		item := topicSummary{
+			Topic:      topic,
+			Partitions: len(partitions),
+		}
+		if len(partitions) > 0 {
+			item.ReplicationFactor = len(partitions[0].Replicas)
+		}
+		for _, p := range partitions {
+			item.Records += snapshot.Newest[topic][p.Partition] - snapshot.Oldest[topic][p.Partition]
+		}
+		items = append(items, item)
+		t.add(item.Topic, item.Partitions, item.ReplicationFactor, item.Records)
+	}
+	return render(os.Stdout, t, items)
+}
+
+type topicPartition struct {
+	Partition int32   `json:"partition"`
+	Leader    int32   `json:"leader"`
+	Replicas  []int32 `json:"replicas"`
+	Isr       []int32 `json:"isr"`
+	Oldest    int64   `json:"oldest"`
+	Newest    int64   `json:"newest"`
+}
+
+func topicsDescribeAction(c *cli.Context) error {
+	topic := c.Args().First()
+	if topic == "" {
+		return cli.NewExitError("usage: kag topics describe TOPIC", 2)
+	}
+
+	snapshot, err := scrape()
+	check(err)
+
+	partitions, ok := snapshot.Topics[topic]
+	if !ok {
+		return cli.NewExitError(fmt.Sprintf("topic not found, %v", topic), 1)
+	}
+
+	items := []topicPartition{}
+	t := table{Header: []string{"PARTITION", "LEADER", "REPLICAS", "ISR", "OLDEST", "NEWEST"}}
+	for _, p := range partitions {
+		item := topicPartition{
+			Partition: p.Partition,
+			Leader:    p.Leader,
+			Replicas:  p.Replicas,
+			Isr:       p.Isr,
+			Oldest:    snapshot.Oldest[topic][p.Partition],
+			Newest:    snapshot.Newest[topic][p.Partition],
+		}
+		items = append(items, item)
+		t.add(item.Partition, item.Leader, joinInt32(item.Replicas), joinInt32(item.Isr), item.Oldest, item.Newest)
+	}
+	return render(os.Stdout, t, items)
+}
+
+func clusterAction(_ *cli.Context) error {
+	snapshot, err := scrape()
+	check(err)
+
+	health := snapshot.ClusterHealth()
+	problems := table{Header: []string{"PROBLEM", "TOPIC", "PARTITION"}}
+	for _, tp := range health.UnderReplicated {
+		problems.add("under_replicated", tp.Topic, tp.Partition)
+	}
+	for _, tp := range health.Offline {
+		problems.add("offline", tp.Topic, tp.Partition)
+	}
+	for _, tp := range health.NonPreferredLeader {
+		problems.add("non_preferred_leader", tp.Topic, tp.Partition)
+	}
+	if opts.Output != outputTable {
+		return render(os.Stdout, problems, health)
+	}
+
+	fmt.Printf("Under Replicated:      %v\n", len(health.UnderReplicated))
+	fmt.Printf("Offline:               %v\n", len(health.Offline))
+	fmt.Printf("Non-Preferred Leader:  %v\n", len(health.NonPreferredLeader))
+	fmt.Println()
+
+	brokers := table{Header: []string{"BROKER", "PARTITIONS", "LEADERS"}}
+	for _, broker := range health.Brokers {
+		brokers.add(broker.NodeID, broker.Partitions, broker.Leaders)
+	}
+	if err := render(os.Stdout, brokers, health); err != nil {
+		return err
+	}
+
+	if len(problems.Rows) == 0 {
+		return nil
+	}
+	fmt.Println()
+	return render(os.Stdout, problems, health)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savaki/kag"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var offsetsCommand = cli.Command{
+	Name:  "offsets",
+	Usage: "manage consumer group offsets",
+	Subcommands: []cli.Command{
+		{
+			Name:   "reset",
+			Usage:  "reset the committed offsets of a consumer group; prints the plan unless --execute is set",
+			Action: offsetsResetAction,
+			Before: checkOutput,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "group",
+					Usage:       "consumer group to reset",
+					Destination: &opts.Offsets.Group,
+				},
+				cli.StringSliceFlag{
+					Name:  "topic",
+					Usage: "topic to reset; may be repeated.  defaults to every topic with committed offsets",
+				},
+				cli.BoolFlag{
+					Name:  "to-earliest",
+					Usage: "reset to the oldest available offset",
+				},
+				cli.BoolFlag{
+					Name:  "to-latest",
+					Usage: "reset to the newest offset",
+				},
+				cli.StringFlag{
+					Name:  "to-datetime",
+					Usage: "reset to the first offset at or after the time e.g. 2026-10-01T12:00:00Z",
+				},
+				cli.Int64Flag{
+					Name:  "to-offset",
+					Usage: "reset to the offset",
+				},
+				cli.Int64Flag{
+					Name:  "shift-by",
+					Usage: "shift the committed offset by n; negative values rewind",
+				},
+				cli.StringFlag{
+					Name:  "from-file",
+					Usage: "reset to the offsets in a csv file of topic,partition,offset",
+				},
+				cli.BoolFlag{
+					Name:        "execute",
+					Usage:       "commit the offsets rather than printing the plan",
+					Destination: &opts.Offsets.Execute,
+				},
+				outputFlag,
+			},
+		},
+		{
+			Name:   "at",
+			Usage:  "find the first offset of each partition at or after a point in time",
+			Action: offsetsAtAction,
+			Before: checkOutput,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "time",
+					Usage: "point in time e.g. 2026-10-01T12:00:00Z",
+				},
+				cli.StringSliceFlag{
+					Name:  "topic",
+					Usage: "topic to search; may be repeated.  defaults to every topic",
+				},
+				outputFlag,
+			},
+		},
+		{
+			Name:   "export",
+			Usage:  "export the committed offsets of every consumer group",
+			Action: offsetsExportAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Value: "json",
+					Usage: "export format; json, csv",
+				},
+				cli.StringFlag{
+					Name:  "file",
+					Usage: "file to write; defaults to stdout",
+				},
+			},
+		},
+		{
+			Name:   "import",
+			Usage:  "restore consumer group offsets from an export; prints the plan unless --execute is set",
+			Action: offsetsImportAction,
+			Before: checkOutput,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "file",
+					Usage: "export file to read, json or csv",
+				},
+				cli.StringSliceFlag{
+					Name:  "group",
+					Usage: "consumer group to import; may be repeated.  defaults to every group in the export",
+				},
+				cli.BoolFlag{
+					Name:  "strict",
+					Usage: "refuse to import if any offset is outside the range available on the brokers",
+				},
+				cli.BoolFlag{
+					Name:        "execute",
+					Usage:       "commit the offsets rather than printing the plan",
+					Destination: &opts.Offsets.Execute,
+				},
+				outputFlag,
+			},
+		},
+	},
+}
+
+// readOffsetsCSV reads topic,partition,offset records
+func readOffsetsCSV(r io.Reader) (map[string]map[int32]int64, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := map[string]map[int32]int64{}
+	for index, record := range records {
+		if len(record) != 3 {
+			return nil, fmt.Errorf("line %v: expected topic,partition,offset", index+1)
+		}
+		if index == 0 && record[0] == "topic" {
+			continue // header
+		}
+
+		partition, err := strconv.ParseInt(strings.TrimSpace(record[1]), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid partition, %v", index+1, record[1])
+		}
+		offset, err := strconv.ParseInt(strings.TrimSpace(record[2]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid offset, %v", index+1, record[2])
+		}
+
+		topic := strings.TrimSpace(record[0])
+		if offsets[topic] == nil {
+			offsets[topic] = map[int32]int64{}
+		}
+		offsets[topic][int32(partition)] = offset
+	}
+	return offsets, nil
+}
+
+func makeResetSpec(c *cli.Context) (kag.ResetSpec, error) {
+	var specs []kag.ResetSpec
+
+	if c.Bool("to-earliest") {
+		specs = append(specs, kag.ResetSpec{Strategy: kag.ResetToEarliest})
+	}
+	if c.Bool("to-latest") {
+		specs = append(specs, kag.ResetSpec{Strategy: kag.ResetToLatest})
+	}
+	if v := c.String("to-datetime"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return kag.ResetSpec{}, fmt.Errorf("invalid --to-datetime, %v: %v", v, err)
+		}
+		specs = append(specs, kag.ResetSpec{Strategy: kag.ResetToDatetime, Time: t})
+	}
+	if c.IsSet("to-offset") {
+		specs = append(specs, kag.ResetSpec{Strategy: kag.ResetToOffset, Offset: c.Int64("to-offset")})
+	}
+	if c.IsSet("shift-by") {
+		specs = append(specs, kag.ResetSpec{Strategy: kag.ResetShiftBy, Shift: c.Int64("shift-by")})
+	}
+	if v := c.String("from-file"); v != "" {
+		f, err := os.Open(v)
+		if err != nil {
+			return kag.ResetSpec{}, err
+		}
+		defer f.Close()
+
+		offsets, err := readOffsetsCSV(f)
+		if err != nil {
+			return kag.ResetSpec{}, fmt.Errorf("unable to read %v: %v", v, err)
+		}
+		specs = append(specs, kag.ResetSpec{Strategy: kag.ResetFromOffsets, Offsets: offsets})
+	}
+
+	if len(specs) != 1 {
+		return kag.ResetSpec{}, fmt.Errorf("exactly one of --to-earliest, --to-latest, --to-datetime, --to-offset, --shift-by, or --from-file must be set")
+	}
+	return specs[0], nil
+}
+
+func makePlanTable(plans ...*kag.ResetPlan) table {
+	t := table{Header: []string{"GROUP", "TOPIC", "PARTITION", "CURRENT", "TARGET", "DELTA", "OLDEST", "NEWEST", "NOTE"}}
+	for _, plan := range plans {
+		for _, r := range plan.Resets {
+			delta := "-"
+			if r.Current >= 0 {
+				delta = strconv.FormatInt(r.Target-r.Current, 10)
+			}
+			t.add(plan.GroupID, r.Topic, r.Partition, r.Current, r.Target, delta, r.Oldest, r.Newest, r.Note)
+		}
+	}
+	return t
+}
+
+func offsetsResetAction(c *cli.Context) error {
+	if opts.Offsets.Group == "" {
+		return cli.NewExitError("--group is required", 2)
+	}
+
+	spec, err := makeResetSpec(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+
+	config, err := newConfig(kag.Nop)
+	check(err)
+
+	client := kag.NewClient(config)
+	ctx := context.Background()
+
+	plan, err := client.PlanOffsetReset(ctx, opts.Offsets.Group, c.StringSlice("topic"), spec)
+	check(err)
+
+	check(render(os.Stdout, makePlanTable(plan), plan))
+
+	if plan.Members > 0 {
+		return cli.NewExitError(fmt.Sprintf("refusing to reset; consumer group, %v, has %v active members", plan.GroupID, plan.Members), 1)
+	}
+	if !opts.Offsets.Execute {
+		fmt.Fprintln(os.Stderr, "dry run; re-run with --execute to commit these offsets")
+		return nil
+	}
+
+	check(client.ExecuteOffsetReset(ctx, plan))
+	fmt.Fprintf(os.Stderr, "committed %v offsets for consumer group, %v\n", len(plan.Resets), plan.GroupID)
+	return nil
+}
+
+func offsetsAtAction(c *cli.Context) error {
+	v := c.String("time")
+	if v == "" {
+		return cli.NewExitError("--time is required", 2)
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("invalid --time, %v: %v", v, err), 2)
+	}
+
+	config, err := newConfig(kag.Nop)
+	check(err)
+
+	offsets, err := kag.NewClient(config).OffsetsForTime(context.Background(), t, c.StringSlice("topic")...)
+	check(err)
+
+	tbl := table{Header: []string{"TOPIC", "PARTITION", "OFFSET", "TIMESTAMP"}}
+	for _, item := range offsets {
+		timestamp := "-"
+		if !item.Timestamp.IsZero() {
+			timestamp = item.Timestamp.Format(time.RFC3339Nano)
+		}
+		tbl.add(item.Topic, item.Partition, item.Offset, timestamp)
+	}
+	return render(os.Stdout, tbl, offsets)
+}
+
+func offsetsExportAction(c *cli.Context) error {
+	format := c.String("format")
+	if format != outputJSON && format != outputCSV {
+		return cli.NewExitError(fmt.Sprintf("unknown format, %v.  valid formats json, csv", format), 2)
+	}
+
+	config, err := newConfig(kag.Nop)
+	check(err)
+
+	export, err := kag.NewClient(config).ExportOffsets(context.Background())
+	check(err)
+
+	var w io.Writer = os.Stdout
+	if path := c.String("file"); path != "" {
+		f, err := os.Create(path)
+		check(err)
+		defer f.Close()
+		w = f
+	}
+
+	if format == outputCSV {
+		return export.WriteCSV(w)
+	}
+	return export.WriteJSON(w)
+}
+
+func offsetsImportAction(c *cli.Context) error {
+	path := c.String("file")
+	if path == "" {
+		return cli.NewExitError("--file is required", 2)
+	}
+
+	f, err := os.Open(path)
+	check(err)
+	defer f.Close()
+
+	export, err := kag.ReadOffsetsExport(f)
+	check(err)
+
+	config, err := newConfig(kag.Nop)
+	check(err)
+
+	client := kag.NewClient(config)
+	ctx := context.Background()
+
+	plans, err := client.PlanOffsetsImport(ctx, export, c.StringSlice("group"))
+	check(err)
+	check(render(os.Stdout, makePlanTable(plans...), plans))
+
+	var active, clamped []string
+	for _, plan := range plans {
+		if plan.Members > 0 {
+			active = append(active, plan.GroupID)
+		}
+		for _, r := range plan.Resets {
+			if r.Note != "" {
+				clamped = append(clamped, fmt.Sprintf("%v %v/%v", plan.GroupID, r.Topic, r.Partition))
+			}
+		}
+	}
+	if len(active) > 0 {
+		return cli.NewExitError(fmt.Sprintf("refusing to import; consumer groups have active members: %v", strings.Join(active, ", ")), 1)
+	}
+	if len(clamped) > 0 && c.Bool("strict") {
+		return cli.NewExitError(fmt.Sprintf("refusing to import; offsets out of range: %v", strings.Join(clamped, ", ")), 1)
+	}
+	if !opts.Offsets.Execute {
+		fmt.Fprintln(os.Stderr, "dry run; re-run with --execute to commit these offsets")
+		return nil
+	}
+
+	for _, plan := range plans {
+		check(client.ExecuteOffsetReset(ctx, plan))
+		fmt.Fprintf(os.Stderr, "committed %v offsets for consumer group, %v\n", len(plan.Resets), plan.GroupID)
+	}
+	return nil
+}
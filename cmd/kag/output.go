@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+var outputFlag = cli.StringFlag{
+	Name:        "output, o",
+	Value:       outputTable,
+	Usage:       "output format; table, json, csv",
+	Destination: &opts.Output,
+}
+
+// table holds tabular command output
+type table struct {
+	Header []string
+	Rows   [][]string
+}
+
+func (t *table) add(values ...interface{}) {
+	row := make([]string, 0, len(values))
+	for _, v := range values {
+		row = append(row, fmt.Sprint(v))
+	}
+	t.Rows = append(t.Rows, row)
+}
+
+// checkOutput rejects an unknown --output before a command connects to the
+// cluster
+func checkOutput(_ *cli.Context) error {
+	switch opts.Output {
+	case outputTable, outputJSON, outputCSV:
+		return nil
+	default:
+		return cli.NewExitError(fmt.Sprintf("unknown output, %v.  valid outputs table, json, csv", opts.Output), 2)
+	}
+}
+
+// render writes the output in the format requested by --output.  The json
+// format writes v; the table and csv formats write t.
+func render(w io.Writer, t table, v interface{}) error {
+	switch opts.Output {
+	case outputTable:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(t.Header, "\t"))
+		for _, row := range t.Rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+
+	case outputCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(t.Header)
+		cw.WriteAll(t.Rows)
+		return cw.Error()
+
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+
+	default:
+		return fmt.Errorf("unknown output, %v.  valid outputs table, json, csv", opts.Output)
+	}
+}
+
+func joinInt32(values []int32) string {
+	var ss []string
+	for _, v := range values {
+		ss = append(ss, fmt.Sprint(v))
+	}
+	return strings.Join(ss, ",")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+
+	"github.com/savaki/kag"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var replayCommand = cli.Command{
+	Name:      "replay",
+	Usage:     "publish a recording made with --record to the configured observers without connecting to kafka",
+	ArgsUsage: "{recording}",
+	Action:    replayAction,
+	Flags: []cli.Flag{
+		cli.Float64Flag{
+			Name:  "speed",
+			Value: 1,
+			Usage: "rate the recording is replayed relative to real time e.g. 60 replays an hour a minute; 0 replays as fast as possible",
+		},
+	},
+}
+
+func replayAction(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return cli.NewExitError("recording required; kag replay {file}", 1)
+	}
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer f.Close()
+
+	recording, err := kag.NewRecordingReader(f)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	config, kagConfig, err := offlineConfig()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer closeObserver(kagConfig.Observer)
+
+	replayer := kag.NewReplayer(kagConfig)
+	if config.HTTPAddr != "" {
+		server := serve(config.HTTPAddr, replayer)
+		defer server.Close()
+	}
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	if err := replayer.Run(ctx, recording, c.Float64("speed")); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/savaki/kag"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var simulateCommand = cli.Command{
+	Name:      "simulate",
+	Usage:     "publish synthetic lag from a scenario file to the configured observers without connecting to kafka",
+	ArgsUsage: "{scenario}",
+	Action:    simulateAction,
+	Flags: []cli.Flag{
+		cli.Float64Flag{
+			Name:  "speed",
+			Value: 1,
+			Usage: "rate simulated time passes relative to real time e.g. 60 runs an hour of scenario a minute",
+		},
+	},
+}
+
+// scenarioFile holds a kag.Scenario read from json
+type scenarioFile struct {
+	Brokers  int             `json:"brokers"`
+	Duration duration        `json:"duration"`
+	Topics   []scenarioTopic `json:"topics"`
+	Groups   []scenarioGroup `json:"groups"`
+	Events   []scenarioEvent `json:"events"`
+}
+
+type scenarioTopic struct {
+	Name              string   `json:"name"`
+	Partitions        int      `json:"partitions"`
+	ReplicationFactor int      `json:"replication_factor"`
+	ProduceRate       float64  `json:"produce_rate"`
+	Retention         duration `json:"retention"`
+}
+
+type scenarioGroup struct {
+	Name        string   `json:"name"`
+	Topics      []string `json:"topics"`
+	ConsumeRate float64  `json:"consume_rate"`
+	Lag         int64    `json:"lag"`
+}
+
+type scenarioEvent struct {
+	Type     string   `json:"type"`
+	At       duration `json:"at"`
+	Duration duration `json:"duration"`
+	Group    string   `json:"group"`
+	Topic    string   `json:"topic"`
+	Broker   int32    `json:"broker"`
+	Rate     float64  `json:"rate"`
+	Records  int64    `json:"records"`
+}
+
+func (s scenarioFile) scenario() kag.Scenario {
+	scenario := kag.Scenario{
+		Brokers:  s.Brokers,
+		Duration: time.Duration(s.Duration),
+	}
+	for _, topic := range s.Topics {
+		scenario.Topics = append(scenario.Topics, kag.ScenarioTopic{
+			Name:              topic.Name,
+			Partitions:        topic.Partitions,
+			ReplicationFactor: topic.ReplicationFactor,
+			ProduceRate:       topic.ProduceRate,
+			Retention:         time.Duration(topic.Retention),
+		})
+	}
+	for _, group := range s.Groups {
+		scenario.Groups = append(scenario.Groups, kag.ScenarioGroup{
+			Name:        group.Name,
+			Topics:      group.Topics,
+			ConsumeRate: group.ConsumeRate,
+			Lag:         group.Lag,
+		})
+	}
+	for _, event := range s.Events {
+		scenario.Events = append(scenario.Events, kag.ScenarioEvent{
+			Type:     event.Type,
+			At:       time.Duration(event.At),
+			Duration: time.Duration(event.Duration),
+			Group:    event.Group,
+			Topic:    event.Topic,
+			Broker:   event.Broker,
+			Rate:     event.Rate,
+			Records:  event.Records,
+		})
+	}
+	return scenario
+}
+
+func readScenarioFile(filename string) (kag.Scenario, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return kag.Scenario{}, errors.Wrapf(err, "unable to read scenario file, %v", filename)
+	}
+
+	var s scenarioFile
+	if err := json.Unmarshal(data, &s); err != nil {
+		return kag.Scenario{}, errors.Wrapf(err, "unable to parse scenario file, %v", filename)
+	}
+	return s.scenario(), nil
+}
+
+func simulateAction(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return cli.NewExitError("scenario file required; kag simulate {file}", 1)
+	}
+	scenario, err := readScenarioFile(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	config, kagConfig, err := offlineConfig()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer closeObserver(kagConfig.Observer)
+
+	simulator, err := kag.NewSimulator(scenario, kagConfig)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if config.HTTPAddr != "" {
+		server := serve(config.HTTPAddr, simulator)
+		defer server.Close()
+	}
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	if err := simulator.Run(ctx, c.Float64("speed")); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+// offlineConfig returns the configuration and the kag.Config, with its
+// observers and recorder, of the cluster named by --cluster for commands that
+// publish without connecting to kafka
+func offlineConfig() (*fileConfig, kag.Config, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, kag.Config{}, err
+	}
+	cluster, err := config.cluster(opts.Cluster)
+	if err != nil {
+		return nil, kag.Config{}, err
+	}
+	observer, err := config.newObserver(cluster.Name)
+	if err != nil {
+		return nil, kag.Config{}, err
+	}
+	if cluster.RecordFile != "" {
+		recorder, err := kag.OpenRecorder(cluster.RecordFile)
+		if err != nil {
+			closeObserver(observer)
+			return nil, kag.Config{}, err
+		}
+		observer = kag.MultiObserver(observer, recorder)
+	}
+	kagConfig, err := config.kagConfig(cluster, observer, nil)
+	if err != nil {
+		closeObserver(observer)
+		return nil, kag.Config{}, err
+	}
+	return config, kagConfig, nil
+}
+
+// interruptible returns a context that is cancelled on interrupt
+func interruptible() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Kill, os.Interrupt)
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(stop)
+	}()
+	return ctx, cancel
+}
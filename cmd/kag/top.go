@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	"github.com/savaki/kag"
+	"github.com/savaki/kag/top"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// stty runs stty against the controlling terminal and returns its output
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	data, err := cmd.Output()
+	return strings.TrimSpace(string(data)), err
+}
+
+func terminalSize() (width, height int, err error) {
+	size, err := stty("size")
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = fmt.Sscanf(size, "%d %d", &height, &width)
+	return
+}
+
+func topAction(_ *cli.Context) error {
+	config, err := newConfig(kag.Nop)
+	check(err)
+
+	monitor := kag.New(config)
+	defer monitor.Close()
+
+	saved, err := stty("-g")
+	if err != nil {
+		return cli.NewExitError("kag top requires an interactive terminal", 1)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return cli.NewExitError(fmt.Sprintf("unable to configure terminal: %v", err), 1)
+	}
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		stty(saved)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	defer signal.Stop(stop)
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return top.Run(ctx, monitor, top.ReadKeys(os.Stdin), os.Stdout, top.Options{
+		Interval: opts.Interval,
+		Size:     terminalSize,
+	})
+}
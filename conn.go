@@ -0,0 +1,176 @@
+package kag
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/savaki/franz"
+	"github.com/savaki/kag/internal/wire"
+)
+
+// defaultRequestTimeout bounds requests sent by kag when Config.Timeout is
+// not set
+const defaultRequestTimeout = 30 * time.Second
+
+// dialNet opens a network connection to the broker at addr honoring the
+// Timeout, Deadline, Resolver, Proxy, TLS, and SASL settings of the Config
+func (c *Client) dialNet(ctx context.Context, addr string) (net.Conn, error) {
+	if c.config.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
+
+	if !c.config.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.config.Deadline)
+		defer cancel()
+	}
+
+	// the broker is verified against the name it was dialed by rather than
+	// the address it resolves to
+	serverName := addr
+	if r := c.config.Resolver; r != nil {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) != 0 {
+			addr = net.JoinHostPort(addrs[0], port)
+		}
+	}
+
+	target := addr
+	if c.config.Proxy != nil {
+		target = c.config.Proxy.Addr()
+	}
+
+	conn, err := (&net.Dialer{
+		LocalAddr:     c.config.LocalAddr,
+		DualStack:     c.config.DualStack,
+		FallbackDelay: c.config.FallbackDelay,
+		KeepAlive:     c.config.KeepAlive,
+	}).DialContext(ctx, "tcp", target)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.config.Proxy != nil {
+		if err := c.config.Proxy.Connect(ctx, conn, addr); err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "unable to connect to %v through proxy, %v", addr, target)
+		}
+	}
+
+	if c.config.TLS != nil {
+		if conn, err = handshakeTLS(ctx, conn, withServerName(c.config.TLS, serverName)); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.config.SASL != nil {
+		if err := c.authenticate(ctx, conn); err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "unable to authenticate to %v", addr)
+		}
+	}
+
+	return conn, nil
+}
+
+// withServerName returns the config with ServerName set to the host of addr
+// if the config does not name the server to verify
+func withServerName(config *tls.Config, addr string) *tls.Config {
+	if config.ServerName != "" || config.InsecureSkipVerify {
+		return config
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
+
+// handshakeTLS returns a tls.Conn that has already completed the handshake
+func handshakeTLS(ctx context.Context, conn net.Conn, config *tls.Config) (net.Conn, error) {
+	tlsConn := tls.Client(conn, config)
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- tlsConn.Handshake()
+	}()
+
+	select {
+	case <-ctx.Done():
+		conn.Close()
+		<-errs
+		return nil, ctx.Err()
+
+	case err := <-errs:
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// requestTimeout returns the time allowed for a single request
+func (c *Client) requestTimeout() time.Duration {
+	if c.config.Timeout == 0 {
+		return defaultRequestTimeout
+	}
+	return c.config.Timeout
+}
+
+// dial opens a connection to the broker at addr and negotiates the version
+// of each request with it
+func (c *Client) dial(ctx context.Context, addr string) (*wire.Conn, error) {
+	return c.dialTimeout(ctx, addr, c.requestTimeout())
+}
+
+// dialTimeout is dial for requests, e.g. JoinGroup, that the broker may hold
+// for longer than the configured timeout
+func (c *Client) dialTimeout(ctx context.Context, addr string, timeout time.Duration) (*wire.Conn, error) {
+	conn, err := c.dialNet(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	w := wire.NewConn(conn, c.clientID(), timeout)
+	err = w.Negotiate()
+	if err == nil {
+		return w, nil
+	}
+	w.Close()
+	if v, ok := errors.Cause(err).(net.Error); ok && v.Timeout() {
+		return nil, errors.Wrapf(err, "unable to retrieve api versions from %v", addr)
+	}
+
+	// brokers that predate ApiVersions close the connection
+	c.debug("unable to retrieve api versions from %v; assuming kafka 0.9: %v", addr, err)
+	conn, err = c.dialNet(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	w = wire.NewConn(conn, c.clientID(), timeout)
+	w.SetApiVersions(wire.Legacy)
+	return w, nil
+}
+
+// clientID returns the client id sent with requests
+func (c *Client) clientID() string {
+	if c.config.ClientID == "" {
+		return franz.DefaultClientID
+	}
+	return c.config.ClientID
+}
@@ -0,0 +1,45 @@
+package kag
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestWithServerName(t *testing.T) {
+	testCases := map[string]struct {
+		Config *tls.Config
+		Addr   string
+		Want   string
+	}{
+		"host": {
+			Config: &tls.Config{},
+			Addr:   "broker-1.example.com:9093",
+			Want:   "broker-1.example.com",
+		},
+		"ipv6": {
+			Config: &tls.Config{},
+			Addr:   "[::1]:9093",
+			Want:   "::1",
+		},
+		"configured": {
+			Config: &tls.Config{ServerName: "kafka.example.com"},
+			Addr:   "broker-1.example.com:9093",
+			Want:   "kafka.example.com",
+		},
+		"insecure": {
+			Config: &tls.Config{InsecureSkipVerify: true},
+			Addr:   "broker-1.example.com:9093",
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			original := tc.Config.ServerName
+			config := withServerName(tc.Config, tc.Addr)
+			assert.Equal(t, tc.Want, config.ServerName)
+			assert.Equal(t, original, tc.Config.ServerName, "shared config must not be modified")
+		})
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
 	"github.com/pkg/errors"
+	"github.com/savaki/kag"
 )
 
 type Observer struct {
@@ -24,6 +26,151 @@ func (o *Observer) Observe(groupID, topic string, partition int32, lag int64) {
 	}
 }
 
+// ObserveTimeLag publishes the age of the oldest unconsumed record in seconds
+func (o *Observer) ObserveTimeLag(groupID, topic string, partition int32, lag time.Duration) {
+	tags := []string{
+		"group:" + groupID,
+		"topic:" + topic,
+		"partition:" + strconv.Itoa(int(partition)),
+	}
+	if err := o.client.Gauge("kafka.consumer.time_lag", lag.Seconds(), tags, 1); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// ObserveProduceRate publishes the records per second appended to a partition
+func (o *Observer) ObserveProduceRate(topic string, partition int32, rate float64) {
+	tags := []string{
+		"topic:" + topic,
+		"partition:" + strconv.Itoa(int(partition)),
+	}
+	if err := o.client.Gauge("kafka.topic.produce_rate", rate, tags, 1); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// ObserveForecast publishes the consume rate and, unless the group will never
+// catch up, the catch up time in seconds
+func (o *Observer) ObserveForecast(groupID, topic string, partition int32, forecast kag.Forecast) {
+	tags := []string{
+		"group:" + groupID,
+		"topic:" + topic,
+		"partition:" + strconv.Itoa(int(partition)),
+	}
+	if err := o.client.Gauge("kafka.consumer.consume_rate", forecast.ConsumeRate, tags, 1); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	never := 0.0
+	if forecast.Never {
+		never = 1
+	} else if err := o.client.Gauge("kafka.consumer.catch_up", forecast.CatchUp.Seconds(), tags, 1); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := o.client.Gauge("kafka.consumer.catch_up_never", never, tags, 1); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// ObserveClusterHealth publishes the number of unhealthy partitions in the
+// cluster and the partition and leader counts of each broker
+func (o *Observer) ObserveClusterHealth(health kag.ClusterHealth) {
+	gauges := map[string]int{
+		"kafka.cluster.under_replicated_partitions":     len(health.UnderReplicated),
+		"kafka.cluster.offline_partitions":              len(health.Offline),
+		"kafka.cluster.non_preferred_leader_partitions": len(health.NonPreferredLeader),
+	}
+	for name, value := range gauges {
+		if err := o.client.Gauge(name, float64(value), nil, 1); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	for _, broker := range health.Brokers {
+		tags := []string{"broker:" + strconv.Itoa(int(broker.NodeID))}
+		if err := o.client.Gauge("kafka.broker.partitions", float64(broker.Partitions), tags, 1); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if err := o.client.Gauge("kafka.broker.leaders", float64(broker.Leaders), tags, 1); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// ObserveRetention publishes the headroom before retention deletes
+// unconsumed records, the estimated time until it does in seconds, and
+// whether the partition is in alert
+func (o *Observer) ObserveRetention(groupID, topic string, partition int32, risk kag.RetentionRisk) {
+	tags := []string{
+		"group:" + groupID,
+		"topic:" + topic,
+		"partition:" + strconv.Itoa(int(partition)),
+	}
+	if err := o.client.Gauge("kafka.consumer.retention_headroom", float64(risk.Headroom), tags, 1); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if !risk.Never {
+		if err := o.client.Gauge("kafka.consumer.time_to_data_loss", risk.TimeToLoss.Seconds(), tags, 1); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	alert := 0.0
+	if risk.Alert {
+		alert = 1
+	}
+	if err := o.client.Gauge("kafka.consumer.retention_alert", alert, tags, 1); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// ObserveStats publishes the duration and outcome of each scrape, what it
+// scraped, and the requests sent to each broker
+func (o *Observer) ObserveStats(stats kag.ScrapeStats) {
+	if err := o.client.Gauge("kag.scrape.duration", stats.Duration.Seconds(), nil, 1); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := o.client.Incr("kag.scrape.count", nil, 1); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if stats.Err != nil {
+		if err := o.client.Incr("kag.scrape.errors", nil, 1); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	} else {
+		gauges := map[string]int{
+			"kag.scrape.brokers":    stats.Brokers,
+			"kag.scrape.groups":     stats.Groups,
+			"kag.scrape.topics":     stats.Topics,
+			"kag.scrape.partitions": stats.Partitions,
+		}
+		for name, value := range gauges {
+			if err := o.client.Gauge(name, float64(value), nil, 1); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}
+
+	for _, broker := range stats.Requests {
+		tags := []string{"broker:" + strconv.Itoa(int(broker.NodeID))}
+		if broker.NodeID == -1 {
+			tags = []string{"broker:bootstrap"}
+		}
+		if err := o.client.Count("kag.broker.requests", int64(broker.Requests), tags, 1); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if err := o.client.Count("kag.broker.request_errors", int64(broker.Errors), tags, 1); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if err := o.client.Gauge("kag.broker.latency", broker.Latency.Seconds(), tags, 1); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if err := o.client.Gauge("kag.broker.max_latency", broker.MaxLatency.Seconds(), tags, 1); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
 func (o *Observer) Flush() error {
 	return o.client.Flush()
 }
@@ -5,11 +5,13 @@ import (
 	"crypto/tls"
 	"io"
 	"net"
+	"reflect"
 	"time"
 )
 
 const (
 	DefaultInterval = time.Minute
+	DefaultCluster  = "default"
 )
 
 // The Resolver interface is used as an abstraction to provide service discovery
@@ -26,15 +28,82 @@ type Config struct {
 	// Brokers contains the initial list of kafka brokerArray to connect to
 	Brokers []string
 
+	// Discovery, when set, finds the initial brokers in place of Brokers,
+	// which are then only used if discovery fails.  See SRVDiscovery and
+	// ConsulDiscovery.
+	Discovery Discovery
+
+	// DiscoveryInterval sets how often the brokers are discovered again so
+	// that kag follows clusters whose bootstrap hosts change; defaults to
+	// DefaultDiscoveryInterval
+	DiscoveryInterval time.Duration
+
 	// Unique identifier for client connections established by this Config.
 	ClientID string
 
+	// Cluster holds a human readable name for the cluster being monitored
+	Cluster string
+
 	// Observer publishes lag
 	Observer Observer
 
 	// Interval specifies the rate the kafka brokers should be polled
 	Interval time.Duration
 
+	// TimeLag enables reading the record at each group's committed offset so
+	// that lag may also be reported as the age of the oldest unconsumed
+	// record.  See Snapshot.TimeLag.
+	TimeLag bool
+
+	// RetentionAlert flags any lagging group partition expected to lose
+	// unconsumed records to retention within this window.  Zero disables
+	// alerting.  See Snapshot.Retention.
+	RetentionAlert time.Duration
+
+	// GroupFilter optionally restricts the consumer groups that are
+	// monitored to those for which it returns true
+	GroupFilter func(groupID string) bool
+
+	// TopicFilter optionally restricts the topics whose offsets and lag are
+	// monitored to those for which it returns true
+	TopicFilter func(topic string) bool
+
+	// Groups optionally holds settings that override the defaults for
+	// individual consumer groups
+	Groups map[string]GroupConfig
+
+	// ElectionGroup, when set, elects a leader among the kag instances that
+	// share the group using Kafka's group membership protocol.  Only the
+	// leader scrapes and publishes.  When the leader fails, a standby takes
+	// over within SessionTimeout; when it is closed, immediately.
+	ElectionGroup string
+
+	// ShardGroup, when set, divides the consumer groups of the cluster among
+	// the kag instances that share the group, rebalancing as instances come
+	// and go.  Each instance fetches and publishes only its share; topic and
+	// cluster wide metrics are published by the first instance.  Takes
+	// precedence over ElectionGroup.
+	ShardGroup string
+
+	// ShardTopics divides topics, rather than consumer groups, among the
+	// instances of ShardGroup.  Each instance reports the lag of every group
+	// on its topics.
+	ShardTopics bool
+
+	// SessionTimeout is the time after which the coordinator removes an
+	// unresponsive instance from ElectionGroup or ShardGroup; defaults to
+	// DefaultSessionTimeout
+	SessionTimeout time.Duration
+
+	// History optionally records the offsets and lag of every scrape.  On
+	// start, the Monitor seeds its rate calculations from the most recent
+	// sample.
+	History *History
+
+	// FetchMaxBytes limits the bytes read per partition when TimeLag is
+	// enabled.  Defaults to DefaultFetchMaxBytes.
+	FetchMaxBytes int32
+
 	// Timeout is the maximum amount of time a dial will wait for a connect to
 	// complete. If Deadline is also set, it may fail earlier.
 	//
@@ -78,10 +147,86 @@ type Config struct {
 	// Resolver optionally specifies an alternate resolver to use.
 	Resolver Resolver
 
+	// Proxy, when set, opens every connection to the brokers, both bootstrap
+	// and those found through metadata, through a proxy.  See SOCKS5Proxy,
+	// HTTPProxy, and ParseProxy.
+	Proxy Proxy
+
 	// TLS enables Config to open secure connections.  If nil, standard net.Conn
-	// will be used.
+	// will be used.  Unless TLS sets ServerName, each broker's certificate is
+	// verified against the host the broker was dialed by.
 	TLS *tls.Config
 
+	// SASL, when set, authenticates every connection to the brokers after
+	// the TLS handshake, if any.  See PlainMechanism, ScramMechanism, and
+	// OAuthBearerMechanism.
+	SASL SASLMechanism
+
 	// Debug writer for optional debug messages
 	Debug io.Writer
 }
+
+// GroupConfig holds settings specific to a single consumer group
+type GroupConfig struct {
+	// RetentionAlert overrides Config.RetentionAlert for the group when
+	// non-zero
+	RetentionAlert time.Duration
+}
+
+func (c Config) retentionAlert(groupID string) time.Duration {
+	if v := c.Groups[groupID].RetentionAlert; v != 0 {
+		return v
+	}
+	return c.RetentionAlert
+}
+
+// connectionChanged returns true if the settings used to connect to the
+// cluster differ between a and b
+func connectionChanged(a, b Config) bool {
+	return !reflect.DeepEqual(a.Brokers, b.Brokers) ||
+		!reflect.DeepEqual(a.Discovery, b.Discovery) ||
+		a.DiscoveryInterval != b.DiscoveryInterval ||
+		a.ClientID != b.ClientID ||
+		a.Timeout != b.Timeout ||
+		!a.Deadline.Equal(b.Deadline) ||
+		!reflect.DeepEqual(a.LocalAddr, b.LocalAddr) ||
+		a.DualStack != b.DualStack ||
+		a.FallbackDelay != b.FallbackDelay ||
+		a.KeepAlive != b.KeepAlive ||
+		!reflect.DeepEqual(a.Resolver, b.Resolver) ||
+		!reflect.DeepEqual(a.Proxy, b.Proxy) ||
+		a.TLS != b.TLS ||
+		!reflect.DeepEqual(a.SASL, b.SASL) ||
+		a.ElectionGroup != b.ElectionGroup ||
+		a.ShardGroup != b.ShardGroup ||
+		a.ShardTopics != b.ShardTopics ||
+		a.SessionTimeout != b.SessionTimeout
+}
+
+// filter removes the groups and topics excluded by GroupFilter and
+// TopicFilter
+func (c Config) filter(newest, oldest topicOffsets, groups groupOffsets) {
+	if c.TopicFilter != nil {
+		for _, offsets := range []topicOffsets{newest, oldest} {
+			for topic := range offsets {
+				if !c.TopicFilter(topic) {
+					delete(offsets, topic)
+				}
+			}
+		}
+	}
+
+	for groupID, topics := range groups {
+		if c.GroupFilter != nil && !c.GroupFilter(groupID) {
+			delete(groups, groupID)
+			continue
+		}
+		if c.TopicFilter != nil {
+			for topic := range topics {
+				if !c.TopicFilter(topic) {
+					delete(topics, topic)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package kag
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+func TestConfigFilter(t *testing.T) {
+	config := Config{
+		GroupFilter: func(groupID string) bool { return !strings.HasPrefix(groupID, "console-") },
+		TopicFilter: func(topic string) bool { return !strings.HasPrefix(topic, "_") },
+	}
+
+	newest := topicOffsets{"a": {0: 10}, "_internal": {0: 5}}
+	oldest := topicOffsets{"a": {0: 1}, "_internal": {0: 1}}
+	groups := groupOffsets{
+		"app":           {"a": {0: 8}, "_internal": {0: 3}},
+		"console-12345": {"a": {0: 1}},
+	}
+	config.filter(newest, oldest, groups)
+
+	assert.Equal(t, topicOffsets{"a": {0: 10}}, newest)
+	assert.Equal(t, topicOffsets{"a": {0: 1}}, oldest)
+	assert.Equal(t, groupOffsets{"app": {"a": {0: 8}}}, groups)
+}
+
+func TestConnectionChanged(t *testing.T) {
+	tlsConfig := &tls.Config{}
+	config := Config{
+		Brokers:  []string{"a:9092", "b:9092"},
+		Interval: time.Minute,
+		TLS:      tlsConfig,
+	}
+
+	unchanged := config
+	unchanged.Brokers = []string{"a:9092", "b:9092"}
+	unchanged.Interval = time.Second
+	unchanged.TimeLag = true
+	assert.False(t, connectionChanged(config, unchanged))
+
+	brokers := config
+	brokers.Brokers = []string{"a:9092"}
+	assert.True(t, connectionChanged(config, brokers))
+
+	certs := config
+	certs.TLS = &tls.Config{}
+	assert.True(t, connectionChanged(config, certs))
+
+	sasl := config
+	sasl.SASL = PlainMechanism("alice", "password")
+	assert.True(t, connectionChanged(config, sasl))
+
+	same := sasl
+	same.SASL = PlainMechanism("alice", "password")
+	assert.False(t, connectionChanged(sasl, same))
+
+	proxy := config
+	proxy.Proxy = SOCKS5Proxy("bastion:1080", "", "")
+	assert.True(t, connectionChanged(config, proxy))
+
+	discovery := config
+	discovery.Discovery = SRVDiscovery("_kafka._tcp.example.com")
+	assert.True(t, connectionChanged(config, discovery))
+
+	sameProxy := proxy
+	sameProxy.Proxy = SOCKS5Proxy("bastion:1080", "", "")
+	assert.False(t, connectionChanged(proxy, sameProxy))
+}
@@ -0,0 +1,243 @@
+package kag
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultDiscoveryInterval is how often the bootstrap brokers are discovered
+// when Config.DiscoveryInterval is not set
+const DefaultDiscoveryInterval = time.Minute
+
+// Discovery finds the bootstrap brokers of a cluster.  See Config.Discovery.
+type Discovery interface {
+	// Discover returns the addresses, host:port, of the bootstrap brokers
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// lookupSRV may be replaced by tests
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// SRVDiscovery returns a Discovery that reads the bootstrap brokers from the
+// DNS SRV records of name e.g. _kafka._tcp.example.com
+func SRVDiscovery(name string) Discovery {
+	return srvDiscovery{name: name}
+}
+
+type srvDiscovery struct {
+	name string
+}
+
+func (s srvDiscovery) Discover(ctx context.Context) ([]string, error) {
+	_, records, err := lookupSRV(ctx, "", "", s.name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to look up srv records, %v", s.name)
+	}
+
+	var brokers []string
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		brokers = append(brokers, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+	return brokers, nil
+}
+
+// ConsulConfig locates the brokers of a cluster in the Consul catalog
+type ConsulConfig struct {
+	// Addr holds the url of the consul http api; defaults to
+	// http://127.0.0.1:8500
+	Addr string
+
+	// Service holds the name under which the brokers are registered
+	Service string
+
+	// Tag, when set, limits the brokers to those registered with the tag
+	Tag string
+
+	// Datacenter defaults to that of the consul agent
+	Datacenter string
+
+	// Token holds an optional acl token
+	Token string
+}
+
+// ConsulDiscovery returns a Discovery that reads the bootstrap brokers from
+// the Consul catalog
+func ConsulDiscovery(config ConsulConfig) Discovery {
+	if config.Addr == "" {
+		config.Addr = "http://127.0.0.1:8500"
+	}
+	return consulDiscovery{config: config}
+}
+
+type consulDiscovery struct {
+	config ConsulConfig
+}
+
+// consulService holds the fields of a catalog entry used by kag
+type consulService struct {
+	Address        string
+	ServiceAddress string
+	ServicePort    int
+}
+
+func (c consulDiscovery) Discover(ctx context.Context) ([]string, error) {
+	query := url.Values{}
+	if c.config.Tag != "" {
+		query.Set("tag", c.config.Tag)
+	}
+	if c.config.Datacenter != "" {
+		query.Set("dc", c.config.Datacenter)
+	}
+	u := strings.TrimSuffix(c.config.Addr, "/") + "/v1/catalog/service/" + url.PathEscape(c.config.Service)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid consul address, %v", c.config.Addr)
+	}
+	if c.config.Token != "" {
+		req.Header.Set("X-Consul-Token", c.config.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to query consul for service, %v", c.config.Service)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unable to query consul for service, %v: %v", c.config.Service, resp.Status)
+	}
+
+	var services []consulService
+	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode consul response")
+	}
+
+	var brokers []string
+	for _, service := range services {
+		host := service.ServiceAddress
+		if host == "" {
+			host = service.Address
+		}
+		brokers = append(brokers, net.JoinHostPort(host, strconv.Itoa(service.ServicePort)))
+	}
+	return brokers, nil
+}
+
+// ParseDiscovery returns the Discovery described by a url of the form
+// srv://_kafka._tcp.example.com or
+// consul://127.0.0.1:8500/kafka?tag=prod&dc=east&token=secret.  Use
+// consul+https:// to reach consul over https.
+func ParseDiscovery(rawurl string) (Discovery, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid discovery url")
+	}
+
+	switch u.Scheme {
+	case "srv":
+		if u.Host == "" {
+			return nil, errors.Errorf("srv discovery requires a name e.g. srv://_kafka._tcp.example.com")
+		}
+		return SRVDiscovery(u.Host), nil
+
+	case "consul", "consul+https":
+		service := strings.Trim(u.Path, "/")
+		if service == "" {
+			return nil, errors.Errorf("consul discovery requires a service e.g. consul://127.0.0.1:8500/kafka")
+		}
+		scheme := "http"
+		if u.Scheme == "consul+https" {
+			scheme = "https"
+		}
+		query := u.Query()
+		return ConsulDiscovery(ConsulConfig{
+			Addr:       scheme + "://" + u.Host,
+			Service:    service,
+			Tag:        query.Get("tag"),
+			Datacenter: query.Get("dc"),
+			Token:      query.Get("token"),
+		}), nil
+
+	default:
+		return nil, errors.Errorf("unsupported discovery scheme, %v.  valid schemes srv, consul, consul+https", u.Scheme)
+	}
+}
+
+// discovered caches the bootstrap brokers found by Config.Discovery
+type discovered struct {
+	mutex   sync.Mutex
+	brokers []string
+	expires time.Time
+}
+
+// bootstrap returns the brokers to connect to first.  When Discovery is set,
+// the discovered brokers are used, re-discovered every DiscoveryInterval.
+// Brokers is used if discovery fails and no brokers were found before.
+func (c *Client) bootstrap(ctx context.Context) []string {
+	if c.config.Discovery == nil {
+		return c.config.Brokers
+	}
+
+	d := c.discovered
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if now := time.Now(); now.After(d.expires) {
+		brokers, err := c.config.Discovery.Discover(ctx)
+		switch {
+		case err != nil:
+			c.debug("unable to discover brokers: %v", err)
+		case len(brokers) == 0:
+			c.debug("unable to discover brokers: none found")
+		default:
+			if !stringsEqual(brokers, d.brokers) {
+				c.debug("discovered brokers, %v", strings.Join(brokers, ","))
+			}
+			d.brokers = brokers
+			d.expires = now.Add(c.config.DiscoveryInterval)
+		}
+	}
+
+	if len(d.brokers) == 0 {
+		return c.config.Brokers
+	}
+	return d.brokers
+}
+
+// rediscover forces the brokers to be discovered again by the next call to
+// bootstrap e.g. after none of them could be reached
+func (c *Client) rediscover() {
+	if c.config.Discovery == nil {
+		return
+	}
+
+	c.discovered.mutex.Lock()
+	defer c.discovered.mutex.Unlock()
+	c.discovered.expires = time.Time{}
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,186 @@
+package kag
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/savaki/kag/kagtest"
+	"github.com/tj/assert"
+)
+
+func TestSRVDiscovery(t *testing.T) {
+	defer func(v func(context.Context, string, string, string) (string, []*net.SRV, error)) { lookupSRV = v }(lookupSRV)
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "_kafka._tcp.example.com", name)
+		return "", []*net.SRV{
+			{Target: "kafka-1.example.com.", Port: 9092},
+			{Target: "kafka-2.example.com.", Port: 9093},
+		}, nil
+	}
+
+	brokers, err := SRVDiscovery("_kafka._tcp.example.com").Discover(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"kafka-1.example.com:9092", "kafka-2.example.com:9093"}, brokers)
+}
+
+func TestConsulDiscovery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Consul-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		assert.Equal(t, "/v1/catalog/service/kafka", req.URL.Path)
+		assert.Equal(t, "prod", req.URL.Query().Get("tag"))
+		assert.Equal(t, "east", req.URL.Query().Get("dc"))
+
+		json.NewEncoder(w).Encode([]consulService{
+			{Address: "10.0.0.1", ServicePort: 9092},
+			{Address: "10.0.0.2", ServiceAddress: "10.0.1.2", ServicePort: 9093},
+		})
+	}))
+	defer server.Close()
+
+	discovery := ConsulDiscovery(ConsulConfig{
+		Addr:       server.URL,
+		Service:    "kafka",
+		Tag:        "prod",
+		Datacenter: "east",
+		Token:      "secret",
+	})
+	brokers, err := discovery.Discover(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.1:9092", "10.0.1.2:9093"}, brokers)
+
+	_, err = ConsulDiscovery(ConsulConfig{Addr: server.URL, Service: "kafka"}).Discover(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestParseDiscovery(t *testing.T) {
+	testCases := map[string]struct {
+		URL  string
+		Want Discovery
+		Err  bool
+	}{
+		"srv": {
+			URL:  "srv://_kafka._tcp.example.com",
+			Want: SRVDiscovery("_kafka._tcp.example.com"),
+		},
+		"consul": {
+			URL:  "consul://127.0.0.1:8500/kafka?tag=prod&dc=east&token=secret",
+			Want: ConsulDiscovery(ConsulConfig{Addr: "http://127.0.0.1:8500", Service: "kafka", Tag: "prod", Datacenter: "east", Token: "secret"}),
+		},
+		"consul https": {
+			URL:  "consul+https://consul.example.com/kafka",
+			Want: ConsulDiscovery(ConsulConfig{Addr: "https://consul.example.com", Service: "kafka"}),
+		},
+		"consul without service": {
+			URL: "consul://127.0.0.1:8500",
+			Err: true,
+		},
+		"unsupported": {
+			URL: "zookeeper://127.0.0.1:2181",
+			Err: true,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			discovery, err := ParseDiscovery(tc.URL)
+			if tc.Err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.Want, discovery)
+		})
+	}
+}
+
+// consulCatalog serves the brokers of a cluster as the consul catalog would
+type consulCatalog struct {
+	mutex   sync.Mutex
+	brokers []string
+	status  int
+}
+
+func (c *consulCatalog) set(status int, brokers ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.status, c.brokers = status, brokers
+}
+
+func (c *consulCatalog) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.status != http.StatusOK {
+		w.WriteHeader(c.status)
+		return
+	}
+
+	var services []consulService
+	for _, broker := range c.brokers {
+		host, port, _ := net.SplitHostPort(broker)
+		v, _ := strconv.Atoi(port)
+		services = append(services, consulService{Address: host, ServicePort: v})
+	}
+	json.NewEncoder(w).Encode(services)
+}
+
+func TestDiscovery(t *testing.T) {
+	a := newCluster(t)
+	defer a.Close()
+
+	b, err := kagtest.NewCluster(1)
+	assert.Nil(t, err)
+	defer b.Close()
+	assert.Nil(t, b.CreateTopic("payments", 1, 1))
+
+	catalog := &consulCatalog{}
+	catalog.set(http.StatusOK, a.Addrs()[1:]...)
+	server := httptest.NewServer(catalog)
+	defer server.Close()
+
+	config := testConfig(a)
+	config.Brokers = nil
+	config.Discovery = ConsulDiscovery(ConsulConfig{Addr: server.URL, Service: "kafka"})
+	config.DiscoveryInterval = 50 * time.Millisecond
+	client := NewClient(config)
+
+	snapshot, err := client.Scrape(context.Background())
+	assert.Nil(t, err)
+	assert.Contains(t, snapshot.Topics, "orders")
+
+	t.Run("bootstrap hosts change", func(t *testing.T) {
+		catalog.set(http.StatusOK, b.Addrs()...)
+		time.Sleep(config.DiscoveryInterval)
+
+		snapshot, err := client.Scrape(context.Background())
+		assert.Nil(t, err)
+		assert.Contains(t, snapshot.Topics, "payments")
+	})
+
+	t.Run("discovery fails", func(t *testing.T) {
+		catalog.set(http.StatusInternalServerError)
+		time.Sleep(config.DiscoveryInterval)
+
+		// the brokers discovered before are kept
+		snapshot, err := client.Scrape(context.Background())
+		assert.Nil(t, err)
+		assert.Contains(t, snapshot.Topics, "payments")
+
+		// Brokers is used when nothing has been discovered
+		config := config
+		config.Brokers = a.Addrs()
+		snapshot, err = NewClient(config).Scrape(context.Background())
+		assert.Nil(t, err)
+		assert.Contains(t, snapshot.Topics, "orders")
+	})
+}
@@ -0,0 +1,245 @@
+package kag
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OffsetsExportVersion identifies the current format of OffsetsExport
+const OffsetsExportVersion = 1
+
+// csvExportPrefix begins the first line of a csv export
+const csvExportPrefix = "# kag offsets export"
+
+// OffsetsExport holds the committed offsets of every consumer group in a
+// cluster at a point in time
+type OffsetsExport struct {
+	Version int       `json:"version"`
+	Cluster string    `json:"cluster"`
+	Time    time.Time `json:"time"`
+
+	// Groups holds the committed offset by group, topic, and partition
+	Groups map[string]map[string]map[int32]int64 `json:"groups"`
+}
+
+// ExportOffsets collects the committed offsets of every consumer group
+func (c *Client) ExportOffsets(ctx context.Context) (*OffsetsExport, error) {
+	snapshot, err := c.Scrape(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OffsetsExport{
+		Version: OffsetsExportVersion,
+		Cluster: snapshot.Cluster,
+		Time:    snapshot.Time.UTC(),
+		Groups:  committedOffsets(snapshot.Groups),
+	}, nil
+}
+
+// committedOffsets returns a copy of groups without the partitions, reported
+// as -1, that were never committed
+func committedOffsets(groups map[string]map[string]map[int32]int64) map[string]map[string]map[int32]int64 {
+	committed := map[string]map[string]map[int32]int64{}
+	for groupID, topics := range groups {
+		if offsets := committedTopicOffsets(topics); len(offsets) > 0 {
+			committed[groupID] = offsets
+		}
+	}
+	return committed
+}
+
+// committedTopicOffsets returns a copy of topics without the partitions that
+// were never committed
+func committedTopicOffsets(topics map[string]map[int32]int64) topicOffsets {
+	committed := topicOffsets{}
+	for topic, partitions := range topics {
+		for partition, offset := range partitions {
+			if offset >= 0 {
+				committed.add(topic, partition, offset)
+			}
+		}
+	}
+	return committed
+}
+
+// WriteJSON writes the export as json
+func (e *OffsetsExport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(e)
+}
+
+// WriteCSV writes the export as a header line followed by csv records of
+// group,topic,partition,offset
+func (e *OffsetsExport) WriteCSV(w io.Writer) error {
+	fmt.Fprintf(w, "%v version=%v cluster=%v time=%v\n", csvExportPrefix, e.Version, e.Cluster, e.Time.Format(time.RFC3339))
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"group", "topic", "partition", "offset"})
+
+	var groupIDs []string
+	for groupID := range e.Groups {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Strings(groupIDs)
+
+	for _, groupID := range groupIDs {
+		topics := e.Groups[groupID]
+		var names []string
+		for topic := range topics {
+			names = append(names, topic)
+		}
+		sort.Strings(names)
+
+		for _, topic := range names {
+			var partitions []int32
+			for partition := range topics[topic] {
+				partitions = append(partitions, partition)
+			}
+			sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+			for _, partition := range partitions {
+				cw.Write([]string{
+					groupID,
+					topic,
+					strconv.Itoa(int(partition)),
+					strconv.FormatInt(topics[topic][partition], 10),
+				})
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadOffsetsExport reads an export written by either WriteJSON or WriteCSV
+func ReadOffsetsExport(r io.Reader) (*OffsetsExport, error) {
+	br := bufio.NewReader(r)
+	peek, err := br.Peek(len(csvExportPrefix))
+	if err == nil && string(peek) == csvExportPrefix {
+		return readCSVExport(br)
+	}
+
+	var e OffsetsExport
+	if err := json.NewDecoder(br).Decode(&e); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode offsets export")
+	}
+	if e.Version != OffsetsExportVersion {
+		return nil, errors.Errorf("unsupported offsets export version, %v", e.Version)
+	}
+	return &e, nil
+}
+
+func readCSVExport(r *bufio.Reader) (*OffsetsExport, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read offsets export header")
+	}
+
+	e := &OffsetsExport{
+		Groups: map[string]map[string]map[int32]int64{},
+	}
+	for _, field := range strings.Fields(strings.TrimPrefix(line, csvExportPrefix)) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "version":
+			e.Version, _ = strconv.Atoi(kv[1])
+		case "cluster":
+			e.Cluster = kv[1]
+		case "time":
+			e.Time, _ = time.Parse(time.RFC3339, kv[1])
+		}
+	}
+	if e.Version != OffsetsExportVersion {
+		return nil, errors.Errorf("unsupported offsets export version, %v", e.Version)
+	}
+
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read offsets export")
+	}
+	for index, record := range records {
+		if index == 0 && len(record) > 0 && record[0] == "group" {
+			continue
+		}
+		if len(record) != 4 {
+			return nil, errors.Errorf("record %v: expected group,topic,partition,offset", index+1)
+		}
+
+		partition, err := strconv.ParseInt(record[2], 10, 32)
+		if err != nil {
+			return nil, errors.Errorf("record %v: invalid partition, %v", index+1, record[2])
+		}
+		offset, err := strconv.ParseInt(record[3], 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("record %v: invalid offset, %v", index+1, record[3])
+		}
+
+		topics, ok := e.Groups[record[0]]
+		if !ok {
+			topics = topicOffsets{}
+			e.Groups[record[0]] = topics
+		}
+		topicOffsets(topics).add(record[1], int32(partition), offset)
+	}
+
+	return e, nil
+}
+
+// PlanOffsetsImport validates the export against the current state of the
+// cluster and returns a ResetPlan for each group.  Offsets outside the range
+// currently available on the brokers are clamped and noted; negative offsets,
+// which mark partitions that were never committed, are skipped.  If groupIDs
+// is non-empty, only the listed groups are planned.
+func (c *Client) PlanOffsetsImport(ctx context.Context, e *OffsetsExport, groupIDs []string) ([]*ResetPlan, error) {
+	if len(groupIDs) == 0 {
+		for groupID := range e.Groups {
+			groupIDs = append(groupIDs, groupID)
+		}
+	}
+	sort.Strings(groupIDs)
+
+	snapshot, err := c.Scrape(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var plans []*ResetPlan
+	for _, groupID := range groupIDs {
+		offsets, ok := e.Groups[groupID]
+		if !ok {
+			return nil, errors.Errorf("consumer group, %v, not found in export", groupID)
+		}
+
+		description, err := c.DescribeGroup(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+
+		plan, err := planReset(snapshot, nil, description, nil, ResetSpec{
+			Strategy: ResetFromOffsets,
+			Offsets:  committedTopicOffsets(offsets),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid offsets for consumer group, %v", groupID)
+		}
+		plans = append(plans, plan)
+	}
+
+	return plans, nil
+}
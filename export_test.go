@@ -0,0 +1,55 @@
+package kag
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+func TestOffsetsExportRoundTrip(t *testing.T) {
+	export := &OffsetsExport{
+		Version: OffsetsExportVersion,
+		Cluster: "local",
+		Time:    time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		Groups: map[string]map[string]map[int32]int64{
+			"a": {"topic": {0: 10, 1: 20}},
+			"b": {"other": {0: 5}},
+		},
+	}
+
+	writers := map[string]func(*OffsetsExport, *bytes.Buffer) error{
+		"json": func(e *OffsetsExport, buf *bytes.Buffer) error { return e.WriteJSON(buf) },
+		"csv":  func(e *OffsetsExport, buf *bytes.Buffer) error { return e.WriteCSV(buf) },
+	}
+
+	for label, write := range writers {
+		t.Run(label, func(t *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			assert.Nil(t, write(export, buf))
+
+			got, err := ReadOffsetsExport(buf)
+			assert.Nil(t, err)
+			assert.Equal(t, export.Cluster, got.Cluster)
+			assert.True(t, export.Time.Equal(got.Time))
+			assert.Equal(t, export.Groups, got.Groups)
+		})
+	}
+
+	t.Run("version", func(t *testing.T) {
+		_, err := ReadOffsetsExport(bytes.NewBufferString(`{"version":99}`))
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCommittedOffsets(t *testing.T) {
+	groups := map[string]map[string]map[int32]int64{
+		"a": {"topic": {0: 10, 1: -1}},
+		"b": {"topic": {0: -1}},
+	}
+	want := map[string]map[string]map[int32]int64{
+		"a": {"topic": {0: 10}},
+	}
+	assert.Equal(t, want, committedOffsets(groups))
+}
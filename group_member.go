@@ -0,0 +1,291 @@
+package kag
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/savaki/franz"
+	"github.com/savaki/kag/internal/wire"
+)
+
+// DefaultSessionTimeout is the session timeout used by coordination groups
+// when Config.SessionTimeout is not set
+const DefaultSessionTimeout = 10 * time.Second
+
+// coordinationProtocolType distinguishes the coordination groups joined by
+// kag from consumer groups
+const coordinationProtocolType = "kag"
+
+// membership describes a single generation of a coordination group as seen
+// by one member
+type membership struct {
+	GenerationID int32
+	MemberID     string
+	Leader       bool
+
+	// Assignment holds the assignment made to the member by the leader
+	Assignment []byte
+
+	// Expires holds the time after which the membership may have been revoked
+	// by the coordinator without the member having heard
+	Expires time.Time
+}
+
+// valid returns true if the member joined and has not since lost its session
+func (m membership) valid(now time.Time) bool {
+	return m.MemberID != "" && now.Before(m.Expires)
+}
+
+// groupMember maintains membership of a coordination group shared by kag
+// instances, rejoining as instances come and go
+type groupMember struct {
+	client         *Client
+	groupID        string
+	protocol       string
+	sessionTimeout time.Duration
+
+	// assign, if set, is called by the leader with the ids of every member
+	// and returns the assignment of each
+	assign func(memberIDs []string) map[string][]byte
+
+	// changed is signalled each time the membership changes
+	changed chan struct{}
+
+	// memberID is assigned by the coordinator and only accessed by run
+	memberID string
+
+	mutex sync.Mutex
+	state membership
+}
+
+func newGroupMember(client *Client, groupID, protocol string, sessionTimeout time.Duration) *groupMember {
+	if sessionTimeout == 0 {
+		sessionTimeout = DefaultSessionTimeout
+	}
+	return &groupMember{
+		client:         client,
+		groupID:        groupID,
+		protocol:       protocol,
+		sessionTimeout: sessionTimeout,
+		changed:        make(chan struct{}, 1),
+	}
+}
+
+// membership returns the current membership
+func (g *groupMember) membership() membership {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.state
+}
+
+func (g *groupMember) setState(state membership) {
+	g.mutex.Lock()
+	previous := g.state
+	g.state = state
+	g.mutex.Unlock()
+
+	if previous.GenerationID == state.GenerationID && previous.MemberID == state.MemberID && previous.Leader == state.Leader && bytes.Equal(previous.Assignment, state.Assignment) {
+		return
+	}
+	g.client.debug("group %v: generation %v, member %v, leader %v", g.groupID, state.GenerationID, state.MemberID, state.Leader)
+	select {
+	case g.changed <- struct{}{}:
+	default:
+	}
+}
+
+// heartbeatInterval returns how often the member heartbeats
+func (g *groupMember) heartbeatInterval() time.Duration {
+	return g.sessionTimeout / 3
+}
+
+// run joins the group and maintains membership until the context is
+// cancelled, at which point the member leaves the group
+func (g *groupMember) run(ctx context.Context) {
+	for {
+		if err := g.session(ctx); err != nil {
+			g.client.debug("group %v: %v", g.groupID, err)
+		}
+		g.setState(membership{})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(g.heartbeatInterval()):
+		}
+	}
+}
+
+// session joins the group through its coordinator and heartbeats until an
+// error occurs or the context is cancelled
+func (g *groupMember) session(ctx context.Context) error {
+	broker, err := g.client.findCoordinator(ctx, g.groupID)
+	if err != nil {
+		return err
+	}
+
+	// JoinGroup may be held by the coordinator for up to the rebalance
+	// timeout, which kag sets to the session timeout
+	addr := fmt.Sprintf("%v:%v", broker.Host, broker.Port)
+	conn, err := g.client.dialTimeout(ctx, addr, g.client.requestTimeout()+g.sessionTimeout)
+	if err != nil {
+		return errors.Wrapf(err, "unable to connect to coordinator, %v", addr)
+	}
+	defer conn.Close()
+
+	for {
+		state, err := g.join(conn)
+		if err != nil {
+			return err
+		}
+		g.setState(state)
+
+		if err := g.heartbeat(ctx, conn, state); err != nil {
+			if err == franz.RebalanceInProgress {
+				g.setState(membership{})
+				continue
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// join joins the group and returns the resulting membership
+func (g *groupMember) join(conn *wire.Conn) (membership, error) {
+	started := time.Now()
+	timeout := int32(g.sessionTimeout / time.Millisecond)
+
+	var join wire.JoinGroupResponse
+	err := do(conn, wire.JoinGroupKey, wire.JoinGroupRequest{
+		GroupID:          g.groupID,
+		SessionTimeout:   timeout,
+		RebalanceTimeout: timeout,
+		MemberID:         g.memberID,
+		ProtocolType:     coordinationProtocolType,
+		Protocols:        []wire.GroupProtocol{{Name: g.protocol, Metadata: []byte{}}},
+	}.Encode, func(d *wire.Decoder) error {
+		join.Decode(d)
+		return nil
+	})
+	if err != nil {
+		return membership{}, errors.Wrapf(err, "unable to join group, %v", g.groupID)
+	}
+	switch franz.Error(join.ErrorCode) {
+	case 0:
+	case franz.UnknownMemberId:
+		g.memberID = ""
+		fallthrough
+	default:
+		return membership{}, errors.Wrapf(franz.Error(join.ErrorCode), "unable to join group, %v", g.groupID)
+	}
+	g.memberID = join.MemberID
+
+	sync := wire.SyncGroupRequest{
+		GroupID:      g.groupID,
+		GenerationID: join.GenerationID,
+		MemberID:     join.MemberID,
+	}
+	if join.LeaderID == join.MemberID {
+		var memberIDs []string
+		for _, member := range join.Members {
+			memberIDs = append(memberIDs, member.MemberID)
+		}
+		var assignments map[string][]byte
+		if g.assign != nil {
+			assignments = g.assign(memberIDs)
+		}
+		for _, memberID := range memberIDs {
+			assignment := assignments[memberID]
+			if assignment == nil {
+				assignment = []byte{}
+			}
+			sync.Assignments = append(sync.Assignments, wire.GroupAssignment{
+				MemberID:   memberID,
+				Assignment: assignment,
+			})
+		}
+	}
+
+	var resp wire.SyncGroupResponse
+	err = do(conn, wire.SyncGroupKey, sync.Encode, func(d *wire.Decoder) error {
+		resp.Decode(d)
+		return nil
+	})
+	if err != nil {
+		return membership{}, errors.Wrapf(err, "unable to sync group, %v", g.groupID)
+	}
+	if resp.ErrorCode != 0 {
+		return membership{}, errors.Wrapf(franz.Error(resp.ErrorCode), "unable to sync group, %v", g.groupID)
+	}
+
+	return membership{
+		GenerationID: join.GenerationID,
+		MemberID:     join.MemberID,
+		Leader:       join.LeaderID == join.MemberID,
+		Assignment:   resp.Assignment,
+		Expires:      started.Add(g.sessionTimeout),
+	}, nil
+}
+
+// heartbeat keeps the membership alive until the context is cancelled, in
+// which case the member leaves the group and nil is returned.  Returns
+// franz.RebalanceInProgress when the member must rejoin.
+func (g *groupMember) heartbeat(ctx context.Context, conn *wire.Conn, state membership) error {
+	ticker := time.NewTicker(g.heartbeatInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			g.leave(conn)
+			return nil
+		case <-ticker.C:
+		}
+
+		sent := time.Now()
+		var resp wire.ErrorResponse
+		err := do(conn, wire.HeartbeatKey, wire.HeartbeatRequest{
+			GroupID:      g.groupID,
+			GenerationID: state.GenerationID,
+			MemberID:     state.MemberID,
+		}.Encode, func(d *wire.Decoder) error {
+			resp.Decode(d)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "unable to heartbeat group, %v", g.groupID)
+		}
+
+		switch code := franz.Error(resp.ErrorCode); code {
+		case 0:
+			state.Expires = sent.Add(g.sessionTimeout)
+			g.setState(state)
+		case franz.RebalanceInProgress:
+			return code
+		case franz.UnknownMemberId:
+			g.memberID = ""
+			fallthrough
+		default:
+			return errors.Wrapf(code, "unable to heartbeat group, %v", g.groupID)
+		}
+	}
+}
+
+// leave leaves the group so that the remaining members rebalance without
+// waiting for the session to expire
+func (g *groupMember) leave(conn *wire.Conn) {
+	err := do(conn, wire.LeaveGroupKey, wire.LeaveGroupRequest{
+		GroupID:  g.groupID,
+		MemberID: g.memberID,
+	}.Encode, nil)
+	if err != nil {
+		g.client.debug("group %v: unable to leave: %v", g.groupID, err)
+	}
+	g.memberID = ""
+}
@@ -0,0 +1,78 @@
+package kag
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Health reports the progress of the Monitor's polling goroutine
+type Health struct {
+	// Interval holds the configured polling interval
+	Interval time.Duration
+
+	// Started holds the time the Monitor was started
+	Started time.Time
+
+	// LastScrape holds the time the most recent scrape completed or the zero
+	// value if no scrape has completed
+	LastScrape time.Time
+
+	// LastProgress holds the last time the polling goroutine completed a unit
+	// of work, successful or not
+	LastProgress time.Time
+
+	// LastError holds the most recent error returned by a scrape, if any
+	LastError error
+
+	// Standby is true when another instance leads Config.ElectionGroup, or
+	// the Monitor is waiting to join Config.ShardGroup, and so skipped its
+	// most recent scrape
+	Standby bool
+}
+
+// Ready returns nil if a scrape has completed within two polling intervals
+// or the Monitor is a standby
+func (h Health) Ready(now time.Time) error {
+	if h.Standby {
+		return nil
+	}
+	if h.LastScrape.IsZero() {
+		return errors.Errorf("no scrape has completed since %v", h.Started.Format(time.RFC3339))
+	}
+	if age := now.Sub(h.LastScrape); age > 2*h.Interval {
+		return errors.Errorf("last scrape completed %v ago", age.Truncate(time.Second))
+	}
+	return nil
+}
+
+// Live returns nil if the polling goroutine has made progress recently.  The
+// allowance covers two polling intervals plus the delay between retries.
+func (h Health) Live(now time.Time) error {
+	last := h.LastProgress
+	if last.IsZero() {
+		last = h.Started
+	}
+	if age := now.Sub(last); age > 2*h.Interval+retryDelay {
+		return errors.Errorf("polling goroutine has not made progress in %v", age.Truncate(time.Second))
+	}
+	return nil
+}
+
+// Health returns the current Health of the Monitor
+func (m *Monitor) Health() Health {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.health
+}
+
+func (m *Monitor) progress(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.health.LastProgress = time.Now()
+	if err != nil {
+		m.health.LastError = err
+	}
+}
@@ -0,0 +1,48 @@
+package kag
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+func TestHealth(t *testing.T) {
+	now := time.Now()
+	interval := time.Minute
+
+	testCases := map[string]struct {
+		Health Health
+		Ready  bool
+		Live   bool
+	}{
+		"starting": {
+			Health: Health{Interval: interval, Started: now.Add(-time.Second)},
+			Live:   true,
+		},
+		"scraped": {
+			Health: Health{Interval: interval, Started: now.Add(-time.Hour), LastScrape: now.Add(-interval), LastProgress: now.Add(-interval)},
+			Ready:  true,
+			Live:   true,
+		},
+		"failing": {
+			Health: Health{Interval: interval, Started: now.Add(-time.Hour), LastScrape: now.Add(-time.Hour), LastProgress: now.Add(-time.Second)},
+			Live:   true,
+		},
+		"standby": {
+			Health: Health{Interval: interval, Started: now.Add(-time.Hour), LastProgress: now.Add(-time.Second), Standby: true},
+			Ready:  true,
+			Live:   true,
+		},
+		"stuck": {
+			Health: Health{Interval: interval, Started: now.Add(-time.Hour), LastScrape: now.Add(-time.Hour), LastProgress: now.Add(-time.Hour)},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			assert.Equal(t, tc.Ready, tc.Health.Ready(now) == nil)
+			assert.Equal(t, tc.Live, tc.Health.Live(now) == nil)
+		})
+	}
+}
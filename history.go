@@ -0,0 +1,599 @@
+package kag
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Resolution identifies one of the series kept by History
+type Resolution int
+
+const (
+	// ResolutionRaw holds one sample per scrape
+	ResolutionRaw Resolution = iota
+
+	// ResolutionMinute holds one sample per minute
+	ResolutionMinute
+
+	// ResolutionHour holds one sample per hour
+	ResolutionHour
+)
+
+var resolutions = []Resolution{ResolutionRaw, ResolutionMinute, ResolutionHour}
+
+func (r Resolution) String() string {
+	switch r {
+	case ResolutionMinute:
+		return "1m"
+	case ResolutionHour:
+		return "1h"
+	default:
+		return "raw"
+	}
+}
+
+// Duration returns the width of a sample at the resolution; zero for raw
+func (r Resolution) Duration() time.Duration {
+	switch r {
+	case ResolutionMinute:
+		return time.Minute
+	case ResolutionHour:
+		return time.Hour
+	default:
+		return 0
+	}
+}
+
+// Default retention of each History resolution
+const (
+	DefaultRawRetention    = 24 * time.Hour
+	DefaultMinuteRetention = 7 * 24 * time.Hour
+	DefaultHourRetention   = 90 * 24 * time.Hour
+)
+
+// HistoryRetention specifies how long samples of each resolution are kept.
+// Zero values use the defaults.
+type HistoryRetention struct {
+	Raw    time.Duration
+	Minute time.Duration
+	Hour   time.Duration
+}
+
+func (h HistoryRetention) of(r Resolution) time.Duration {
+	switch r {
+	case ResolutionMinute:
+		return h.Minute
+	case ResolutionHour:
+		return h.Hour
+	default:
+		return h.Raw
+	}
+}
+
+// HistorySample holds the offsets and lag of a single scrape or, for the
+// rolled up resolutions, of a single minute or hour.  Rolled up samples are
+// timestamped with the start of the period and hold the last offsets and the
+// largest lag observed during it.
+type HistorySample struct {
+	Time   time.Time                             `json:"time"`
+	Newest map[string]map[int32]int64            `json:"newest"`
+	Oldest map[string]map[int32]int64            `json:"oldest"`
+	Groups map[string]map[string]map[int32]int64 `json:"groups"`
+	Lag    map[string]map[string]map[int32]int64 `json:"lag"`
+}
+
+// Snapshot returns the sample as a Snapshot holding only offsets and lag
+func (s HistorySample) Snapshot() *Snapshot {
+	return &Snapshot{
+		Time:   s.Time,
+		Newest: s.Newest,
+		Oldest: s.Oldest,
+		Groups: s.Groups,
+		Lag:    s.Lag,
+	}
+}
+
+func makeHistorySample(snapshot *Snapshot) HistorySample {
+	sample := HistorySample{
+		Time:   snapshot.Time.UTC(),
+		Newest: topicOffsets(snapshot.Newest).copy(),
+		Oldest: topicOffsets(snapshot.Oldest).copy(),
+		Groups: map[string]map[string]map[int32]int64{},
+		Lag:    map[string]map[string]map[int32]int64{},
+	}
+	for groupID, topics := range snapshot.Groups {
+		sample.Groups[groupID] = topicOffsets(topics).copy()
+	}
+	for groupID, topics := range snapshot.Lag {
+		sample.Lag[groupID] = topicOffsets(topics).copy()
+	}
+	return sample
+}
+
+// merge folds a later sample into a rolled up sample
+func (s *HistorySample) merge(sample HistorySample) {
+	for topic, partitions := range sample.Newest {
+		for partition, offset := range partitions {
+			topicOffsets(s.Newest).add(topic, partition, offset)
+		}
+	}
+	for topic, partitions := range sample.Oldest {
+		for partition, offset := range partitions {
+			topicOffsets(s.Oldest).add(topic, partition, offset)
+		}
+	}
+	for groupID, topics := range sample.Groups {
+		if s.Groups[groupID] == nil {
+			s.Groups[groupID] = map[string]map[int32]int64{}
+		}
+		for topic, partitions := range topics {
+			for partition, offset := range partitions {
+				topicOffsets(s.Groups[groupID]).add(topic, partition, offset)
+			}
+		}
+	}
+	for groupID, topics := range sample.Lag {
+		for topic, partitions := range topics {
+			for partition, lag := range partitions {
+				if v, ok := s.Lag[groupID][topic][partition]; !ok || lag > v {
+					lagRecorder(s.Lag).Observe(groupID, topic, partition, lag)
+				}
+			}
+		}
+	}
+}
+
+// segmentLayout names the daily files that hold the samples of a resolution
+const segmentLayout = "20060102"
+
+const segmentExt = ".jsonl"
+
+// maxHistoryLine limits the size of a single encoded sample
+const maxHistoryLine = 100 * 1024 * 1024
+
+// History is an embedded, file-backed store of scrape history.  Samples are
+// appended as json lines to one file per resolution per day under dir and
+// files older than the retention of their resolution are removed.  History
+// is safe for concurrent use.
+type History struct {
+	mutex     sync.Mutex
+	dir       string
+	retention HistoryRetention
+
+	// pending holds the rollup of the current period of each resolution
+	// other than raw
+	pending map[Resolution]*HistorySample
+
+	// latest holds the most recent raw sample, if any
+	latest *HistorySample
+}
+
+// OpenHistory opens or creates the history stored in dir.  Rollups
+// interrupted by a restart are rebuilt from the raw samples.
+func OpenHistory(dir string, retention HistoryRetention) (*History, error) {
+	if retention.Raw == 0 {
+		retention.Raw = DefaultRawRetention
+	}
+	if retention.Minute == 0 {
+		retention.Minute = DefaultMinuteRetention
+	}
+	if retention.Hour == 0 {
+		retention.Hour = DefaultHourRetention
+	}
+
+	for _, r := range resolutions {
+		if err := os.MkdirAll(filepath.Join(dir, r.String()), 0755); err != nil {
+			return nil, errors.Wrapf(err, "unable to create history directory, %v", dir)
+		}
+	}
+
+	h := &History{
+		dir:       dir,
+		retention: retention,
+		pending:   map[Resolution]*HistorySample{},
+	}
+
+	if err := h.repair(); err != nil {
+		return nil, err
+	}
+	if latest, ok, err := h.last(ResolutionRaw); err != nil {
+		return nil, err
+	} else if ok {
+		h.latest = &latest
+	}
+
+	for _, r := range []Resolution{ResolutionMinute, ResolutionHour} {
+		// the period last written may have been flushed by Close before it
+		// ended, so it resumes as the pending rollup.  Merging a raw sample
+		// twice has no effect, and Read keeps the later of the two rollups.
+		from := time.Now().Add(-retention.Raw)
+		if last, ok, err := h.last(r); err != nil {
+			return nil, err
+		} else if ok {
+			from = last.Time
+			pending := makeHistorySample(last.Snapshot())
+			h.pending[r] = &pending
+		}
+
+		samples, err := h.read(ResolutionRaw, from, time.Now().Add(time.Hour))
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range samples {
+			if err := h.rollup(r, sample); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return h, nil
+}
+
+// SetRetention changes the retention of the history, removing the files that
+// are now older than it.  Zero values use the defaults.
+func (h *History) SetRetention(retention HistoryRetention) error {
+	if retention.Raw == 0 {
+		retention.Raw = DefaultRawRetention
+	}
+	if retention.Minute == 0 {
+		retention.Minute = DefaultMinuteRetention
+	}
+	if retention.Hour == 0 {
+		retention.Hour = DefaultHourRetention
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.retention = retention
+	return h.prune()
+}
+
+// Close writes the rollups of the periods in progress.  Samples appended
+// after Close begin new rollups.
+func (h *History) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, r := range []Resolution{ResolutionMinute, ResolutionHour} {
+		if pending := h.pending[r]; pending != nil {
+			if err := h.write(r, *pending); err != nil {
+				return err
+			}
+			delete(h.pending, r)
+		}
+	}
+	return nil
+}
+
+// Append records the offsets and lag of the snapshot
+func (h *History) Append(snapshot *Snapshot) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	sample := makeHistorySample(snapshot)
+	if err := h.write(ResolutionRaw, sample); err != nil {
+		return err
+	}
+	h.latest = &sample
+	for _, r := range []Resolution{ResolutionMinute, ResolutionHour} {
+		if err := h.rollup(r, sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollup folds the sample into the pending period of the resolution,
+// writing the pending sample once a later period begins
+func (h *History) rollup(r Resolution, sample HistorySample) error {
+	start := sample.Time.Truncate(r.Duration())
+
+	pending := h.pending[r]
+	if pending != nil && pending.Time.Equal(start) {
+		pending.merge(sample)
+		return nil
+	}
+
+	if pending != nil {
+		if err := h.write(r, *pending); err != nil {
+			return err
+		}
+		if err := h.prune(); err != nil {
+			return err
+		}
+	}
+
+	next := makeHistorySample(sample.Snapshot())
+	next.Time = start
+	h.pending[r] = &next
+	return nil
+}
+
+func (h *History) write(r Resolution, sample HistorySample) error {
+	filename := filepath.Join(h.dir, r.String(), sample.Time.UTC().Format(segmentLayout)+segmentExt)
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open history file, %v", filename)
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return errors.Wrapf(err, "unable to encode history sample")
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return errors.Wrapf(err, "unable to write history file, %v", filename)
+	}
+	return nil
+}
+
+// repair truncates any line left partially written by a crash so that the
+// samples appended after it may be read
+func (h *History) repair() error {
+	for _, r := range resolutions {
+		days, err := h.segments(r)
+		if err != nil {
+			return err
+		}
+		for _, day := range days {
+			filename := filepath.Join(h.dir, r.String(), day.Format(segmentLayout)+segmentExt)
+			if err := truncatePartialLine(filename); err != nil {
+				return errors.Wrapf(err, "unable to repair history file, %v", filename)
+			}
+		}
+	}
+	return nil
+}
+
+// truncatePartialLine removes the bytes that follow the last newline of the
+// file, if any
+func truncatePartialLine(filename string) error {
+	f, err := os.OpenFile(filename, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil
+	}
+
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, size-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+
+	start, err := lineStart(f, size)
+	if err != nil {
+		return err
+	}
+	return f.Truncate(start)
+}
+
+// lineStart returns the offset of the first byte of the line that ends at
+// end, exclusive, i.e. the offset following the last newline before end
+func lineStart(r io.ReaderAt, end int64) (int64, error) {
+	buf := make([]byte, 64*1024)
+	for end > 0 {
+		n := int64(len(buf))
+		if n > end {
+			n = end
+		}
+		if _, err := r.ReadAt(buf[:n], end-n); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return end - n + int64(i) + 1, nil
+		}
+		end -= n
+	}
+	return 0, nil
+}
+
+// segments returns the days for which the resolution has samples in
+// ascending order
+func (h *History) segments(r Resolution) ([]time.Time, error) {
+	files, err := ioutil.ReadDir(filepath.Join(h.dir, r.String()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read history directory, %v", h.dir)
+	}
+
+	var days []time.Time
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		day, err := time.Parse(segmentLayout, strings.TrimSuffix(name, segmentExt))
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, nil
+}
+
+// readSegment reads the samples of a single day.  Lines that can't be
+// decoded are skipped.
+func (h *History) readSegment(r Resolution, day time.Time) ([]HistorySample, error) {
+	filename := filepath.Join(h.dir, r.String(), day.Format(segmentLayout)+segmentExt)
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open history file, %v", filename)
+	}
+	defer f.Close()
+
+	var samples []HistorySample
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxHistoryLine)
+	for scanner.Scan() {
+		var sample HistorySample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "unable to read history file, %v", filename)
+	}
+	return samples, nil
+}
+
+// Read returns the samples of the resolution from from through to inclusive
+// in ascending order of time.  For the rolled up resolutions, the sample of
+// the period in progress is included.
+func (h *History) Read(r Resolution, from, to time.Time) ([]HistorySample, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.read(r, from, to)
+}
+
+func (h *History) read(r Resolution, from, to time.Time) ([]HistorySample, error) {
+	days, err := h.segments(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []HistorySample
+	for _, day := range days {
+		if day.Add(24*time.Hour).Before(from) || day.After(to) {
+			continue
+		}
+
+		items, err := h.readSegment(r, day)
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range items {
+			if sample.Time.Before(from) || sample.Time.After(to) {
+				continue
+			}
+			samples = appendSample(samples, sample)
+		}
+	}
+
+	if pending := h.pending[r]; pending != nil && !pending.Time.Before(from) && !pending.Time.After(to) {
+		samples = appendSample(samples, *pending)
+	}
+
+	return samples, nil
+}
+
+// appendSample appends the sample, replacing the last one if it is of the
+// same period e.g. a rollup written by Close and rewritten after a restart
+func appendSample(samples []HistorySample, sample HistorySample) []HistorySample {
+	if n := len(samples); n > 0 && samples[n-1].Time.Equal(sample.Time) {
+		samples[n-1] = sample
+		return samples
+	}
+	return append(samples, sample)
+}
+
+// Latest returns the most recent raw sample
+func (h *History) Latest() (HistorySample, bool, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.latest == nil {
+		return HistorySample{}, false, nil
+	}
+	return *h.latest, true, nil
+}
+
+// last returns the most recently written sample of the resolution.  Segments
+// are read backwards from their end so that only the last line is decoded.
+func (h *History) last(r Resolution) (HistorySample, bool, error) {
+	days, err := h.segments(r)
+	if err != nil {
+		return HistorySample{}, false, err
+	}
+
+	for i := len(days) - 1; i >= 0; i-- {
+		filename := filepath.Join(h.dir, r.String(), days[i].Format(segmentLayout)+segmentExt)
+		sample, ok, err := lastSample(filename)
+		if err != nil {
+			return HistorySample{}, false, errors.Wrapf(err, "unable to read history file, %v", filename)
+		}
+		if ok {
+			return sample, true, nil
+		}
+	}
+	return HistorySample{}, false, nil
+}
+
+// lastSample decodes the last line of the file that holds a sample
+func lastSample(filename string) (HistorySample, bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return HistorySample{}, false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return HistorySample{}, false, err
+	}
+
+	// end excludes the newline that terminates the line
+	for end := info.Size() - 1; end > 0; {
+		start, err := lineStart(f, end)
+		if err != nil {
+			return HistorySample{}, false, err
+		}
+
+		var sample HistorySample
+		data := make([]byte, end-start)
+		if _, err := f.ReadAt(data, start); err != nil {
+			return HistorySample{}, false, err
+		}
+		if err := json.Unmarshal(data, &sample); err == nil {
+			return sample, true, nil
+		}
+		end = start - 1
+	}
+	return HistorySample{}, false, nil
+}
+
+// prune removes the files whose samples are all older than the retention of
+// their resolution
+func (h *History) prune() error {
+	now := time.Now()
+	for _, r := range resolutions {
+		days, err := h.segments(r)
+		if err != nil {
+			return err
+		}
+		for _, day := range days {
+			if day.Add(24 * time.Hour).After(now.Add(-h.retention.of(r))) {
+				break
+			}
+			filename := filepath.Join(h.dir, r.String(), day.Format(segmentLayout)+segmentExt)
+			if err := os.Remove(filename); err != nil {
+				return errors.Wrapf(err, "unable to remove history file, %v", filename)
+			}
+		}
+	}
+	return nil
+}
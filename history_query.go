@@ -0,0 +1,158 @@
+package kag
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Metrics that may be queried from History
+const (
+	MetricLag       = "lag"
+	MetricCommitted = "committed"
+	MetricNewest    = "newest"
+	MetricOldest    = "oldest"
+)
+
+// HistoryQuery selects a series from History.  Values are summed across
+// every partition that matches the query.
+type HistoryQuery struct {
+	// Metric is one of MetricLag, MetricCommitted, MetricNewest, or
+	// MetricOldest
+	Metric string
+
+	// Group is required by MetricLag and MetricCommitted and ignored
+	// otherwise
+	Group string
+
+	// Topic restricts the query to a single topic; required by MetricNewest
+	// and MetricOldest
+	Topic string
+
+	// Partition restricts the query to a single partition of Topic; -1 for
+	// all partitions
+	Partition int32
+
+	From time.Time
+	To   time.Time
+
+	// Step is the spacing of the returned points.  Within each step, lag
+	// reports the largest value and offsets the last.  Zero returns every
+	// sample.  The resolution read is the coarsest not wider than Step that
+	// still covers From.
+	Step time.Duration
+}
+
+// HistoryPoint holds the value of a series at a point in time
+type HistoryPoint struct {
+	Time  time.Time `json:"time"`
+	Value int64     `json:"value"`
+}
+
+// Validate returns an error if the query is incomplete
+func (q HistoryQuery) Validate() error {
+	switch q.Metric {
+	case MetricLag, MetricCommitted:
+		if q.Group == "" {
+			return errors.Errorf("metric, %v, requires a group", q.Metric)
+		}
+	case MetricNewest, MetricOldest:
+		if q.Topic == "" {
+			return errors.Errorf("metric, %v, requires a topic", q.Metric)
+		}
+	default:
+		return errors.Errorf("unknown metric, %v.  valid metrics lag, committed, newest, oldest", q.Metric)
+	}
+	if q.Partition >= 0 && q.Topic == "" {
+		return errors.Errorf("partition requires a topic")
+	}
+	if q.To.Before(q.From) {
+		return errors.Errorf("to must not be before from")
+	}
+	if q.Step < 0 {
+		return errors.Errorf("step must not be negative")
+	}
+	return nil
+}
+
+// resolution returns the resolution that best serves the query
+func (h *History) resolution(q HistoryQuery) Resolution {
+	r := ResolutionRaw
+	switch {
+	case q.Step >= time.Hour:
+		r = ResolutionHour
+	case q.Step >= time.Minute:
+		r = ResolutionMinute
+	}
+	for r < ResolutionHour && time.Since(q.From) > h.retention.of(r) {
+		r++
+	}
+	return r
+}
+
+// value returns the value of the query in the sample or false if the sample
+// holds no matching partitions
+func (q HistoryQuery) value(sample HistorySample) (int64, bool) {
+	var topics map[string]map[int32]int64
+	switch q.Metric {
+	case MetricLag:
+		topics = sample.Lag[q.Group]
+	case MetricCommitted:
+		topics = sample.Groups[q.Group]
+	case MetricNewest:
+		topics = sample.Newest
+	case MetricOldest:
+		topics = sample.Oldest
+	}
+
+	var total int64
+	var found bool
+	for topic, partitions := range topics {
+		if q.Topic != "" && topic != q.Topic {
+			continue
+		}
+		for partition, v := range partitions {
+			if q.Partition >= 0 && partition != q.Partition {
+				continue
+			}
+			total += v
+			found = true
+		}
+	}
+	return total, found
+}
+
+// Query returns the series selected by the query in ascending order of time
+func (h *History) Query(q HistoryQuery) ([]HistoryPoint, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	samples, err := h.Read(h.resolution(q), q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+
+	points := []HistoryPoint{}
+	for _, sample := range samples {
+		v, ok := q.value(sample)
+		if !ok {
+			continue
+		}
+
+		t := sample.Time
+		if q.Step > 0 {
+			t = q.From.Add(t.Sub(q.From) / q.Step * q.Step)
+		}
+
+		if n := len(points); n > 0 && points[n-1].Time.Equal(t) {
+			if q.Metric != MetricLag || v > points[n-1].Value {
+				points[n-1].Value = v
+			}
+			continue
+		}
+		points = append(points, HistoryPoint{Time: t, Value: v})
+	}
+
+	return points, nil
+}
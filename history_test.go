@@ -0,0 +1,231 @@
+package kag
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+func makeHistorySnapshot(t time.Time, newest, lag int64) *Snapshot {
+	return &Snapshot{
+		Time:   t,
+		Newest: map[string]map[int32]int64{"topic": {0: newest}},
+		Oldest: map[string]map[int32]int64{"topic": {0: 0}},
+		Groups: map[string]map[string]map[int32]int64{"group": {"topic": {0: newest - lag}}},
+		Lag:    map[string]map[string]map[int32]int64{"group": {"topic": {0: lag}}},
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kag-history")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+
+	h, err := OpenHistory(dir, HistoryRetention{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.Append(makeHistorySnapshot(start.Add(10*time.Second), 100, 5)))
+	assert.Nil(t, h.Append(makeHistorySnapshot(start.Add(40*time.Second), 110, 3)))
+	assert.Nil(t, h.Append(makeHistorySnapshot(start.Add(70*time.Second), 120, 1)))
+
+	raw, err := h.Read(ResolutionRaw, start, start.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, raw, 3)
+
+	minutes, err := h.Read(ResolutionMinute, start, start.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, minutes, 2)
+	assert.True(t, minutes[0].Time.Equal(start))
+	assert.Equal(t, int64(110), minutes[0].Newest["topic"][0], "last offset of the minute")
+	assert.Equal(t, int64(5), minutes[0].Lag["group"]["topic"][0], "largest lag of the minute")
+
+	t.Run("reopen", func(t *testing.T) {
+		h, err := OpenHistory(dir, HistoryRetention{})
+		assert.Nil(t, err)
+
+		reopened, err := h.Read(ResolutionMinute, start, start.Add(time.Hour))
+		assert.Nil(t, err)
+		assert.Equal(t, minutes, reopened)
+
+		hours, err := h.Read(ResolutionHour, start, start.Add(time.Hour))
+		assert.Nil(t, err)
+		assert.Len(t, hours, 1)
+		assert.Equal(t, int64(120), hours[0].Newest["topic"][0])
+
+		latest, ok, err := h.Latest()
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, int64(1), latest.Lag["group"]["topic"][0])
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		old := start.Add(-72 * time.Hour)
+		assert.Nil(t, h.write(ResolutionRaw, makeHistorySample(makeHistorySnapshot(old, 1, 0))))
+		assert.Nil(t, h.prune())
+
+		_, err := os.Stat(filepath.Join(dir, "raw", old.Format(segmentLayout)+segmentExt))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestHistoryClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kag-history")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+
+	h, err := OpenHistory(dir, HistoryRetention{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.Append(makeHistorySnapshot(start, 100, 9)))
+	assert.Nil(t, h.Close())
+
+	// the rollup written by Close resumes once reopened
+	h, err = OpenHistory(dir, HistoryRetention{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.Append(makeHistorySnapshot(start.Add(10*time.Second), 110, 2)))
+	assert.Nil(t, h.Append(makeHistorySnapshot(start.Add(time.Minute), 120, 1)))
+	assert.Nil(t, h.Close())
+
+	minutes, err := h.Read(ResolutionMinute, start, start.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, minutes, 2)
+	assert.Equal(t, int64(110), minutes[0].Newest["topic"][0])
+	assert.Equal(t, int64(9), minutes[0].Lag["group"]["topic"][0])
+
+	hours, err := h.Read(ResolutionHour, start, start.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, hours, 1)
+	assert.Equal(t, int64(120), hours[0].Newest["topic"][0])
+
+	old := start.Add(-48 * time.Hour)
+	filename := filepath.Join(dir, "raw", old.Format(segmentLayout)+segmentExt)
+	assert.Nil(t, h.write(ResolutionRaw, makeHistorySample(makeHistorySnapshot(old, 1, 0))))
+
+	assert.Nil(t, h.SetRetention(HistoryRetention{Raw: 72 * time.Hour}))
+	_, err = os.Stat(filename)
+	assert.Nil(t, err)
+
+	assert.Nil(t, h.SetRetention(HistoryRetention{}))
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestHistoryAfterCrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kag-history")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+
+	h, err := OpenHistory(dir, HistoryRetention{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.Append(makeHistorySnapshot(start, 100, 5)))
+	assert.Nil(t, h.Append(makeHistorySnapshot(start.Add(10*time.Second), 110, 5)))
+
+	// crash while writing the second sample
+	filename := filepath.Join(dir, "raw", start.Format(segmentLayout)+segmentExt)
+	info, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(filename, info.Size()-10))
+
+	h, err = OpenHistory(dir, HistoryRetention{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.Append(makeHistorySnapshot(start.Add(20*time.Second), 120, 5)))
+
+	raw, err := h.Read(ResolutionRaw, start, start.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, raw, 2)
+	assert.Equal(t, int64(100), raw[0].Newest["topic"][0])
+	assert.Equal(t, int64(120), raw[1].Newest["topic"][0])
+}
+
+func TestLastSample(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kag-history")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// a sample larger than the chunks read backwards followed by a line
+	// that can't be decoded
+	start := time.Now().UTC().Truncate(time.Hour)
+	snapshot := makeHistorySnapshot(start, 100, 5)
+	for i := int32(1); i < 10000; i++ {
+		snapshot.Newest["topic"][i] = int64(i)
+	}
+	data, err := json.Marshal(makeHistorySample(snapshot))
+	assert.Nil(t, err)
+	assert.True(t, len(data) > 64*1024)
+
+	filename := filepath.Join(dir, "segment.jsonl")
+	content := append([]byte("{}\n"), data...)
+	content = append(content, "\n{\n"...)
+	assert.Nil(t, ioutil.WriteFile(filename, content, 0644))
+
+	sample, ok, err := lastSample(filename)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Len(t, sample.Newest["topic"], 10000)
+}
+
+func TestHistoryQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kag-history")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	h, err := OpenHistory(dir, HistoryRetention{})
+	assert.Nil(t, err)
+
+	start := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	for i, lag := range []int64{4, 9, 2, 7} {
+		snapshot := makeHistorySnapshot(start.Add(time.Duration(i)*30*time.Second), int64(100+i), lag)
+		snapshot.Lag["group"]["other"] = map[int32]int64{0: 1}
+		assert.Nil(t, h.Append(snapshot))
+	}
+
+	testCases := map[string]struct {
+		Query HistoryQuery
+		Want  []int64
+	}{
+		"every sample": {
+			Query: HistoryQuery{Metric: MetricLag, Group: "group", Topic: "topic", Partition: -1},
+			Want:  []int64{4, 9, 2, 7},
+		},
+		"summed across topics": {
+			Query: HistoryQuery{Metric: MetricLag, Group: "group", Partition: -1},
+			Want:  []int64{5, 10, 3, 8},
+		},
+		"largest lag per step": {
+			Query: HistoryQuery{Metric: MetricLag, Group: "group", Topic: "topic", Partition: 0, Step: time.Minute},
+			Want:  []int64{9, 7},
+		},
+		"last offset per step": {
+			Query: HistoryQuery{Metric: MetricNewest, Topic: "topic", Partition: -1, Step: time.Minute},
+			Want:  []int64{101, 103},
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			q := tc.Query
+			q.From, q.To = start, start.Add(time.Hour)
+
+			points, err := h.Query(q)
+			assert.Nil(t, err)
+
+			var got []int64
+			for _, p := range points {
+				got = append(got, p.Value)
+			}
+			assert.Equal(t, tc.Want, got)
+		})
+	}
+
+	_, err = h.Query(HistoryQuery{Metric: MetricLag, Partition: -1})
+	assert.NotNil(t, err, "lag requires a group")
+}
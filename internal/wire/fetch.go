@@ -0,0 +1,378 @@
+package wire
+
+import (
+	"encoding/binary"
+)
+
+// FetchRequest reads records from the partitions led by a broker
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_Fetch
+type FetchRequest struct {
+	ReplicaID      int32
+	MaxWaitTime    int32
+	MinBytes       int32
+	MaxBytes       int32
+	IsolationLevel int8
+	Topics         []FetchTopic
+}
+
+type FetchTopic struct {
+	Topic      string
+	Partitions []FetchPartition
+}
+
+type FetchPartition struct {
+	Partition   int32
+	FetchOffset int64
+	MaxBytes    int32
+}
+
+// Encode writes the given version, 0 through 4, of the request.  MaxBytes
+// requires version 3 and IsolationLevel version 4.
+func (r FetchRequest) Encode(e *Encoder, version int16) {
+	e.Int32(r.ReplicaID)
+	e.Int32(r.MaxWaitTime)
+	e.Int32(r.MinBytes)
+	if version >= 3 {
+		e.Int32(r.MaxBytes)
+	}
+	if version >= 4 {
+		e.Int8(r.IsolationLevel)
+	}
+	e.ArrayLen(len(r.Topics))
+	for _, t := range r.Topics {
+		e.String(t.Topic)
+		e.ArrayLen(len(t.Partitions))
+		for _, p := range t.Partitions {
+			e.Int32(p.Partition)
+			e.Int64(p.FetchOffset)
+			e.Int32(p.MaxBytes)
+		}
+	}
+}
+
+// Decode reads the given version of the request
+func (r *FetchRequest) Decode(d *Decoder, version int16) {
+	r.ReplicaID = d.Int32()
+	r.MaxWaitTime = d.Int32()
+	r.MinBytes = d.Int32()
+	if version >= 3 {
+		r.MaxBytes = d.Int32()
+	}
+	if version >= 4 {
+		r.IsolationLevel = d.Int8()
+	}
+	r.Topics = make([]FetchTopic, d.ArrayLen())
+	for i := range r.Topics {
+		r.Topics[i].Topic = d.String()
+		r.Topics[i].Partitions = make([]FetchPartition, d.ArrayLen())
+		for j := range r.Topics[i].Partitions {
+			p := &r.Topics[i].Partitions[j]
+			p.Partition = d.Int32()
+			p.FetchOffset = d.Int64()
+			p.MaxBytes = d.Int32()
+		}
+	}
+}
+
+// FetchResponse holds the records of each partition.  Versions 0 and 1
+// return v0 message sets, which carry no timestamps; versions 2 and 3 return
+// v1 message sets; version 4 returns v2 record batches.
+type FetchResponse struct {
+	ThrottleTime int32
+	Topics       []FetchResponseTopic
+}
+
+type FetchResponseTopic struct {
+	Topic      string
+	Partitions []FetchResponsePartition
+}
+
+// FetchResponsePartition holds the records of a single partition.  Aborted
+// transactions are skipped when decoding.  LastStableOffset requires
+// version 4.
+type FetchResponsePartition struct {
+	Partition        int32
+	ErrorCode        int16
+	HighWatermark    int64
+	LastStableOffset int64
+	Records          []byte
+}
+
+// Encode writes the given version, 0 through 4, of the response
+func (r FetchResponse) Encode(e *Encoder, version int16) {
+	if version >= 1 {
+		e.Int32(r.ThrottleTime)
+	}
+	e.ArrayLen(len(r.Topics))
+	for _, t := range r.Topics {
+		e.String(t.Topic)
+		e.ArrayLen(len(t.Partitions))
+		for _, p := range t.Partitions {
+			e.Int32(p.Partition)
+			e.Int16(p.ErrorCode)
+			e.Int64(p.HighWatermark)
+			if version >= 4 {
+				e.Int64(p.LastStableOffset)
+				e.ArrayLen(-1) // aborted transactions
+			}
+			e.Bytes(p.Records)
+		}
+	}
+}
+
+// Decode reads the given version of the response
+func (r *FetchResponse) Decode(d *Decoder, version int16) {
+	if version >= 1 {
+		r.ThrottleTime = d.Int32()
+	}
+	r.Topics = make([]FetchResponseTopic, d.ArrayLen())
+	for i := range r.Topics {
+		r.Topics[i].Topic = d.String()
+		r.Topics[i].Partitions = make([]FetchResponsePartition, d.ArrayLen())
+		for j := range r.Topics[i].Partitions {
+			p := &r.Topics[i].Partitions[j]
+			p.Partition = d.Int32()
+			p.ErrorCode = d.Int16()
+			p.HighWatermark = d.Int64()
+			p.LastStableOffset = -1
+			if version >= 4 {
+				p.LastStableOffset = d.Int64()
+				for n := d.ArrayLen(); n > 0; n-- {
+					d.Int64() // producer id
+					d.Int64() // first offset
+				}
+			}
+			p.Records = d.Bytes()
+		}
+	}
+}
+
+// RecordTimestamp holds the timestamp, in ms since epoch, of the records from
+// FirstOffset through LastOffset inclusive.  Individual records yield a range
+// of one; compressed batches, whose records can't be read without
+// decompressing, yield a single range carrying the timestamp of the first
+// record in the batch.
+type RecordTimestamp struct {
+	FirstOffset int64
+	LastOffset  int64
+	Timestamp   int64
+}
+
+// attribute bits shared by record batches and legacy messages
+const (
+	compressionMask   = 0x07
+	logAppendTimeMask = 0x08
+	controlBatchMask  = 0x20
+)
+
+// recordBatchHeaderSize is the size of a v2 record batch up to and including
+// the record count
+const recordBatchHeaderSize = 61
+
+// RecordTimestamps extracts the offsets and timestamps of the records in a
+// record set.  Both v2 record batches and legacy v0/v1 message sets are
+// supported; v0 messages carry no timestamp and are reported with a
+// timestamp of -1.  Record sets are frequently truncated by the fetch byte
+// limit; the incomplete tail is decoded as far as possible and then ignored.
+func RecordTimestamps(data []byte) []RecordTimestamp {
+	var timestamps []RecordTimestamp
+
+	for len(data) >= 17 {
+		baseOffset := int64(binary.BigEndian.Uint64(data[0:]))
+		length := int(int32(binary.BigEndian.Uint32(data[8:])))
+		if length < 5 {
+			break
+		}
+
+		entry := data[12:]
+		complete := len(entry) >= length
+		if complete {
+			entry = entry[:length]
+		}
+
+		switch magic := entry[4]; magic {
+		case 0, 1:
+			if !complete {
+				return timestamps
+			}
+			timestamps = append(timestamps, legacyTimestamp(baseOffset, magic, entry))
+		default:
+			timestamps = append(timestamps, batchTimestamps(baseOffset, entry)...)
+		}
+
+		if !complete {
+			break
+		}
+		data = data[12+length:]
+	}
+
+	return timestamps
+}
+
+// legacyTimestamp reads the timestamp of a v0 or v1 message.  The offset of a
+// compressed v1 message is the offset of its last inner message and the
+// timestamp is the largest of the inner timestamps.
+func legacyTimestamp(offset int64, magic byte, entry []byte) RecordTimestamp {
+	v := RecordTimestamp{FirstOffset: offset, LastOffset: offset, Timestamp: -1}
+	if magic == 1 && len(entry) >= 14 {
+		v.Timestamp = int64(binary.BigEndian.Uint64(entry[6:]))
+	}
+	return v
+}
+
+// batchTimestamps reads the records of a v2 record batch; entry begins with
+// the partition leader epoch
+func batchTimestamps(baseOffset int64, entry []byte) []RecordTimestamp {
+	if len(entry)+12 < recordBatchHeaderSize {
+		return nil
+	}
+
+	d := NewDecoder(entry)
+	d.Int32() // partition leader epoch
+	d.Int8()  // magic
+	d.Int32() // crc
+	attributes := d.Int16()
+	lastOffsetDelta := d.Int32()
+	firstTimestamp := d.Int64()
+	maxTimestamp := d.Int64()
+	d.Int64() // producer id
+	d.Int16() // producer epoch
+	d.Int32() // base sequence
+	count := d.Int32()
+
+	lastOffset := baseOffset + int64(lastOffsetDelta)
+	switch {
+	case attributes&controlBatchMask != 0:
+		return nil
+	case attributes&logAppendTimeMask != 0:
+		return []RecordTimestamp{{FirstOffset: baseOffset, LastOffset: lastOffset, Timestamp: maxTimestamp}}
+	case attributes&compressionMask != 0:
+		return []RecordTimestamp{{FirstOffset: baseOffset, LastOffset: lastOffset, Timestamp: firstTimestamp}}
+	}
+
+	var timestamps []RecordTimestamp
+	for i := int32(0); i < count; i++ {
+		length := d.Varint()
+		if d.Err() != nil || length < 0 || int(length) > d.Remaining() {
+			break
+		}
+		record := NewDecoder(d.next(int(length)))
+		record.Int8() // attributes
+		timestampDelta := record.Varint()
+		offsetDelta := record.Varint()
+		if record.Err() != nil {
+			break
+		}
+
+		offset := baseOffset + offsetDelta
+		timestamps = append(timestamps, RecordTimestamp{
+			FirstOffset: offset,
+			LastOffset:  offset,
+			Timestamp:   firstTimestamp + timestampDelta,
+		})
+	}
+
+	if len(timestamps) == 0 {
+		// truncated before the first record; the header still tells us when
+		// the batch began
+		return []RecordTimestamp{{FirstOffset: baseOffset, LastOffset: lastOffset, Timestamp: firstTimestamp}}
+	}
+	return timestamps
+}
+
+// FindTimestamp returns the timestamp of the first record at or after offset
+func FindTimestamp(timestamps []RecordTimestamp, offset int64) (int64, bool) {
+	for _, v := range timestamps {
+		if v.LastOffset >= offset {
+			return v.Timestamp, v.Timestamp >= 0
+		}
+	}
+	return 0, false
+}
+
+// RecordBatch describes an uncompressed v2 record batch; used to construct
+// record sets for testing
+type RecordBatch struct {
+	BaseOffset int64
+
+	// Timestamps holds the timestamp, in ms since epoch, of each record
+	Timestamps []int64
+}
+
+// Encode writes the batch.  The crc is not computed.
+func (b RecordBatch) Encode(e *Encoder) {
+	var firstTimestamp, maxTimestamp int64
+	if len(b.Timestamps) > 0 {
+		firstTimestamp = b.Timestamps[0]
+	}
+	for _, ts := range b.Timestamps {
+		if ts > maxTimestamp {
+			maxTimestamp = ts
+		}
+	}
+
+	records := &Encoder{}
+	for i, ts := range b.Timestamps {
+		record := &Encoder{}
+		record.Int8(0) // attributes
+		record.Varint(ts - firstTimestamp)
+		record.Varint(int64(i))
+		record.Varint(-1) // key
+		record.Varint(-1) // value
+		record.Varint(0)  // headers
+
+		records.Varint(int64(len(record.Encoded())))
+		records.Raw(record.Encoded())
+	}
+
+	body := &Encoder{}
+	body.Int32(0) // partition leader epoch
+	body.Int8(2)  // magic
+	body.Int32(0) // crc
+	body.Int16(0) // attributes
+	body.Int32(int32(len(b.Timestamps) - 1))
+	body.Int64(firstTimestamp)
+	body.Int64(maxTimestamp)
+	body.Int64(-1) // producer id
+	body.Int16(-1) // producer epoch
+	body.Int32(-1) // base sequence
+	body.ArrayLen(len(b.Timestamps))
+	body.Raw(records.Encoded())
+
+	e.Int64(b.BaseOffset)
+	e.Int32(int32(len(body.Encoded())))
+	e.Raw(body.Encoded())
+}
+
+// MessageSet describes a legacy message set of uncompressed messages at
+// consecutive offsets; used to construct record sets for testing
+type MessageSet struct {
+	BaseOffset int64
+
+	// Magic holds the message format, 0 or 1.  Timestamps are only written
+	// by version 1.
+	Magic int8
+
+	// Timestamps holds the timestamp, in ms since epoch, of each message
+	Timestamps []int64
+}
+
+// Encode writes the message set.  The crc is not computed.
+func (m MessageSet) Encode(e *Encoder) {
+	for i, ts := range m.Timestamps {
+		message := &Encoder{}
+		message.Int32(0) // crc
+		message.Int8(m.Magic)
+		message.Int8(0) // attributes
+		if m.Magic >= 1 {
+			message.Int64(ts)
+		}
+		message.Int32(-1) // key
+		message.Int32(-1) // value
+
+		e.Int64(m.BaseOffset + int64(i))
+		e.Int32(int32(len(message.Encoded())))
+		e.Raw(message.Encoded())
+	}
+}
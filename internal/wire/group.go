@@ -0,0 +1,400 @@
+package wire
+
+// JoinGroupRequest asks the coordinator to add a member to a group.  The
+// response is withheld until every member has joined or the rebalance
+// timeout expires.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_JoinGroup
+type JoinGroupRequest struct {
+	GroupID          string
+	SessionTimeout   int32
+	RebalanceTimeout int32
+	MemberID         string
+	ProtocolType     string
+	Protocols        []GroupProtocol
+}
+
+type GroupProtocol struct {
+	Name     string
+	Metadata []byte
+}
+
+// Encode writes version 1 of the request
+func (r JoinGroupRequest) Encode(e *Encoder) {
+	e.String(r.GroupID)
+	e.Int32(r.SessionTimeout)
+	e.Int32(r.RebalanceTimeout)
+	e.String(r.MemberID)
+	e.String(r.ProtocolType)
+	e.ArrayLen(len(r.Protocols))
+	for _, p := range r.Protocols {
+		e.String(p.Name)
+		e.Bytes(p.Metadata)
+	}
+}
+
+// Decode reads version 1 of the request
+func (r *JoinGroupRequest) Decode(d *Decoder) {
+	r.GroupID = d.String()
+	r.SessionTimeout = d.Int32()
+	r.RebalanceTimeout = d.Int32()
+	r.MemberID = d.String()
+	r.ProtocolType = d.String()
+	r.Protocols = make([]GroupProtocol, d.ArrayLen())
+	for i := range r.Protocols {
+		r.Protocols[i].Name = d.String()
+		r.Protocols[i].Metadata = d.Bytes()
+	}
+}
+
+type JoinGroupResponse struct {
+	ErrorCode    int16
+	GenerationID int32
+	Protocol     string
+	LeaderID     string
+	MemberID     string
+
+	// Members is only populated in the response to the leader
+	Members []JoinGroupMember
+}
+
+type JoinGroupMember struct {
+	MemberID string
+	Metadata []byte
+}
+
+// Encode writes version 1 of the response
+func (r JoinGroupResponse) Encode(e *Encoder) {
+	e.Int16(r.ErrorCode)
+	e.Int32(r.GenerationID)
+	e.String(r.Protocol)
+	e.String(r.LeaderID)
+	e.String(r.MemberID)
+	e.ArrayLen(len(r.Members))
+	for _, m := range r.Members {
+		e.String(m.MemberID)
+		e.Bytes(m.Metadata)
+	}
+}
+
+// Decode reads version 1 of the response
+func (r *JoinGroupResponse) Decode(d *Decoder) {
+	r.ErrorCode = d.Int16()
+	r.GenerationID = d.Int32()
+	r.Protocol = d.String()
+	r.LeaderID = d.String()
+	r.MemberID = d.String()
+	r.Members = make([]JoinGroupMember, d.ArrayLen())
+	for i := range r.Members {
+		r.Members[i].MemberID = d.String()
+		r.Members[i].Metadata = d.Bytes()
+	}
+}
+
+// SyncGroupRequest distributes the leader's assignments to the members of a
+// group.  Followers send no assignments.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_SyncGroup
+type SyncGroupRequest struct {
+	GroupID      string
+	GenerationID int32
+	MemberID     string
+	Assignments  []GroupAssignment
+}
+
+type GroupAssignment struct {
+	MemberID   string
+	Assignment []byte
+}
+
+// Encode writes version 0 of the request
+func (r SyncGroupRequest) Encode(e *Encoder) {
+	e.String(r.GroupID)
+	e.Int32(r.GenerationID)
+	e.String(r.MemberID)
+	e.ArrayLen(len(r.Assignments))
+	for _, a := range r.Assignments {
+		e.String(a.MemberID)
+		e.Bytes(a.Assignment)
+	}
+}
+
+// Decode reads version 0 of the request
+func (r *SyncGroupRequest) Decode(d *Decoder) {
+	r.GroupID = d.String()
+	r.GenerationID = d.Int32()
+	r.MemberID = d.String()
+	r.Assignments = make([]GroupAssignment, d.ArrayLen())
+	for i := range r.Assignments {
+		r.Assignments[i].MemberID = d.String()
+		r.Assignments[i].Assignment = d.Bytes()
+	}
+}
+
+type SyncGroupResponse struct {
+	ErrorCode  int16
+	Assignment []byte
+}
+
+// Encode writes version 0 of the response
+func (r SyncGroupResponse) Encode(e *Encoder) {
+	e.Int16(r.ErrorCode)
+	e.Bytes(r.Assignment)
+}
+
+// Decode reads version 0 of the response
+func (r *SyncGroupResponse) Decode(d *Decoder) {
+	r.ErrorCode = d.Int16()
+	r.Assignment = d.Bytes()
+}
+
+// HeartbeatRequest keeps a member's session alive.  The coordinator responds
+// with a rebalance in progress error when the member must rejoin.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_Heartbeat
+type HeartbeatRequest struct {
+	GroupID      string
+	GenerationID int32
+	MemberID     string
+}
+
+// Encode writes version 0 of the request
+func (r HeartbeatRequest) Encode(e *Encoder) {
+	e.String(r.GroupID)
+	e.Int32(r.GenerationID)
+	e.String(r.MemberID)
+}
+
+// Decode reads version 0 of the request
+func (r *HeartbeatRequest) Decode(d *Decoder) {
+	r.GroupID = d.String()
+	r.GenerationID = d.Int32()
+	r.MemberID = d.String()
+}
+
+// LeaveGroupRequest removes a member from a group, triggering an immediate
+// rebalance
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_LeaveGroup
+type LeaveGroupRequest struct {
+	GroupID  string
+	MemberID string
+}
+
+// Encode writes version 0 of the request
+func (r LeaveGroupRequest) Encode(e *Encoder) {
+	e.String(r.GroupID)
+	e.String(r.MemberID)
+}
+
+// Decode reads version 0 of the request
+func (r *LeaveGroupRequest) Decode(d *Decoder) {
+	r.GroupID = d.String()
+	r.MemberID = d.String()
+}
+
+// ErrorResponse is the response to requests, e.g. Heartbeat and LeaveGroup,
+// whose body is a single error code
+type ErrorResponse struct {
+	ErrorCode int16
+}
+
+// Encode writes the response
+func (r ErrorResponse) Encode(e *Encoder) {
+	e.Int16(r.ErrorCode)
+}
+
+// Decode reads the response
+func (r *ErrorResponse) Decode(d *Decoder) {
+	r.ErrorCode = d.Int16()
+}
+
+// FindCoordinatorRequest locates the broker that coordinates a group
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_FindCoordinator
+type FindCoordinatorRequest struct {
+	GroupID string
+}
+
+// Encode writes the given version, 0 or 1, of the request
+func (r FindCoordinatorRequest) Encode(e *Encoder, version int16) {
+	e.String(r.GroupID)
+	if version >= 1 {
+		e.Int8(0) // group coordinator
+	}
+}
+
+// Decode reads the given version of the request
+func (r *FindCoordinatorRequest) Decode(d *Decoder, version int16) {
+	r.GroupID = d.String()
+	if version >= 1 {
+		d.Int8()
+	}
+}
+
+type FindCoordinatorResponse struct {
+	ErrorCode int16
+
+	// ErrorMessage is only returned by version 1 on
+	ErrorMessage string
+
+	NodeID int32
+	Host   string
+	Port   int32
+}
+
+// Encode writes the given version, 0 or 1, of the response
+func (r FindCoordinatorResponse) Encode(e *Encoder, version int16) {
+	if version >= 1 {
+		e.Int32(0) // throttle time
+	}
+	e.Int16(r.ErrorCode)
+	if version >= 1 {
+		e.NullableString(r.ErrorMessage)
+	}
+	e.Int32(r.NodeID)
+	e.String(r.Host)
+	e.Int32(r.Port)
+}
+
+// Decode reads the given version of the response
+func (r *FindCoordinatorResponse) Decode(d *Decoder, version int16) {
+	if version >= 1 {
+		d.Int32() // throttle time
+	}
+	r.ErrorCode = d.Int16()
+	if version >= 1 {
+		r.ErrorMessage = d.String()
+	}
+	r.NodeID = d.Int32()
+	r.Host = d.String()
+	r.Port = d.Int32()
+}
+
+// DescribeGroupsRequest retrieves the state and members of groups from their
+// coordinator
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_DescribeGroups
+type DescribeGroupsRequest struct {
+	GroupIDs []string
+}
+
+// Encode writes the given version, 0 or 1, of the request; both are
+// identical
+func (r DescribeGroupsRequest) Encode(e *Encoder, version int16) {
+	e.StringArray(r.GroupIDs)
+}
+
+// Decode reads the given version of the request
+func (r *DescribeGroupsRequest) Decode(d *Decoder, version int16) {
+	r.GroupIDs = d.StringArray()
+}
+
+type DescribeGroupsResponse struct {
+	Groups []DescribedGroup
+}
+
+type DescribedGroup struct {
+	ErrorCode    int16
+	GroupID      string
+	State        string
+	ProtocolType string
+	Protocol     string
+	Members      []DescribedMember
+}
+
+type DescribedMember struct {
+	MemberID   string
+	ClientID   string
+	ClientHost string
+	Metadata   []byte
+	Assignment []byte
+}
+
+// Encode writes the given version, 0 or 1, of the response
+func (r DescribeGroupsResponse) Encode(e *Encoder, version int16) {
+	if version >= 1 {
+		e.Int32(0) // throttle time
+	}
+	e.ArrayLen(len(r.Groups))
+	for _, g := range r.Groups {
+		e.Int16(g.ErrorCode)
+		e.String(g.GroupID)
+		e.String(g.State)
+		e.String(g.ProtocolType)
+		e.String(g.Protocol)
+		e.ArrayLen(len(g.Members))
+		for _, m := range g.Members {
+			e.String(m.MemberID)
+			e.String(m.ClientID)
+			e.String(m.ClientHost)
+			e.Bytes(m.Metadata)
+			e.Bytes(m.Assignment)
+		}
+	}
+}
+
+// Decode reads the given version of the response
+func (r *DescribeGroupsResponse) Decode(d *Decoder, version int16) {
+	if version >= 1 {
+		d.Int32() // throttle time
+	}
+	r.Groups = make([]DescribedGroup, d.ArrayLen())
+	for i := range r.Groups {
+		g := &r.Groups[i]
+		g.ErrorCode = d.Int16()
+		g.GroupID = d.String()
+		g.State = d.String()
+		g.ProtocolType = d.String()
+		g.Protocol = d.String()
+		g.Members = make([]DescribedMember, d.ArrayLen())
+		for j := range g.Members {
+			m := &g.Members[j]
+			m.MemberID = d.String()
+			m.ClientID = d.String()
+			m.ClientHost = d.String()
+			m.Metadata = d.Bytes()
+			m.Assignment = d.Bytes()
+		}
+	}
+}
+
+// ListGroupsResponse lists the groups coordinated by the broker.  The request
+// has no body.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_ListGroups
+type ListGroupsResponse struct {
+	ErrorCode int16
+	Groups    []ListedGroup
+}
+
+type ListedGroup struct {
+	GroupID      string
+	ProtocolType string
+}
+
+// Encode writes the given version, 0 or 1, of the response
+func (r ListGroupsResponse) Encode(e *Encoder, version int16) {
+	if version >= 1 {
+		e.Int32(0) // throttle time
+	}
+	e.Int16(r.ErrorCode)
+	e.ArrayLen(len(r.Groups))
+	for _, g := range r.Groups {
+		e.String(g.GroupID)
+		e.String(g.ProtocolType)
+	}
+}
+
+// Decode reads the given version of the response
+func (r *ListGroupsResponse) Decode(d *Decoder, version int16) {
+	if version >= 1 {
+		d.Int32() // throttle time
+	}
+	r.ErrorCode = d.Int16()
+	r.Groups = make([]ListedGroup, d.ArrayLen())
+	for i := range r.Groups {
+		r.Groups[i].GroupID = d.String()
+		r.Groups[i].ProtocolType = d.String()
+	}
+}
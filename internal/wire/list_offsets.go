@@ -0,0 +1,139 @@
+package wire
+
+// ListOffsetsRequest retrieves the offset of each partition as of a
+// timestamp in ms or the sentinels -1 for newest and -2 for oldest.  Version
+// 0 predates record timestamps; for other timestamps, it returns the offset
+// of the first segment older than the timestamp.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_ListOffsets
+type ListOffsetsRequest struct {
+	ReplicaID int32
+	Topics    []ListOffsetsTopic
+}
+
+type ListOffsetsTopic struct {
+	Topic      string
+	Partitions []ListOffsetsPartition
+}
+
+type ListOffsetsPartition struct {
+	Partition int32
+	Timestamp int64
+}
+
+// Encode writes the given version, 0 through 2, of the request
+func (r ListOffsetsRequest) Encode(e *Encoder, version int16) {
+	e.Int32(r.ReplicaID)
+	if version >= 2 {
+		e.Int8(0) // read uncommitted
+	}
+	e.ArrayLen(len(r.Topics))
+	for _, t := range r.Topics {
+		e.String(t.Topic)
+		e.ArrayLen(len(t.Partitions))
+		for _, p := range t.Partitions {
+			e.Int32(p.Partition)
+			e.Int64(p.Timestamp)
+			if version == 0 {
+				e.Int32(1) // max offsets
+			}
+		}
+	}
+}
+
+// Decode reads the given version of the request
+func (r *ListOffsetsRequest) Decode(d *Decoder, version int16) {
+	r.ReplicaID = d.Int32()
+	if version >= 2 {
+		d.Int8()
+	}
+	r.Topics = make([]ListOffsetsTopic, d.ArrayLen())
+	for i := range r.Topics {
+		t := &r.Topics[i]
+		t.Topic = d.String()
+		t.Partitions = make([]ListOffsetsPartition, d.ArrayLen())
+		for j := range t.Partitions {
+			t.Partitions[j].Partition = d.Int32()
+			t.Partitions[j].Timestamp = d.Int64()
+			if version == 0 {
+				d.Int32()
+			}
+		}
+	}
+}
+
+type ListOffsetsResponse struct {
+	Topics []ListOffsetsTopicResponse
+}
+
+type ListOffsetsTopicResponse struct {
+	Topic      string
+	Partitions []ListOffsetsPartitionResponse
+}
+
+// ListOffsetsPartitionResponse holds the offset of a partition.  Offset is
+// -1 if the partition holds no matching record; Timestamp is -1 when unknown,
+// as it always is for version 0.
+type ListOffsetsPartitionResponse struct {
+	Partition int32
+	ErrorCode int16
+	Timestamp int64
+	Offset    int64
+}
+
+// Encode writes the given version, 0 through 2, of the response
+func (r ListOffsetsResponse) Encode(e *Encoder, version int16) {
+	if version >= 2 {
+		e.Int32(0) // throttle time
+	}
+	e.ArrayLen(len(r.Topics))
+	for _, t := range r.Topics {
+		e.String(t.Topic)
+		e.ArrayLen(len(t.Partitions))
+		for _, p := range t.Partitions {
+			e.Int32(p.Partition)
+			e.Int16(p.ErrorCode)
+			if version == 0 {
+				if p.Offset < 0 {
+					e.ArrayLen(0)
+				} else {
+					e.ArrayLen(1)
+					e.Int64(p.Offset)
+				}
+				continue
+			}
+			e.Int64(p.Timestamp)
+			e.Int64(p.Offset)
+		}
+	}
+}
+
+// Decode reads the given version of the response
+func (r *ListOffsetsResponse) Decode(d *Decoder, version int16) {
+	if version >= 2 {
+		d.Int32() // throttle time
+	}
+	r.Topics = make([]ListOffsetsTopicResponse, d.ArrayLen())
+	for i := range r.Topics {
+		t := &r.Topics[i]
+		t.Topic = d.String()
+		t.Partitions = make([]ListOffsetsPartitionResponse, d.ArrayLen())
+		for j := range t.Partitions {
+			p := &t.Partitions[j]
+			p.Partition = d.Int32()
+			p.ErrorCode = d.Int16()
+			if version == 0 {
+				p.Timestamp, p.Offset = -1, -1
+				offsets := d.ArrayLen()
+				for k := 0; k < offsets; k++ {
+					if offset := d.Int64(); k == 0 {
+						p.Offset = offset
+					}
+				}
+				continue
+			}
+			p.Timestamp = d.Int64()
+			p.Offset = d.Int64()
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package wire
+
+// MetadataRequest retrieves the brokers of the cluster and the partitions of
+// the requested topics.  A nil Topics requests every topic.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_Metadata
+type MetadataRequest struct {
+	Topics []string
+}
+
+// Encode writes the given version, 0 through 5, of the request
+func (r MetadataRequest) Encode(e *Encoder, version int16) {
+	switch {
+	case r.Topics == nil && version >= 1:
+		e.Int32(-1)
+	default:
+		// version 0 requests every topic with an empty array
+		e.StringArray(r.Topics)
+	}
+	if version >= 4 {
+		e.Bool(false) // allow auto topic creation
+	}
+}
+
+// Decode reads the given version of the request
+func (r *MetadataRequest) Decode(d *Decoder, version int16) {
+	r.Topics = nil
+	if n := d.Int32(); n > 0 || (n == 0 && version >= 1) {
+		r.Topics = make([]string, 0, n)
+		for i := int32(0); i < n && d.Err() == nil; i++ {
+			r.Topics = append(r.Topics, d.String())
+		}
+	}
+	if version >= 4 {
+		d.Bool()
+	}
+}
+
+type MetadataResponse struct {
+	Brokers []MetadataBroker
+
+	// ClusterID is only returned by version 2 on
+	ClusterID string
+
+	// ControllerID is -1 before version 1
+	ControllerID int32
+
+	Topics []MetadataTopic
+}
+
+type MetadataBroker struct {
+	NodeID int32
+	Host   string
+	Port   int32
+
+	// Rack is only returned by version 1 on
+	Rack string
+}
+
+type MetadataTopic struct {
+	ErrorCode int16
+	Topic     string
+
+	// Internal is only returned by version 1 on
+	Internal bool
+
+	Partitions []MetadataPartition
+}
+
+type MetadataPartition struct {
+	ErrorCode int16
+	Partition int32
+	Leader    int32
+	Replicas  []int32
+	Isr       []int32
+
+	// OfflineReplicas is only returned by version 5 on
+	OfflineReplicas []int32
+}
+
+// Encode writes the given version, 0 through 5, of the response
+func (r MetadataResponse) Encode(e *Encoder, version int16) {
+	if version >= 3 {
+		e.Int32(0) // throttle time
+	}
+	e.ArrayLen(len(r.Brokers))
+	for _, b := range r.Brokers {
+		e.Int32(b.NodeID)
+		e.String(b.Host)
+		e.Int32(b.Port)
+		if version >= 1 {
+			e.NullableString(b.Rack)
+		}
+	}
+	if version >= 2 {
+		e.NullableString(r.ClusterID)
+	}
+	if version >= 1 {
+		e.Int32(r.ControllerID)
+	}
+	e.ArrayLen(len(r.Topics))
+	for _, t := range r.Topics {
+		e.Int16(t.ErrorCode)
+		e.String(t.Topic)
+		if version >= 1 {
+			e.Bool(t.Internal)
+		}
+		e.ArrayLen(len(t.Partitions))
+		for _, p := range t.Partitions {
+			e.Int16(p.ErrorCode)
+			e.Int32(p.Partition)
+			e.Int32(p.Leader)
+			e.Int32Array(p.Replicas)
+			e.Int32Array(p.Isr)
+			if version >= 5 {
+				e.Int32Array(p.OfflineReplicas)
+			}
+		}
+	}
+}
+
+// Decode reads the given version of the response
+func (r *MetadataResponse) Decode(d *Decoder, version int16) {
+	if version >= 3 {
+		d.Int32() // throttle time
+	}
+	r.Brokers = make([]MetadataBroker, d.ArrayLen())
+	for i := range r.Brokers {
+		b := &r.Brokers[i]
+		b.NodeID = d.Int32()
+		b.Host = d.String()
+		b.Port = d.Int32()
+		if version >= 1 {
+			b.Rack = d.String()
+		}
+	}
+	if version >= 2 {
+		r.ClusterID = d.String()
+	}
+	r.ControllerID = -1
+	if version >= 1 {
+		r.ControllerID = d.Int32()
+	}
+	r.Topics = make([]MetadataTopic, d.ArrayLen())
+	for i := range r.Topics {
+		t := &r.Topics[i]
+		t.ErrorCode = d.Int16()
+		t.Topic = d.String()
+		if version >= 1 {
+			t.Internal = d.Bool()
+		}
+		t.Partitions = make([]MetadataPartition, d.ArrayLen())
+		for j := range t.Partitions {
+			p := &t.Partitions[j]
+			p.ErrorCode = d.Int16()
+			p.Partition = d.Int32()
+			p.Leader = d.Int32()
+			p.Replicas = d.Int32Array()
+			p.Isr = d.Int32Array()
+			if version >= 5 {
+				p.OfflineReplicas = d.Int32Array()
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package wire
+
+// OffsetCommitRequest commits offsets on behalf of a consumer group.  Offsets
+// may be committed for a group with no active members by using a
+// GenerationID of -1 and an empty MemberID.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_OffsetCommit
+type OffsetCommitRequest struct {
+	GroupID       string
+	GenerationID  int32
+	MemberID      string
+	RetentionTime int64
+	Topics        []OffsetCommitTopic
+}
+
+type OffsetCommitTopic struct {
+	Topic      string
+	Partitions []OffsetCommitPartition
+}
+
+type OffsetCommitPartition struct {
+	Partition int32
+	Offset    int64
+	Metadata  string
+}
+
+// Encode writes version 2 of the request
+func (r OffsetCommitRequest) Encode(e *Encoder) {
+	e.String(r.GroupID)
+	e.Int32(r.GenerationID)
+	e.String(r.MemberID)
+	e.Int64(r.RetentionTime)
+	e.ArrayLen(len(r.Topics))
+	for _, t := range r.Topics {
+		e.String(t.Topic)
+		e.ArrayLen(len(t.Partitions))
+		for _, p := range t.Partitions {
+			e.Int32(p.Partition)
+			e.Int64(p.Offset)
+			e.NullableString(p.Metadata)
+		}
+	}
+}
+
+// Decode reads version 2 of the request
+func (r *OffsetCommitRequest) Decode(d *Decoder) {
+	r.GroupID = d.String()
+	r.GenerationID = d.Int32()
+	r.MemberID = d.String()
+	r.RetentionTime = d.Int64()
+	r.Topics = make([]OffsetCommitTopic, d.ArrayLen())
+	for i := range r.Topics {
+		r.Topics[i].Topic = d.String()
+		r.Topics[i].Partitions = make([]OffsetCommitPartition, d.ArrayLen())
+		for j := range r.Topics[i].Partitions {
+			p := &r.Topics[i].Partitions[j]
+			p.Partition = d.Int32()
+			p.Offset = d.Int64()
+			p.Metadata = d.String()
+		}
+	}
+}
+
+type OffsetCommitResponse struct {
+	Topics []OffsetCommitResponseTopic
+}
+
+type OffsetCommitResponseTopic struct {
+	Topic      string
+	Partitions []PartitionError
+}
+
+// PartitionError holds the per partition error code common to many responses
+type PartitionError struct {
+	Partition int32
+	ErrorCode int16
+}
+
+// Encode writes version 2 of the response
+func (r OffsetCommitResponse) Encode(e *Encoder) {
+	e.ArrayLen(len(r.Topics))
+	for _, t := range r.Topics {
+		e.String(t.Topic)
+		e.ArrayLen(len(t.Partitions))
+		for _, p := range t.Partitions {
+			e.Int32(p.Partition)
+			e.Int16(p.ErrorCode)
+		}
+	}
+}
+
+// Decode reads version 2 of the response
+func (r *OffsetCommitResponse) Decode(d *Decoder) {
+	r.Topics = make([]OffsetCommitResponseTopic, d.ArrayLen())
+	for i := range r.Topics {
+		r.Topics[i].Topic = d.String()
+		r.Topics[i].Partitions = make([]PartitionError, d.ArrayLen())
+		for j := range r.Topics[i].Partitions {
+			r.Topics[i].Partitions[j].Partition = d.Int32()
+			r.Topics[i].Partitions[j].ErrorCode = d.Int16()
+		}
+	}
+}
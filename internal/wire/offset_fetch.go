@@ -0,0 +1,112 @@
+package wire
+
+// OffsetFetchRequest retrieves the offsets committed by a consumer group.  A
+// nil Topics requests every committed offset, which requires version 2.
+// Version 0 reads offsets committed to zookeeper and is not supported.
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_OffsetFetch
+type OffsetFetchRequest struct {
+	GroupID string
+	Topics  []OffsetFetchTopic
+}
+
+type OffsetFetchTopic struct {
+	Topic      string
+	Partitions []int32
+}
+
+// Encode writes the given version, 1 through 3, of the request
+func (r OffsetFetchRequest) Encode(e *Encoder, version int16) {
+	e.String(r.GroupID)
+	if r.Topics == nil && version >= 2 {
+		e.Int32(-1)
+		return
+	}
+	e.ArrayLen(len(r.Topics))
+	for _, t := range r.Topics {
+		e.String(t.Topic)
+		e.Int32Array(t.Partitions)
+	}
+}
+
+// Decode reads the given version of the request
+func (r *OffsetFetchRequest) Decode(d *Decoder, version int16) {
+	r.GroupID = d.String()
+	r.Topics = nil
+	n := d.Int32()
+	if n < 0 {
+		return
+	}
+	r.Topics = []OffsetFetchTopic{}
+	for i := int32(0); i < n && d.Err() == nil; i++ {
+		r.Topics = append(r.Topics, OffsetFetchTopic{
+			Topic:      d.String(),
+			Partitions: d.Int32Array(),
+		})
+	}
+}
+
+type OffsetFetchResponse struct {
+	Topics []OffsetFetchTopicResponse
+
+	// ErrorCode is only returned by version 2 on
+	ErrorCode int16
+}
+
+type OffsetFetchTopicResponse struct {
+	Topic      string
+	Partitions []OffsetFetchPartition
+}
+
+// OffsetFetchPartition holds the committed offset of a partition or -1 if
+// the group has not committed one
+type OffsetFetchPartition struct {
+	Partition int32
+	Offset    int64
+	Metadata  string
+	ErrorCode int16
+}
+
+// Encode writes the given version, 1 through 3, of the response
+func (r OffsetFetchResponse) Encode(e *Encoder, version int16) {
+	if version >= 3 {
+		e.Int32(0) // throttle time
+	}
+	e.ArrayLen(len(r.Topics))
+	for _, t := range r.Topics {
+		e.String(t.Topic)
+		e.ArrayLen(len(t.Partitions))
+		for _, p := range t.Partitions {
+			e.Int32(p.Partition)
+			e.Int64(p.Offset)
+			e.NullableString(p.Metadata)
+			e.Int16(p.ErrorCode)
+		}
+	}
+	if version >= 2 {
+		e.Int16(r.ErrorCode)
+	}
+}
+
+// Decode reads the given version of the response
+func (r *OffsetFetchResponse) Decode(d *Decoder, version int16) {
+	if version >= 3 {
+		d.Int32() // throttle time
+	}
+	r.Topics = make([]OffsetFetchTopicResponse, d.ArrayLen())
+	for i := range r.Topics {
+		t := &r.Topics[i]
+		t.Topic = d.String()
+		t.Partitions = make([]OffsetFetchPartition, d.ArrayLen())
+		for j := range t.Partitions {
+			p := &t.Partitions[j]
+			p.Partition = d.Int32()
+			p.Offset = d.Int64()
+			p.Metadata = d.String()
+			p.ErrorCode = d.Int16()
+		}
+	}
+	if version >= 2 {
+		r.ErrorCode = d.Int16()
+	}
+}
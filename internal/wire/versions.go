@@ -0,0 +1,151 @@
+package wire
+
+import (
+	"fmt"
+)
+
+// ApiVersion holds the range of versions of a request supported by a broker
+type ApiVersion struct {
+	ApiKey     int16
+	MinVersion int16
+	MaxVersion int16
+}
+
+// Supported holds the versions of each request implemented by this package
+var Supported = map[int16]ApiVersion{
+	FetchKey:           {ApiKey: FetchKey, MinVersion: 0, MaxVersion: 4},
+	ListOffsetsKey:     {ApiKey: ListOffsetsKey, MinVersion: 0, MaxVersion: 2},
+	MetadataKey:        {ApiKey: MetadataKey, MinVersion: 0, MaxVersion: 5},
+	OffsetCommitKey:    {ApiKey: OffsetCommitKey, MinVersion: 2, MaxVersion: 2},
+	OffsetFetchKey:     {ApiKey: OffsetFetchKey, MinVersion: 1, MaxVersion: 3},
+	FindCoordinatorKey: {ApiKey: FindCoordinatorKey, MinVersion: 0, MaxVersion: 1},
+	JoinGroupKey:       {ApiKey: JoinGroupKey, MinVersion: 1, MaxVersion: 1},
+	HeartbeatKey:       {ApiKey: HeartbeatKey, MinVersion: 0, MaxVersion: 0},
+	LeaveGroupKey:      {ApiKey: LeaveGroupKey, MinVersion: 0, MaxVersion: 0},
+	SyncGroupKey:       {ApiKey: SyncGroupKey, MinVersion: 0, MaxVersion: 0},
+	DescribeGroupsKey:  {ApiKey: DescribeGroupsKey, MinVersion: 0, MaxVersion: 1},
+	ListGroupsKey:      {ApiKey: ListGroupsKey, MinVersion: 0, MaxVersion: 1},
+	SaslHandshakeKey:   {ApiKey: SaslHandshakeKey, MinVersion: 1, MaxVersion: 1},
+	ApiVersionsKey:     {ApiKey: ApiVersionsKey, MinVersion: 0, MaxVersion: 0},
+}
+
+// Legacy holds the versions supported by brokers that predate the
+// ApiVersions request, i.e. kafka 0.9
+var Legacy = []ApiVersion{
+	{ApiKey: ProduceKey, MinVersion: 0, MaxVersion: 1},
+	{ApiKey: FetchKey, MinVersion: 0, MaxVersion: 1},
+	{ApiKey: ListOffsetsKey, MinVersion: 0, MaxVersion: 0},
+	{ApiKey: MetadataKey, MinVersion: 0, MaxVersion: 0},
+	{ApiKey: OffsetCommitKey, MinVersion: 0, MaxVersion: 2},
+	{ApiKey: OffsetFetchKey, MinVersion: 0, MaxVersion: 1},
+	{ApiKey: FindCoordinatorKey, MinVersion: 0, MaxVersion: 0},
+	{ApiKey: JoinGroupKey, MinVersion: 0, MaxVersion: 0},
+	{ApiKey: HeartbeatKey, MinVersion: 0, MaxVersion: 0},
+	{ApiKey: LeaveGroupKey, MinVersion: 0, MaxVersion: 0},
+	{ApiKey: SyncGroupKey, MinVersion: 0, MaxVersion: 0},
+	{ApiKey: DescribeGroupsKey, MinVersion: 0, MaxVersion: 0},
+	{ApiKey: ListGroupsKey, MinVersion: 0, MaxVersion: 0},
+}
+
+// apiNames holds the names of the requests used in error messages
+var apiNames = map[int16]string{
+	ProduceKey:          "Produce",
+	FetchKey:            "Fetch",
+	ListOffsetsKey:      "ListOffsets",
+	MetadataKey:         "Metadata",
+	OffsetCommitKey:     "OffsetCommit",
+	OffsetFetchKey:      "OffsetFetch",
+	FindCoordinatorKey:  "FindCoordinator",
+	JoinGroupKey:        "JoinGroup",
+	HeartbeatKey:        "Heartbeat",
+	LeaveGroupKey:       "LeaveGroup",
+	SyncGroupKey:        "SyncGroup",
+	DescribeGroupsKey:   "DescribeGroups",
+	ListGroupsKey:       "ListGroups",
+	SaslHandshakeKey:    "SaslHandshake",
+	ApiVersionsKey:      "ApiVersions",
+	SaslAuthenticateKey: "SaslAuthenticate",
+}
+
+// ApiName returns the name of the request with the given api key
+func ApiName(apiKey int16) string {
+	if name, ok := apiNames[apiKey]; ok {
+		return name
+	}
+	return fmt.Sprintf("api %v", apiKey)
+}
+
+// UnsupportedVersionError is returned when a broker supports none of the
+// versions of a request implemented by this package
+type UnsupportedVersionError struct {
+	ApiKey int16
+
+	// Broker holds the versions supported by the broker; zero if the broker
+	// does not support the request at all
+	Broker ApiVersion
+}
+
+func (e UnsupportedVersionError) Error() string {
+	supported := Supported[e.ApiKey]
+	if e.Broker.ApiKey != e.ApiKey {
+		return fmt.Sprintf("broker does not support %v", ApiName(e.ApiKey))
+	}
+	return fmt.Sprintf("broker supports %v v%v-v%v; kag requires v%v-v%v",
+		ApiName(e.ApiKey), e.Broker.MinVersion, e.Broker.MaxVersion, supported.MinVersion, supported.MaxVersion)
+}
+
+// ApiVersionsResponse lists the versions of each request the broker supports
+//
+// See http://kafka.apache.org/protocol.html#The_Messages_ApiVersions
+type ApiVersionsResponse struct {
+	ErrorCode   int16
+	ApiVersions []ApiVersion
+}
+
+// Encode writes version 0 of the response
+func (r ApiVersionsResponse) Encode(e *Encoder) {
+	e.Int16(r.ErrorCode)
+	e.ArrayLen(len(r.ApiVersions))
+	for _, v := range r.ApiVersions {
+		e.Int16(v.ApiKey)
+		e.Int16(v.MinVersion)
+		e.Int16(v.MaxVersion)
+	}
+}
+
+// Decode reads version 0 of the response
+func (r *ApiVersionsResponse) Decode(d *Decoder) {
+	r.ErrorCode = d.Int16()
+	r.ApiVersions = make([]ApiVersion, d.ArrayLen())
+	for i := range r.ApiVersions {
+		r.ApiVersions[i].ApiKey = d.Int16()
+		r.ApiVersions[i].MinVersion = d.Int16()
+		r.ApiVersions[i].MaxVersion = d.Int16()
+	}
+}
+
+// pick returns the highest version of the request supported both by this
+// package and by a broker supporting versions
+func pick(apiKey int16, versions map[int16]ApiVersion) (int16, error) {
+	supported, ok := Supported[apiKey]
+	if !ok {
+		return 0, UnsupportedVersionError{ApiKey: apiKey}
+	}
+	if versions == nil {
+		return supported.MaxVersion, nil
+	}
+
+	broker, ok := versions[apiKey]
+	if !ok {
+		return 0, UnsupportedVersionError{ApiKey: apiKey}
+	}
+
+	version := supported.MaxVersion
+	if broker.MaxVersion < version {
+		version = broker.MaxVersion
+	}
+	if version < supported.MinVersion || version < broker.MinVersion {
+		return 0, UnsupportedVersionError{ApiKey: apiKey, Broker: broker}
+	}
+	return version, nil
+}
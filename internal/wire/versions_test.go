@@ -0,0 +1,176 @@
+package wire
+
+import (
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestPick(t *testing.T) {
+	testCases := map[string]struct {
+		Versions map[int16]ApiVersion
+		Want     int16
+		Err      string
+	}{
+		"not negotiated": {
+			Want: 3,
+		},
+		"newer broker": {
+			Versions: map[int16]ApiVersion{OffsetFetchKey: {ApiKey: OffsetFetchKey, MinVersion: 0, MaxVersion: 5}},
+			Want:     3,
+		},
+		"older broker": {
+			Versions: map[int16]ApiVersion{OffsetFetchKey: {ApiKey: OffsetFetchKey, MinVersion: 0, MaxVersion: 2}},
+			Want:     2,
+		},
+		"too old": {
+			Versions: map[int16]ApiVersion{OffsetFetchKey: {ApiKey: OffsetFetchKey, MinVersion: 0, MaxVersion: 0}},
+			Err:      "broker supports OffsetFetch v0-v0; kag requires v1-v3",
+		},
+		"unsupported": {
+			Versions: map[int16]ApiVersion{},
+			Err:      "broker does not support OffsetFetch",
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			version, err := pick(OffsetFetchKey, tc.Versions)
+			if tc.Err != "" {
+				assert.EqualError(t, err, tc.Err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.Want, version)
+		})
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	for version := int16(0); version <= Supported[MetadataKey].MaxVersion; version++ {
+		resp := MetadataResponse{
+			Brokers:      []MetadataBroker{{NodeID: 1, Host: "localhost", Port: 9092, Rack: "a"}},
+			ClusterID:    "cluster",
+			ControllerID: 1,
+			Topics: []MetadataTopic{
+				{
+					Topic:    "__consumer_offsets",
+					Internal: true,
+					Partitions: []MetadataPartition{
+						{Partition: 0, Leader: 1, Replicas: []int32{1, 2}, Isr: []int32{1}, OfflineReplicas: []int32{2}},
+					},
+				},
+			},
+		}
+
+		e := &Encoder{}
+		resp.Encode(e, version)
+
+		var got MetadataResponse
+		d := NewDecoder(e.Encoded())
+		got.Decode(d, version)
+		assert.Nil(t, d.Err())
+		assert.Equal(t, 0, d.Remaining())
+
+		// fields absent from older versions are lost
+		if version < 5 {
+			resp.Topics[0].Partitions[0].OfflineReplicas = nil
+		}
+		if version < 2 {
+			resp.ClusterID = ""
+		}
+		if version < 1 {
+			resp.Brokers[0].Rack = ""
+			resp.ControllerID = -1
+			resp.Topics[0].Internal = false
+		}
+		assert.Equal(t, resp, got, "version %v", version)
+	}
+}
+
+func TestListOffsetsRoundTrip(t *testing.T) {
+	for version := int16(0); version <= Supported[ListOffsetsKey].MaxVersion; version++ {
+		req := ListOffsetsRequest{
+			ReplicaID: -1,
+			Topics: []ListOffsetsTopic{
+				{Topic: "topic", Partitions: []ListOffsetsPartition{{Partition: 1, Timestamp: -2}}},
+			},
+		}
+
+		e := &Encoder{}
+		req.Encode(e, version)
+
+		var gotReq ListOffsetsRequest
+		d := NewDecoder(e.Encoded())
+		gotReq.Decode(d, version)
+		assert.Nil(t, d.Err())
+		assert.Equal(t, req, gotReq, "version %v", version)
+
+		resp := ListOffsetsResponse{
+			Topics: []ListOffsetsTopicResponse{
+				{Topic: "topic", Partitions: []ListOffsetsPartitionResponse{{Partition: 1, Timestamp: 1000, Offset: 123}}},
+			},
+		}
+
+		e = &Encoder{}
+		resp.Encode(e, version)
+
+		var got ListOffsetsResponse
+		d = NewDecoder(e.Encoded())
+		got.Decode(d, version)
+		assert.Nil(t, d.Err())
+		assert.Equal(t, 0, d.Remaining())
+
+		// version 0 returns offsets without timestamps
+		if version == 0 {
+			resp.Topics[0].Partitions[0].Timestamp = -1
+		}
+		assert.Equal(t, resp, got, "version %v", version)
+	}
+}
+
+func TestFetchRoundTrip(t *testing.T) {
+	for version := int16(0); version <= Supported[FetchKey].MaxVersion; version++ {
+		req := FetchRequest{
+			ReplicaID:   -1,
+			MaxWaitTime: 100,
+			Topics: []FetchTopic{
+				{Topic: "topic", Partitions: []FetchPartition{{Partition: 1, FetchOffset: 123, MaxBytes: 1024}}},
+			},
+		}
+		if version >= 3 {
+			req.MaxBytes = 4096
+		}
+
+		e := &Encoder{}
+		req.Encode(e, version)
+
+		var gotReq FetchRequest
+		d := NewDecoder(e.Encoded())
+		gotReq.Decode(d, version)
+		assert.Nil(t, d.Err())
+		assert.Equal(t, req, gotReq, "version %v", version)
+
+		resp := FetchResponse{
+			Topics: []FetchResponseTopic{
+				{Topic: "topic", Partitions: []FetchResponsePartition{{Partition: 1, HighWatermark: 200, LastStableOffset: -1, Records: []byte("records")}}},
+			},
+		}
+		if version >= 1 {
+			resp.ThrottleTime = 10
+		}
+		if version >= 4 {
+			resp.Topics[0].Partitions[0].LastStableOffset = 150
+		}
+
+		e = &Encoder{}
+		resp.Encode(e, version)
+
+		var got FetchResponse
+		d = NewDecoder(e.Encoded())
+		got.Decode(d, version)
+		assert.Nil(t, d.Err())
+		assert.Equal(t, 0, d.Remaining())
+		assert.Equal(t, resp, got, "version %v", version)
+	}
+}
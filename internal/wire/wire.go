@@ -0,0 +1,483 @@
+// Package wire implements the subset of the kafka wire protocol that kag
+// uses, negotiating the version of each request with the broker.
+//
+// wire replaces the vendored franz package as the transport of every request
+// kag sends.  It was introduced to commit offsets, as franz keeps
+// OffsetCommit, like the JoinGroup, SyncGroup, and Heartbeat requests used for
+// group membership, unexported.  franz also pins a single version of each
+// request, offers no hook for authenticating or tunnelling a connection before
+// its first request, and its stats types are not collected by franz.Conn.
+// Rather than patch the vendored package, kag speaks the protocol itself and
+// uses franz only for its error codes and DefaultClientID.
+//
+// See http://kafka.apache.org/protocol.html
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Api keys of the requests kag sends
+const (
+	ProduceKey          int16 = 0
+	FetchKey            int16 = 1
+	ListOffsetsKey      int16 = 2
+	MetadataKey         int16 = 3
+	OffsetCommitKey     int16 = 8
+	OffsetFetchKey      int16 = 9
+	FindCoordinatorKey  int16 = 10
+	JoinGroupKey        int16 = 11
+	HeartbeatKey        int16 = 12
+	LeaveGroupKey       int16 = 13
+	SyncGroupKey        int16 = 14
+	DescribeGroupsKey   int16 = 15
+	ListGroupsKey       int16 = 16
+	SaslHandshakeKey    int16 = 17
+	ApiVersionsKey      int16 = 18
+	SaslAuthenticateKey int16 = 36
+)
+
+// ErrTruncated is returned when a message ends before all fields were read
+var ErrTruncated = errors.New("kafka message truncated")
+
+// Encoder writes big endian kafka primitives
+type Encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *Encoder) Int8(v int8) {
+	e.buf.WriteByte(byte(v))
+}
+
+func (e *Encoder) Bool(v bool) {
+	if v {
+		e.Int8(1)
+	} else {
+		e.Int8(0)
+	}
+}
+
+func (e *Encoder) Int16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.buf.Write(b[:])
+}
+
+func (e *Encoder) Int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.buf.Write(b[:])
+}
+
+func (e *Encoder) Int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.buf.Write(b[:])
+}
+
+func (e *Encoder) String(v string) {
+	e.Int16(int16(len(v)))
+	e.buf.WriteString(v)
+}
+
+// Varint writes a zig-zag encoded variable length integer as used by v2
+// record batches
+func (e *Encoder) Varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	e.buf.Write(b[:n])
+}
+
+// NullableString writes -1 for the empty string
+func (e *Encoder) NullableString(v string) {
+	if v == "" {
+		e.Int16(-1)
+		return
+	}
+	e.String(v)
+}
+
+func (e *Encoder) Bytes(v []byte) {
+	if v == nil {
+		e.Int32(-1)
+		return
+	}
+	e.Int32(int32(len(v)))
+	e.buf.Write(v)
+}
+
+// ArrayLen writes the length prefix of an array
+func (e *Encoder) ArrayLen(n int) {
+	e.Int32(int32(n))
+}
+
+func (e *Encoder) Int32Array(v []int32) {
+	e.ArrayLen(len(v))
+	for _, item := range v {
+		e.Int32(item)
+	}
+}
+
+func (e *Encoder) StringArray(v []string) {
+	e.ArrayLen(len(v))
+	for _, item := range v {
+		e.String(item)
+	}
+}
+
+// Raw appends data without a length prefix
+func (e *Encoder) Raw(data []byte) {
+	e.buf.Write(data)
+}
+
+// Encoded returns the encoded content
+func (e *Encoder) Encoded() []byte {
+	return e.buf.Bytes()
+}
+
+// Decoder reads big endian kafka primitives.  The first error encountered is
+// retained and all subsequent reads return zero values.
+type Decoder struct {
+	data []byte
+	err  error
+}
+
+// NewDecoder returns a Decoder that reads from data
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{data: data}
+}
+
+func (d *Decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.data) < n {
+		d.err = ErrTruncated
+		return nil
+	}
+	v := d.data[:n]
+	d.data = d.data[n:]
+	return v
+}
+
+// Err returns the first error encountered while decoding
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Remaining returns the number of unread bytes
+func (d *Decoder) Remaining() int {
+	return len(d.data)
+}
+
+func (d *Decoder) Int8() int8 {
+	if v := d.next(1); v != nil {
+		return int8(v[0])
+	}
+	return 0
+}
+
+func (d *Decoder) Bool() bool {
+	return d.Int8() != 0
+}
+
+func (d *Decoder) Int16() int16 {
+	if v := d.next(2); v != nil {
+		return int16(binary.BigEndian.Uint16(v))
+	}
+	return 0
+}
+
+func (d *Decoder) Int32() int32 {
+	if v := d.next(4); v != nil {
+		return int32(binary.BigEndian.Uint32(v))
+	}
+	return 0
+}
+
+func (d *Decoder) Int64() int64 {
+	if v := d.next(8); v != nil {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+// Varint reads a zig-zag encoded variable length integer
+func (d *Decoder) Varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = ErrTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// String reads a string or nullable string; null is returned as ""
+func (d *Decoder) String() string {
+	n := d.Int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *Decoder) Bytes() []byte {
+	n := d.Int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// ArrayLen reads the length prefix of an array; null arrays are returned as 0
+func (d *Decoder) ArrayLen() int {
+	n := d.Int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > len(d.data) {
+		d.err = ErrTruncated
+		return 0
+	}
+	return int(n)
+}
+
+func (d *Decoder) Int32Array() []int32 {
+	n := d.ArrayLen()
+	v := make([]int32, 0, n)
+	for i := 0; i < n; i++ {
+		v = append(v, d.Int32())
+	}
+	return v
+}
+
+func (d *Decoder) StringArray() []string {
+	n := d.ArrayLen()
+	v := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		v = append(v, d.String())
+	}
+	return v
+}
+
+// Conn sends requests over a network connection to a kafka broker.  Requests
+// are serialized; Conn is safe for concurrent use.
+type Conn struct {
+	mutex         sync.Mutex
+	conn          net.Conn
+	r             *bufio.Reader
+	clientID      string
+	correlationID int32
+	timeout       time.Duration
+
+	// versions holds the versions supported by the broker; nil until
+	// negotiated
+	versions map[int16]ApiVersion
+
+	// stats summarizes the requests sent since Stats was last called
+	statsMutex sync.Mutex
+	stats      Stats
+}
+
+// Stats summarizes the requests sent over a Conn
+type Stats struct {
+	Requests int
+	Errors   int
+
+	// Latency holds the total time spent waiting on requests and MaxLatency
+	// the longest any one took
+	Latency    time.Duration
+	MaxLatency time.Duration
+}
+
+// Add combines the requests summarized by v with those of s
+func (s *Stats) Add(v Stats) {
+	s.Requests += v.Requests
+	s.Errors += v.Errors
+	s.Latency += v.Latency
+	if v.MaxLatency > s.MaxLatency {
+		s.MaxLatency = v.MaxLatency
+	}
+}
+
+// NewConn returns a Conn that communicates over conn.  If timeout is non-zero,
+// each request must complete within timeout.
+func NewConn(conn net.Conn, clientID string, timeout time.Duration) *Conn {
+	return &Conn{
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		clientID: clientID,
+		timeout:  timeout,
+	}
+}
+
+// Do sends a request with the given api key and version, the body of which is
+// written by req, and passes the body of the response to resp
+func (c *Conn) Do(apiKey, apiVersion int16, req func(*Encoder), resp func(*Decoder) error) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	started := time.Now()
+	err := c.do(apiKey, apiVersion, req, resp)
+	c.observe(time.Since(started), err)
+	return err
+}
+
+func (c *Conn) do(apiKey, apiVersion int16, req func(*Encoder), resp func(*Decoder) error) error {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+
+	c.correlationID++
+	correlationID := c.correlationID
+
+	body := &Encoder{}
+	body.Int16(apiKey)
+	body.Int16(apiVersion)
+	body.Int32(correlationID)
+	body.String(c.clientID)
+	if req != nil {
+		req(body)
+	}
+
+	if err := WriteFrame(c.conn, body.Encoded()); err != nil {
+		return errors.Wrapf(err, "unable to write request, %v", apiKey)
+	}
+
+	data, err := ReadFrame(c.r)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read response, %v", apiKey)
+	}
+
+	d := NewDecoder(data)
+	if id := d.Int32(); id != correlationID {
+		return errors.Errorf("correlation id mismatch; expected %v, got %v", correlationID, id)
+	}
+	if resp == nil {
+		return nil
+	}
+	if err := resp(d); err != nil {
+		return err
+	}
+	return d.Err()
+}
+
+func (c *Conn) observe(latency time.Duration, err error) {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+
+	c.stats.Requests++
+	if err != nil {
+		c.stats.Errors++
+	}
+	c.stats.Latency += latency
+	if latency > c.stats.MaxLatency {
+		c.stats.MaxLatency = latency
+	}
+}
+
+// Stats returns a summary of the requests sent since the previous call to
+// Stats
+func (c *Conn) Stats() Stats {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+
+	stats := c.stats
+	c.stats = Stats{}
+	return stats
+}
+
+// Negotiate retrieves the versions of each request supported by the broker.
+// Brokers that predate ApiVersions, kafka 0.9, close the connection instead.
+func (c *Conn) Negotiate() error {
+	var resp ApiVersionsResponse
+	err := c.Do(ApiVersionsKey, 0, nil, func(d *Decoder) error {
+		resp.Decode(d)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if resp.ErrorCode != 0 {
+		return errors.Errorf("unable to retrieve api versions, error code %v", resp.ErrorCode)
+	}
+
+	c.SetApiVersions(resp.ApiVersions)
+	return nil
+}
+
+// SetApiVersions records the versions of each request supported by the
+// broker, e.g. Legacy for brokers that cannot be negotiated with
+func (c *Conn) SetApiVersions(versions []ApiVersion) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.versions = map[int16]ApiVersion{}
+	for _, v := range versions {
+		c.versions[v.ApiKey] = v
+	}
+}
+
+// Version returns the highest version of the request supported by both the
+// broker and this package or UnsupportedVersionError if there is none.  The
+// highest version implemented is returned until versions are negotiated.
+func (c *Conn) Version(apiKey int16) (int16, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return pick(apiKey, c.versions)
+}
+
+// RemoteAddr returns the address of the broker
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the underlying network connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ReadFrame reads a single size delimited message
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := int32(binary.BigEndian.Uint32(size[:]))
+	if n < 0 || n > maxFrameSize {
+		return nil, errors.Errorf("invalid message size, %v", n)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// WriteFrame writes a single size delimited message
+func WriteFrame(w io.Writer, data []byte) error {
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err := w.Write(frame)
+	return err
+}
+
+// maxFrameSize guards against allocating absurd buffers on corrupt input
+const maxFrameSize = 100 * 1024 * 1024
@@ -0,0 +1,108 @@
+package wire
+
+import (
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestDecoderTruncated(t *testing.T) {
+	d := NewDecoder([]byte{0, 5, 'a'})
+	assert.Equal(t, "", d.String())
+	assert.Equal(t, ErrTruncated, d.Err())
+	assert.Equal(t, int32(0), d.Int32())
+}
+
+func TestOffsetCommitRoundTrip(t *testing.T) {
+	req := OffsetCommitRequest{
+		GroupID:       "group",
+		GenerationID:  -1,
+		RetentionTime: -1,
+		Topics: []OffsetCommitTopic{
+			{
+				Topic:      "topic",
+				Partitions: []OffsetCommitPartition{{Partition: 1, Offset: 123}},
+			},
+		},
+	}
+
+	e := &Encoder{}
+	req.Encode(e)
+
+	var got OffsetCommitRequest
+	d := NewDecoder(e.Encoded())
+	got.Decode(d)
+	assert.Nil(t, d.Err())
+	assert.Equal(t, 0, d.Remaining())
+	assert.Equal(t, req, got)
+}
+
+func TestJoinGroupRoundTrip(t *testing.T) {
+	resp := JoinGroupResponse{
+		GenerationID: 3,
+		Protocol:     "election",
+		LeaderID:     "a",
+		MemberID:     "a",
+		Members: []JoinGroupMember{
+			{MemberID: "a", Metadata: []byte{}},
+			{MemberID: "b", Metadata: []byte{1}},
+		},
+	}
+
+	e := &Encoder{}
+	resp.Encode(e)
+
+	var got JoinGroupResponse
+	d := NewDecoder(e.Encoded())
+	got.Decode(d)
+	assert.Nil(t, d.Err())
+	assert.Equal(t, 0, d.Remaining())
+	assert.Equal(t, resp, got)
+}
+
+func TestRecordTimestamps(t *testing.T) {
+	e := &Encoder{}
+	RecordBatch{BaseOffset: 10, Timestamps: []int64{1000, 1500, 2000}}.Encode(e)
+	RecordBatch{BaseOffset: 20, Timestamps: []int64{3000}}.Encode(e)
+	data := e.Encoded()
+
+	timestamps := RecordTimestamps(data)
+	assert.Equal(t, []RecordTimestamp{
+		{FirstOffset: 10, LastOffset: 10, Timestamp: 1000},
+		{FirstOffset: 11, LastOffset: 11, Timestamp: 1500},
+		{FirstOffset: 12, LastOffset: 12, Timestamp: 2000},
+		{FirstOffset: 20, LastOffset: 20, Timestamp: 3000},
+	}, timestamps)
+
+	ts, ok := FindTimestamp(timestamps, 11)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1500), ts)
+
+	ts, ok = FindTimestamp(timestamps, 13) // gap left by compaction
+	assert.True(t, ok)
+	assert.Equal(t, int64(3000), ts)
+
+	_, ok = FindTimestamp(timestamps, 21)
+	assert.False(t, ok)
+
+	t.Run("truncated", func(t *testing.T) {
+		truncated := RecordTimestamps(data[:len(data)-4])
+		assert.Len(t, truncated, 4)
+		assert.Equal(t, int64(3000), truncated[3].Timestamp)
+
+		header := RecordTimestamps(data[:recordBatchHeaderSize])
+		assert.Equal(t, []RecordTimestamp{{FirstOffset: 10, LastOffset: 12, Timestamp: 1000}}, header)
+	})
+
+	t.Run("message sets", func(t *testing.T) {
+		e := &Encoder{}
+		MessageSet{BaseOffset: 10, Magic: 1, Timestamps: []int64{1000, 1500}}.Encode(e)
+		MessageSet{BaseOffset: 12, Timestamps: []int64{2000}}.Encode(e)
+
+		assert.Equal(t, []RecordTimestamp{
+			{FirstOffset: 10, LastOffset: 10, Timestamp: 1000},
+			{FirstOffset: 11, LastOffset: 11, Timestamp: 1500},
+			{FirstOffset: 12, LastOffset: 12, Timestamp: -1},
+		}, RecordTimestamps(e.Encoded()))
+	})
+}
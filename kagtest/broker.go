@@ -0,0 +1,496 @@
+package kagtest
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/savaki/kag/internal/wire"
+)
+
+// Kafka error codes returned by the fake brokers
+const (
+	errNone                    int16 = 0
+	errOffsetOutOfRange        int16 = 1
+	errUnknownTopicOrPartition int16 = 3
+	errLeaderNotAvailable      int16 = 5
+	errNotLeaderForPartition   int16 = 6
+	errCoordinatorNotAvailable int16 = 15
+	errNotCoordinator          int16 = 16
+	errIllegalGeneration       int16 = 22
+	errInconsistentProtocol    int16 = 23
+	errUnknownMemberID         int16 = 25
+	errRebalanceInProgress     int16 = 27
+)
+
+// maxFetchRecords limits the records returned per partition by Fetch
+const maxFetchRecords = 100
+
+type broker struct {
+	cluster *Cluster
+	nodeID  int32
+
+	mutex    sync.Mutex
+	addr     string
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+}
+
+func (b *broker) listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	b.listener = listener
+	b.addr = listener.Addr().String()
+	b.mutex.Unlock()
+
+	go b.accept(listener)
+	return nil
+}
+
+func (b *broker) running() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.listener != nil
+}
+
+// hostPort returns the host and port advertised in metadata
+func (b *broker) hostPort() (string, int32) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	host, port, _ := net.SplitHostPort(b.addr)
+	v, _ := strconv.Atoi(port)
+	return host, int32(v)
+}
+
+// stop closes the listener and every open connection
+func (b *broker) stop() {
+	b.mutex.Lock()
+	listener := b.listener
+	b.listener = nil
+	var conns []net.Conn
+	for conn := range b.conns {
+		conns = append(conns, conn)
+	}
+	b.mutex.Unlock()
+
+	if listener != nil {
+		listener.Close()
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func (b *broker) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		b.mutex.Lock()
+		if b.listener != listener {
+			b.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		b.conns[conn] = struct{}{}
+		b.mutex.Unlock()
+
+		go b.serve(conn)
+	}
+}
+
+// serve handles the requests of a single connection in order.  Connections
+// sending unsupported requests, or failing to authenticate when SASL is
+// required, are closed, as Kafka does.
+func (b *broker) serve(conn net.Conn) {
+	defer func() {
+		b.mutex.Lock()
+		delete(b.conns, conn)
+		b.mutex.Unlock()
+		conn.Close()
+	}()
+
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	sasl := &saslConn{authenticated: !b.cluster.saslRequired()}
+	r := bufio.NewReader(conn)
+	for {
+		data, err := wire.ReadFrame(r)
+		if err != nil {
+			return
+		}
+
+		d := wire.NewDecoder(data)
+		apiKey := d.Int16()
+		apiVersion := d.Int16()
+		correlationID := d.Int32()
+		clientID := d.String()
+		if d.Err() != nil {
+			return
+		}
+
+		e := &wire.Encoder{}
+		e.Int32(correlationID)
+		if !sasl.authenticated {
+			if !b.cluster.authenticate(sasl, apiKey, apiVersion, d, e) || d.Err() != nil {
+				return
+			}
+		} else if !b.handle(apiKey, apiVersion, clientID, host, d, e) || d.Err() != nil {
+			return
+		}
+		if err := wire.WriteFrame(conn, e.Encoded()); err != nil {
+			return
+		}
+		if sasl.failed {
+			return
+		}
+	}
+}
+
+// handle decodes the request and encodes the response; returns false if
+// the request is not supported
+func (b *broker) handle(apiKey, apiVersion int16, clientID, clientHost string, d *wire.Decoder, e *wire.Encoder) bool {
+	c := b.cluster
+	if !c.supports(apiKey, apiVersion) {
+		return false
+	}
+
+	switch apiKey {
+	case wire.ApiVersionsKey:
+		c.apiVersions().Encode(e)
+	case wire.MetadataKey:
+		var req wire.MetadataRequest
+		req.Decode(d, apiVersion)
+		b.metadata(req).Encode(e, apiVersion)
+	case wire.ListOffsetsKey:
+		var req wire.ListOffsetsRequest
+		req.Decode(d, apiVersion)
+		b.listOffsets(req).Encode(e, apiVersion)
+	case wire.FetchKey:
+		var req wire.FetchRequest
+		req.Decode(d, apiVersion)
+		b.fetch(req, apiVersion).Encode(e, apiVersion)
+	case wire.OffsetCommitKey:
+		b.offsetCommit(d, e)
+	case wire.OffsetFetchKey:
+		var req wire.OffsetFetchRequest
+		req.Decode(d, apiVersion)
+		b.offsetFetch(req).Encode(e, apiVersion)
+	case wire.FindCoordinatorKey:
+		var req wire.FindCoordinatorRequest
+		req.Decode(d, apiVersion)
+		b.findCoordinator(req).Encode(e, apiVersion)
+	case wire.JoinGroupKey:
+		var req wire.JoinGroupRequest
+		req.Decode(d)
+		c.join(b, clientID, clientHost, req).Encode(e)
+	case wire.SyncGroupKey:
+		var req wire.SyncGroupRequest
+		req.Decode(d)
+		c.sync(b, req).Encode(e)
+	case wire.HeartbeatKey:
+		var req wire.HeartbeatRequest
+		req.Decode(d)
+		c.heartbeat(b, req).Encode(e)
+	case wire.LeaveGroupKey:
+		var req wire.LeaveGroupRequest
+		req.Decode(d)
+		c.leave(b, req).Encode(e)
+	case wire.DescribeGroupsKey:
+		var req wire.DescribeGroupsRequest
+		req.Decode(d, apiVersion)
+		c.describeGroups(b, req).Encode(e, apiVersion)
+	case wire.ListGroupsKey:
+		c.listGroups(b).Encode(e, apiVersion)
+	default:
+		return false
+	}
+	return true
+}
+
+func (b *broker) metadata(req wire.MetadataRequest) wire.MetadataResponse {
+	c := b.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	resp := wire.MetadataResponse{
+		ClusterID:    clusterID,
+		ControllerID: -1,
+	}
+	for _, item := range c.live() {
+		host, port := item.hostPort()
+		resp.Brokers = append(resp.Brokers, wire.MetadataBroker{
+			NodeID: item.nodeID,
+			Host:   host,
+			Port:   port,
+			Rack:   c.racks[item.nodeID],
+		})
+		if resp.ControllerID == -1 {
+			resp.ControllerID = item.nodeID
+		}
+	}
+
+	topics := req.Topics
+	if topics == nil {
+		topics = c.topicNames()
+	}
+	for _, topic := range topics {
+		partitions, ok := c.topics[topic]
+		if !ok {
+			resp.Topics = append(resp.Topics, wire.MetadataTopic{
+				ErrorCode: errUnknownTopicOrPartition,
+				Topic:     topic,
+			})
+			continue
+		}
+
+		item := wire.MetadataTopic{
+			Topic:    topic,
+			Internal: topic == "__consumer_offsets",
+		}
+		for i, p := range partitions {
+			code := errNone
+			if p.leader == -1 {
+				code = errLeaderNotAvailable
+			}
+			item.Partitions = append(item.Partitions, wire.MetadataPartition{
+				ErrorCode:       code,
+				Partition:       int32(i),
+				Leader:          p.leader,
+				Replicas:        p.replicas,
+				Isr:             p.isr,
+				OfflineReplicas: []int32{},
+			})
+		}
+		resp.Topics = append(resp.Topics, item)
+	}
+	return resp
+}
+
+// leaderOf returns the partition if this broker leads it or an error code
+func (b *broker) leaderOf(topic string, partition int32) (*partition, int16) {
+	p, err := b.cluster.partition(topic, partition)
+	if err != nil {
+		return nil, errUnknownTopicOrPartition
+	}
+	if p.leader != b.nodeID {
+		return nil, errNotLeaderForPartition
+	}
+	return p, errNone
+}
+
+func (b *broker) listOffsets(req wire.ListOffsetsRequest) wire.ListOffsetsResponse {
+	c := b.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var resp wire.ListOffsetsResponse
+	for _, t := range req.Topics {
+		item := wire.ListOffsetsTopicResponse{Topic: t.Topic}
+		for _, rp := range t.Partitions {
+			p, code := b.leaderOf(t.Topic, rp.Partition)
+			if code != errNone {
+				item.Partitions = append(item.Partitions, wire.ListOffsetsPartitionResponse{
+					Partition: rp.Partition,
+					ErrorCode: code,
+					Timestamp: -1,
+					Offset:    -1,
+				})
+				continue
+			}
+
+			timestamp, offset := int64(-1), int64(-1)
+			switch rp.Timestamp {
+			case -1:
+				offset = p.newest()
+			case -2:
+				offset = p.oldest
+			default:
+				for k, ts := range p.timestamps {
+					if ts >= rp.Timestamp {
+						timestamp, offset = ts, p.oldest+int64(k)
+						break
+					}
+				}
+			}
+			item.Partitions = append(item.Partitions, wire.ListOffsetsPartitionResponse{
+				Partition: rp.Partition,
+				Timestamp: timestamp,
+				Offset:    offset,
+			})
+		}
+		resp.Topics = append(resp.Topics, item)
+	}
+	return resp
+}
+
+// fetch returns records in the format of the request version: v2 record
+// batches for v4, v1 messages for v2 and v3, and v0 messages, without
+// timestamps, for v0 and v1
+func (b *broker) fetch(req wire.FetchRequest, version int16) wire.FetchResponse {
+	c := b.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var resp wire.FetchResponse
+	for _, t := range req.Topics {
+		item := wire.FetchResponseTopic{Topic: t.Topic}
+		for _, fp := range t.Partitions {
+			rp := wire.FetchResponsePartition{
+				Partition:        fp.Partition,
+				HighWatermark:    -1,
+				LastStableOffset: -1,
+			}
+
+			p, code := b.leaderOf(t.Topic, fp.Partition)
+			switch {
+			case code != errNone:
+				rp.ErrorCode = code
+			case fp.FetchOffset < p.oldest || fp.FetchOffset > p.newest():
+				rp.ErrorCode = errOffsetOutOfRange
+			default:
+				rp.HighWatermark = p.newest()
+				rp.LastStableOffset = p.newest()
+				rp.Records = p.records(fp.FetchOffset, fp.MaxBytes, version)
+			}
+			item.Partitions = append(item.Partitions, rp)
+		}
+		resp.Topics = append(resp.Topics, item)
+	}
+	return resp
+}
+
+// records encodes the records from offset, in the format of the Fetch
+// version, within maxBytes.
+// As with Kafka, the first batch is returned even when larger than maxBytes.
+func (p *partition) records(offset int64, maxBytes int32, version int16) []byte {
+	timestamps := p.timestamps[offset-p.oldest:]
+	if len(timestamps) > maxFetchRecords {
+		timestamps = timestamps[:maxFetchRecords]
+	}
+	for {
+		e := &wire.Encoder{}
+		if len(timestamps) == 0 {
+			return e.Encoded()
+		}
+		switch {
+		case version >= 4:
+			wire.RecordBatch{BaseOffset: offset, Timestamps: timestamps}.Encode(e)
+		case version >= 2:
+			wire.MessageSet{BaseOffset: offset, Magic: 1, Timestamps: timestamps}.Encode(e)
+		default:
+			wire.MessageSet{BaseOffset: offset, Timestamps: timestamps}.Encode(e)
+		}
+		if data := e.Encoded(); len(data) <= int(maxBytes) || len(timestamps) == 1 {
+			return data
+		}
+		timestamps = timestamps[:len(timestamps)/2]
+	}
+}
+
+func (b *broker) offsetCommit(d *wire.Decoder, e *wire.Encoder) {
+	var req wire.OffsetCommitRequest
+	req.Decode(d)
+
+	c := b.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	coordinator := c.coordinator(req.GroupID) == b
+
+	var resp wire.OffsetCommitResponse
+	for _, t := range req.Topics {
+		item := wire.OffsetCommitResponseTopic{Topic: t.Topic}
+		for _, p := range t.Partitions {
+			code := errNone
+			switch _, err := c.partition(t.Topic, p.Partition); {
+			case !coordinator:
+				code = errNotCoordinator
+			case err != nil:
+				code = errUnknownTopicOrPartition
+			default:
+				c.group(req.GroupID).commit(t.Topic, p.Partition, p.Offset)
+			}
+			item.Partitions = append(item.Partitions, wire.PartitionError{
+				Partition: p.Partition,
+				ErrorCode: code,
+			})
+		}
+		resp.Topics = append(resp.Topics, item)
+	}
+	resp.Encode(e)
+}
+
+func (b *broker) offsetFetch(req wire.OffsetFetchRequest) wire.OffsetFetchResponse {
+	c := b.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.coordinator(req.GroupID) != b {
+		return wire.OffsetFetchResponse{ErrorCode: errNotCoordinator}
+	}
+
+	offsets := map[string]map[int32]int64{}
+	if g, ok := c.groups[req.GroupID]; ok {
+		offsets = g.offsets
+	}
+
+	// a nil topic array requests every committed offset
+	topics := req.Topics
+	if topics == nil {
+		for topic, partitions := range offsets {
+			item := wire.OffsetFetchTopic{Topic: topic}
+			for partition := range partitions {
+				item.Partitions = append(item.Partitions, partition)
+			}
+			topics = append(topics, item)
+		}
+	}
+
+	var resp wire.OffsetFetchResponse
+	for _, t := range topics {
+		item := wire.OffsetFetchTopicResponse{Topic: t.Topic}
+		for _, partition := range t.Partitions {
+			offset, ok := offsets[t.Topic][partition]
+			if !ok {
+				offset = -1
+			}
+			item.Partitions = append(item.Partitions, wire.OffsetFetchPartition{
+				Partition: partition,
+				Offset:    offset,
+			})
+		}
+		resp.Topics = append(resp.Topics, item)
+	}
+	return resp
+}
+
+func (b *broker) findCoordinator(req wire.FindCoordinatorRequest) wire.FindCoordinatorResponse {
+	c := b.cluster
+	c.mutex.Lock()
+	coordinator := c.coordinator(req.GroupID)
+	c.mutex.Unlock()
+
+	if coordinator == nil {
+		return wire.FindCoordinatorResponse{
+			ErrorCode: errCoordinatorNotAvailable,
+			NodeID:    -1,
+			Port:      -1,
+		}
+	}
+
+	host, port := coordinator.hostPort()
+	return wire.FindCoordinatorResponse{
+		NodeID: coordinator.nodeID,
+		Host:   host,
+		Port:   port,
+	}
+}
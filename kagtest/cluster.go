@@ -0,0 +1,418 @@
+// Package kagtest provides an in-process fake Kafka cluster for testing kag,
+// and code built on it, without a real cluster.  Brokers listen on loopback
+// and speak the subset of the Kafka wire protocol used by kag, accepting
+// every version of each request implemented by package wire.  Brokers may be
+// limited to the versions of an older release of kafka with SetApiVersion
+// and DisableApiVersions, and may require SASL authentication.
+//
+// The state of the cluster is scriptable: topics may be created, records
+// produced and truncated, group offsets committed, and brokers killed and
+// restarted while clients are connected.
+package kagtest
+
+import (
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/savaki/kag/internal/wire"
+)
+
+// Cluster is an in-process fake Kafka cluster.  Cluster is safe for
+// concurrent use.
+type Cluster struct {
+	mutex   sync.Mutex
+	brokers []*broker
+	topics  map[string][]*partition
+	groups  map[string]*group
+	members int
+	done    chan struct{}
+
+	// versions holds the versions of each request the brokers accept
+	versions map[int16]wire.ApiVersion
+
+	// racks holds the rack of each broker, if set
+	racks map[int32]string
+
+	// sasl holds the verifier of each SASL mechanism new connections may
+	// authenticate with; connections need not authenticate when empty
+	sasl map[string]func(auth []byte) bool
+}
+
+type partition struct {
+	leader   int32
+	replicas []int32
+	isr      []int32
+
+	// oldest holds the offset of the first record; offsets before oldest
+	// have been removed by retention
+	oldest int64
+
+	// timestamps holds the timestamp, in ms, of each record from oldest on
+	timestamps []int64
+}
+
+func (p *partition) newest() int64 {
+	return p.oldest + int64(len(p.timestamps))
+}
+
+// NewCluster starts a cluster with the given number of brokers.  Node ids
+// are numbered from 1.
+func NewCluster(brokers int) (*Cluster, error) {
+	if brokers < 1 {
+		return nil, errors.Errorf("at least one broker is required")
+	}
+
+	c := &Cluster{
+		topics:   map[string][]*partition{},
+		groups:   map[string]*group{},
+		versions: defaultVersions(),
+		racks:    map[int32]string{},
+		done:     make(chan struct{}),
+	}
+	for i := 0; i < brokers; i++ {
+		b := &broker{
+			cluster: c,
+			nodeID:  int32(i + 1),
+			conns:   map[net.Conn]struct{}{},
+		}
+		if err := b.listen("127.0.0.1:0"); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.brokers = append(c.brokers, b)
+	}
+	go c.expireSessions()
+
+	return c, nil
+}
+
+// Close stops every broker
+func (c *Cluster) Close() error {
+	c.mutex.Lock()
+	brokers := c.brokers
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	c.mutex.Unlock()
+
+	for _, b := range brokers {
+		b.stop()
+	}
+	return nil
+}
+
+// Addrs returns the addresses of the live brokers
+func (c *Cluster) Addrs() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var addrs []string
+	for _, b := range c.live() {
+		addrs = append(addrs, b.addr)
+	}
+	return addrs
+}
+
+// live returns the brokers that are running in order of node id
+func (c *Cluster) live() []*broker {
+	var brokers []*broker
+	for _, b := range c.brokers {
+		if b.running() {
+			brokers = append(brokers, b)
+		}
+	}
+	return brokers
+}
+
+func (c *Cluster) broker(nodeID int32) (*broker, error) {
+	for _, b := range c.brokers {
+		if b.nodeID == nodeID {
+			return b, nil
+		}
+	}
+	return nil, errors.Errorf("unknown broker, %v", nodeID)
+}
+
+// CreateTopic creates a topic whose partitions are led by the brokers in
+// turn and replicated to up to replicationFactor brokers
+func (c *Cluster) CreateTopic(topic string, partitions, replicationFactor int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.topics[topic]; ok {
+		return errors.Errorf("topic, %v, already exists", topic)
+	}
+	if partitions < 1 {
+		return errors.Errorf("at least one partition is required")
+	}
+	if replicationFactor < 1 || replicationFactor > len(c.brokers) {
+		return errors.Errorf("replication factor must be between 1 and %v", len(c.brokers))
+	}
+
+	for i := 0; i < partitions; i++ {
+		var replicas []int32
+		for r := 0; r < replicationFactor; r++ {
+			replicas = append(replicas, c.brokers[(i+r)%len(c.brokers)].nodeID)
+		}
+		c.topics[topic] = append(c.topics[topic], &partition{
+			leader:   replicas[0],
+			replicas: replicas,
+			isr:      append([]int32{}, replicas...),
+		})
+	}
+	return nil
+}
+
+func (c *Cluster) partition(topic string, p int32) (*partition, error) {
+	partitions, ok := c.topics[topic]
+	if !ok {
+		return nil, errors.Errorf("unknown topic, %v", topic)
+	}
+	if p < 0 || int(p) >= len(partitions) {
+		return nil, errors.Errorf("unknown partition, %v/%v", topic, p)
+	}
+	return partitions[p], nil
+}
+
+// Produce appends n records timestamped now to the partition and returns
+// the new newest offset
+func (c *Cluster) Produce(topic string, partition int32, n int) (int64, error) {
+	return c.ProduceAt(topic, partition, time.Now(), n)
+}
+
+// ProduceAt appends n records with the given timestamp to the partition and
+// returns the new newest offset
+func (c *Cluster) ProduceAt(topic string, partition int32, t time.Time, n int) (int64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	p, err := c.partition(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	ms := t.UnixNano() / int64(time.Millisecond)
+	for i := 0; i < n; i++ {
+		p.timestamps = append(p.timestamps, ms)
+	}
+	return p.newest(), nil
+}
+
+// Truncate removes the records before offset from the partition, as
+// retention would
+func (c *Cluster) Truncate(topic string, partition int32, offset int64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	p, err := c.partition(topic, partition)
+	if err != nil {
+		return err
+	}
+	if offset <= p.oldest {
+		return nil
+	}
+	if offset > p.newest() {
+		offset = p.newest()
+	}
+	p.timestamps = p.timestamps[offset-p.oldest:]
+	p.oldest = offset
+	return nil
+}
+
+// Offsets returns the oldest and newest offsets of the partition
+func (c *Cluster) Offsets(topic string, partition int32) (oldest, newest int64, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	p, err := c.partition(topic, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	return p.oldest, p.newest(), nil
+}
+
+// Commit commits an offset on behalf of the consumer group, creating the
+// group if needed
+func (c *Cluster) Commit(groupID, topic string, partition int32, offset int64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, err := c.partition(topic, partition); err != nil {
+		return err
+	}
+	c.group(groupID).commit(topic, partition, offset)
+	return nil
+}
+
+// Committed returns the offset committed by the consumer group or false if
+// none has been
+func (c *Cluster) Committed(groupID, topic string, partition int32) (int64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	g, ok := c.groups[groupID]
+	if !ok {
+		return 0, false
+	}
+	offset, ok := g.offsets[topic][partition]
+	return offset, ok
+}
+
+// DeleteGroup removes the consumer group and its offsets.  As with Kafka,
+// groups with active members can't be deleted.
+func (c *Cluster) DeleteGroup(groupID string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	g, ok := c.groups[groupID]
+	if !ok {
+		return errors.Errorf("unknown group, %v", groupID)
+	}
+	if len(g.members) > 0 {
+		return errors.Errorf("group, %v, has active members", groupID)
+	}
+	delete(c.groups, groupID)
+	return nil
+}
+
+// SetLeader makes the broker the leader of the partition
+func (c *Cluster) SetLeader(topic string, partition int32, nodeID int32) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	p, err := c.partition(topic, partition)
+	if err != nil {
+		return err
+	}
+	if _, err := c.broker(nodeID); err != nil && nodeID != -1 {
+		return err
+	}
+	p.leader = nodeID
+	return nil
+}
+
+// SetISR sets the in sync replicas of the partition
+func (c *Cluster) SetISR(topic string, partition int32, isr ...int32) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	p, err := c.partition(topic, partition)
+	if err != nil {
+		return err
+	}
+	p.isr = append([]int32{}, isr...)
+	return nil
+}
+
+// KillBroker stops the broker, closing its connections.  Partitions it led
+// fail over to another in sync replica, or go offline if there is none, and
+// the broker leaves the in sync replicas of every partition.
+func (c *Cluster) KillBroker(nodeID int32) error {
+	c.mutex.Lock()
+	b, err := c.broker(nodeID)
+	if err != nil {
+		c.mutex.Unlock()
+		return err
+	}
+
+	for _, partitions := range c.topics {
+		for _, p := range partitions {
+			p.isr = without(p.isr, nodeID)
+			if p.leader != nodeID {
+				continue
+			}
+			p.leader = -1
+			if len(p.isr) > 0 {
+				p.leader = p.isr[0]
+			}
+		}
+	}
+	c.mutex.Unlock()
+
+	b.stop()
+	return nil
+}
+
+// RestartBroker restarts a killed broker on its original address.  The
+// broker rejoins the in sync replicas of its partitions and leads any that
+// are offline; leadership that moved elsewhere stays there.
+func (c *Cluster) RestartBroker(nodeID int32) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	b, err := c.broker(nodeID)
+	if err != nil {
+		return err
+	}
+	if b.running() {
+		return nil
+	}
+	if err := b.listen(b.addr); err != nil {
+		return err
+	}
+
+	for _, partitions := range c.topics {
+		for _, p := range partitions {
+			if !contains(p.replicas, nodeID) {
+				continue
+			}
+			p.isr = append(p.isr, nodeID)
+			if p.leader == -1 {
+				p.leader = nodeID
+			}
+		}
+	}
+	return nil
+}
+
+// coordinator returns the broker that coordinates the group
+func (c *Cluster) coordinator(groupID string) *broker {
+	live := c.live()
+	if len(live) == 0 {
+		return nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(groupID))
+	return live[h.Sum32()%uint32(len(live))]
+}
+
+// nextMemberID returns a unique member id for a client
+func (c *Cluster) nextMemberID(clientID string) string {
+	c.members++
+	return clientID + "-" + strconv.Itoa(c.members)
+}
+
+// topicNames returns the names of every topic in order
+func (c *Cluster) topicNames() []string {
+	var names []string
+	for name := range c.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func without(ids []int32, id int32) []int32 {
+	var v []int32
+	for _, item := range ids {
+		if item != id {
+			v = append(v, item)
+		}
+	}
+	return v
+}
+
+func contains(ids []int32, id int32) bool {
+	for _, item := range ids {
+		if item == id {
+			return true
+		}
+	}
+	return false
+}
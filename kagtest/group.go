@@ -0,0 +1,476 @@
+package kagtest
+
+import (
+	"sort"
+	"time"
+
+	"github.com/savaki/kag/internal/wire"
+)
+
+// states of a consumer group as reported by DescribeGroups
+const (
+	stateEmpty               = "Empty"
+	statePreparingRebalance  = "PreparingRebalance"
+	stateCompletingRebalance = "CompletingRebalance"
+	stateStable              = "Stable"
+	stateDead                = "Dead"
+)
+
+// sessionCheckInterval is how often member sessions are checked for expiry
+const sessionCheckInterval = 50 * time.Millisecond
+
+// group holds the membership and committed offsets of a group.  All fields
+// are guarded by Cluster.mutex.
+type group struct {
+	id           string
+	protocolType string
+	protocol     string
+	state        string
+	generation   int32
+	leader       string
+	members      map[string]*member
+	offsets      map[string]map[int32]int64
+
+	// rebalance is closed when the pending join completes
+	rebalance chan struct{}
+
+	// synced is closed when the leader syncs or the sync is abandoned
+	synced chan struct{}
+
+	// epoch distinguishes rebalances so that stale timers are ignored
+	epoch int
+}
+
+type member struct {
+	id               string
+	clientID         string
+	clientHost       string
+	metadata         []byte
+	protocols        []string
+	sessionTimeout   time.Duration
+	rebalanceTimeout time.Duration
+	lastHeartbeat    time.Time
+	assignment       []byte
+
+	// joined is true once the member has joined the pending rebalance
+	joined bool
+}
+
+func (m *member) supports(protocol string) bool {
+	for _, item := range m.protocols {
+		if item == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// group returns the group, creating it if needed
+func (c *Cluster) group(groupID string) *group {
+	g, ok := c.groups[groupID]
+	if !ok {
+		g = &group{
+			id:      groupID,
+			state:   stateEmpty,
+			members: map[string]*member{},
+			offsets: map[string]map[int32]int64{},
+		}
+		c.groups[groupID] = g
+	}
+	return g
+}
+
+func (g *group) commit(topic string, partition int32, offset int64) {
+	if g.offsets[topic] == nil {
+		g.offsets[topic] = map[int32]int64{}
+	}
+	g.offsets[topic][partition] = offset
+}
+
+// prepareRebalance starts a rebalance that completes once every member has
+// rejoined or the rebalance timeout elapses
+func (g *group) prepareRebalance(c *Cluster) {
+	if g.state == stateCompletingRebalance {
+		close(g.synced)
+	}
+	g.state = statePreparingRebalance
+	g.rebalance = make(chan struct{})
+	g.epoch++
+
+	var timeout time.Duration
+	for _, m := range g.members {
+		m.joined = false
+		if m.rebalanceTimeout > timeout {
+			timeout = m.rebalanceTimeout
+		}
+	}
+
+	epoch := g.epoch
+	time.AfterFunc(timeout, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if g.epoch == epoch && g.state == statePreparingRebalance {
+			g.completeJoin()
+		}
+	})
+}
+
+func (g *group) allJoined() bool {
+	for _, m := range g.members {
+		if !m.joined {
+			return false
+		}
+	}
+	return true
+}
+
+// completeJoin removes the members that failed to rejoin and starts the next
+// generation
+func (g *group) completeJoin() {
+	for id, m := range g.members {
+		if !m.joined {
+			delete(g.members, id)
+		}
+	}
+
+	g.generation++
+	if len(g.members) == 0 {
+		g.state = stateEmpty
+		g.leader = ""
+		g.protocol = ""
+	} else {
+		if _, ok := g.members[g.leader]; !ok {
+			g.leader = g.memberIDs()[0]
+		}
+		g.state = stateCompletingRebalance
+		g.synced = make(chan struct{})
+	}
+	close(g.rebalance)
+}
+
+// membersChanged responds to members leaving or expiring
+func (g *group) membersChanged(c *Cluster) {
+	switch {
+	case g.state == statePreparingRebalance:
+		if g.allJoined() {
+			g.completeJoin()
+		}
+	case len(g.members) == 0:
+		if g.state == stateCompletingRebalance {
+			close(g.synced)
+		}
+		g.generation++
+		g.state = stateEmpty
+		g.leader = ""
+		g.protocol = ""
+	default:
+		g.prepareRebalance(c)
+	}
+}
+
+// memberIDs returns the ids of the members in order
+func (g *group) memberIDs() []string {
+	var ids []string
+	for id := range g.members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (c *Cluster) join(b *broker, clientID, clientHost string, req wire.JoinGroupRequest) wire.JoinGroupResponse {
+	c.mutex.Lock()
+
+	failed := func(code int16) wire.JoinGroupResponse {
+		c.mutex.Unlock()
+		return wire.JoinGroupResponse{ErrorCode: code, GenerationID: -1, MemberID: req.MemberID}
+	}
+
+	if c.coordinator(req.GroupID) != b {
+		return failed(errNotCoordinator)
+	}
+	if len(req.Protocols) == 0 {
+		return failed(errInconsistentProtocol)
+	}
+
+	g := c.group(req.GroupID)
+	if len(g.members) > 0 && g.protocolType != req.ProtocolType {
+		return failed(errInconsistentProtocol)
+	}
+
+	var protocols []string
+	for _, p := range req.Protocols {
+		protocols = append(protocols, p.Name)
+	}
+
+	m, ok := g.members[req.MemberID]
+	switch {
+	case req.MemberID == "":
+		m = &member{id: c.nextMemberID(clientID)}
+	case !ok:
+		return failed(errUnknownMemberID)
+	}
+	m.clientID = clientID
+	m.clientHost = clientHost
+	m.protocols = protocols
+	m.metadata = req.Protocols[0].Metadata
+	m.sessionTimeout = time.Duration(req.SessionTimeout) * time.Millisecond
+	m.rebalanceTimeout = time.Duration(req.RebalanceTimeout) * time.Millisecond
+	m.lastHeartbeat = time.Now()
+
+	// the group uses the first protocol listed by its first member
+	if len(g.members) == 0 {
+		g.protocolType = req.ProtocolType
+		g.protocol = protocols[0]
+	} else if !m.supports(g.protocol) {
+		return failed(errInconsistentProtocol)
+	}
+	for _, p := range req.Protocols {
+		if p.Name == g.protocol {
+			m.metadata = p.Metadata
+		}
+	}
+
+	g.members[m.id] = m
+	if g.state != statePreparingRebalance {
+		g.prepareRebalance(c)
+	}
+	m.joined = true
+	if g.allJoined() {
+		g.completeJoin()
+	}
+	rebalance := g.rebalance
+	c.mutex.Unlock()
+
+	<-rebalance
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := g.members[m.id]; !ok || c.groups[g.id] != g {
+		return wire.JoinGroupResponse{ErrorCode: errUnknownMemberID, GenerationID: -1}
+	}
+
+	resp := wire.JoinGroupResponse{
+		GenerationID: g.generation,
+		Protocol:     g.protocol,
+		LeaderID:     g.leader,
+		MemberID:     m.id,
+	}
+	if m.id == g.leader {
+		for _, id := range g.memberIDs() {
+			resp.Members = append(resp.Members, wire.JoinGroupMember{
+				MemberID: id,
+				Metadata: g.members[id].metadata,
+			})
+		}
+	}
+	return resp
+}
+
+func (c *Cluster) sync(b *broker, req wire.SyncGroupRequest) wire.SyncGroupResponse {
+	c.mutex.Lock()
+
+	failed := func(code int16) wire.SyncGroupResponse {
+		c.mutex.Unlock()
+		return wire.SyncGroupResponse{ErrorCode: code, Assignment: []byte{}}
+	}
+
+	if c.coordinator(req.GroupID) != b {
+		return failed(errNotCoordinator)
+	}
+	g, ok := c.groups[req.GroupID]
+	if !ok {
+		return failed(errUnknownMemberID)
+	}
+	m, ok := g.members[req.MemberID]
+	if !ok {
+		return failed(errUnknownMemberID)
+	}
+	if req.GenerationID != g.generation {
+		return failed(errIllegalGeneration)
+	}
+	if g.state == statePreparingRebalance {
+		return failed(errRebalanceInProgress)
+	}
+
+	m.lastHeartbeat = time.Now()
+	if g.state == stateCompletingRebalance && m.id == g.leader {
+		for _, item := range g.members {
+			item.assignment = []byte{}
+		}
+		for _, assignment := range req.Assignments {
+			if item, ok := g.members[assignment.MemberID]; ok {
+				item.assignment = assignment.Assignment
+			}
+		}
+		g.state = stateStable
+		close(g.synced)
+	}
+	synced, generation := g.synced, g.generation
+	c.mutex.Unlock()
+
+	<-synced
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if g.state != stateStable || g.generation != generation {
+		return wire.SyncGroupResponse{ErrorCode: errRebalanceInProgress, Assignment: []byte{}}
+	}
+	if _, ok := g.members[m.id]; !ok {
+		return wire.SyncGroupResponse{ErrorCode: errUnknownMemberID, Assignment: []byte{}}
+	}
+	return wire.SyncGroupResponse{Assignment: m.assignment}
+}
+
+func (c *Cluster) heartbeat(b *broker, req wire.HeartbeatRequest) wire.ErrorResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.coordinator(req.GroupID) != b {
+		return wire.ErrorResponse{ErrorCode: errNotCoordinator}
+	}
+	g, ok := c.groups[req.GroupID]
+	if !ok {
+		return wire.ErrorResponse{ErrorCode: errUnknownMemberID}
+	}
+	m, ok := g.members[req.MemberID]
+	if !ok {
+		return wire.ErrorResponse{ErrorCode: errUnknownMemberID}
+	}
+	if req.GenerationID != g.generation {
+		return wire.ErrorResponse{ErrorCode: errIllegalGeneration}
+	}
+
+	m.lastHeartbeat = time.Now()
+	if g.state == statePreparingRebalance {
+		return wire.ErrorResponse{ErrorCode: errRebalanceInProgress}
+	}
+	return wire.ErrorResponse{}
+}
+
+func (c *Cluster) leave(b *broker, req wire.LeaveGroupRequest) wire.ErrorResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.coordinator(req.GroupID) != b {
+		return wire.ErrorResponse{ErrorCode: errNotCoordinator}
+	}
+	g, ok := c.groups[req.GroupID]
+	if !ok {
+		return wire.ErrorResponse{ErrorCode: errUnknownMemberID}
+	}
+	if _, ok := g.members[req.MemberID]; !ok {
+		return wire.ErrorResponse{ErrorCode: errUnknownMemberID}
+	}
+
+	delete(g.members, req.MemberID)
+	g.membersChanged(c)
+	return wire.ErrorResponse{}
+}
+
+func (c *Cluster) describeGroups(b *broker, req wire.DescribeGroupsRequest) wire.DescribeGroupsResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var resp wire.DescribeGroupsResponse
+	for _, groupID := range req.GroupIDs {
+		g, ok := c.groups[groupID]
+		switch {
+		case c.coordinator(groupID) != b:
+			resp.Groups = append(resp.Groups, wire.DescribedGroup{ErrorCode: errNotCoordinator, GroupID: groupID})
+			continue
+		case !ok:
+			resp.Groups = append(resp.Groups, wire.DescribedGroup{GroupID: groupID, State: stateDead})
+			continue
+		}
+
+		item := wire.DescribedGroup{
+			GroupID:      groupID,
+			State:        g.state,
+			ProtocolType: g.protocolType,
+		}
+		if g.state == stateStable {
+			item.Protocol = g.protocol
+		}
+		for _, id := range g.memberIDs() {
+			m := g.members[id]
+			item.Members = append(item.Members, wire.DescribedMember{
+				MemberID:   m.id,
+				ClientID:   m.clientID,
+				ClientHost: m.clientHost,
+				Metadata:   nonNil(m.metadata),
+				Assignment: nonNil(m.assignment),
+			})
+		}
+		resp.Groups = append(resp.Groups, item)
+	}
+	return resp
+}
+
+// listGroups lists the groups coordinated by the broker
+func (c *Cluster) listGroups(b *broker) wire.ListGroupsResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var groupIDs []string
+	for id := range c.groups {
+		if c.coordinator(id) == b {
+			groupIDs = append(groupIDs, id)
+		}
+	}
+	sort.Strings(groupIDs)
+
+	var resp wire.ListGroupsResponse
+	for _, id := range groupIDs {
+		resp.Groups = append(resp.Groups, wire.ListedGroup{
+			GroupID:      id,
+			ProtocolType: c.groups[id].protocolType,
+		})
+	}
+	return resp
+}
+
+// expireSessions removes members whose session has expired until the
+// cluster is closed.  Members waiting on a rebalance they have joined don't
+// heartbeat and so are exempt.
+func (c *Cluster) expireSessions() {
+	ticker := time.NewTicker(sessionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		c.mutex.Lock()
+		for _, g := range c.groups {
+			expired := false
+			for id, m := range g.members {
+				if g.state == statePreparingRebalance && m.joined {
+					continue
+				}
+				if now.Sub(m.lastHeartbeat) > m.sessionTimeout {
+					delete(g.members, id)
+					expired = true
+				}
+			}
+			if expired {
+				g.membersChanged(c)
+			}
+		}
+		c.mutex.Unlock()
+	}
+}
+
+func nonNil(data []byte) []byte {
+	if data == nil {
+		return []byte{}
+	}
+	return data
+}
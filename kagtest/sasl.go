@@ -0,0 +1,111 @@
+package kagtest
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/savaki/kag/internal/wire"
+)
+
+// Kafka error codes returned during SASL authentication
+const (
+	errUnsupportedSaslMechanism int16 = 33
+	errIllegalSaslState         int16 = 34
+	errSaslAuthenticationFailed int16 = 58
+)
+
+// RequirePlain requires new connections to authenticate with SASL/PLAIN using
+// the given credentials before sending any other request
+func (c *Cluster) RequirePlain(username, password string) {
+	want := []byte("\x00" + username + "\x00" + password)
+	c.requireSASL("PLAIN", func(auth []byte) bool {
+		return bytes.Equal(auth, want)
+	})
+}
+
+// RequireOAuthBearer requires new connections to authenticate with
+// SASL/OAUTHBEARER using the given token before sending any other request
+func (c *Cluster) RequireOAuthBearer(token string) {
+	want := []byte("auth=Bearer " + token)
+	c.requireSASL("OAUTHBEARER", func(auth []byte) bool {
+		for _, item := range bytes.Split(auth, []byte("\x01")) {
+			if bytes.Equal(item, want) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (c *Cluster) requireSASL(mechanism string, verify func(auth []byte) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.sasl == nil {
+		c.sasl = map[string]func([]byte) bool{}
+	}
+	c.sasl[mechanism] = verify
+}
+
+// saslRequired returns true if new connections must authenticate
+func (c *Cluster) saslRequired() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.sasl) > 0
+}
+
+// saslConn holds the authentication state of a single connection
+type saslConn struct {
+	mechanism     string
+	authenticated bool
+	failed        bool
+}
+
+// authenticate handles the requests of a connection that has yet to
+// authenticate; returns false if the request is not permitted
+func (c *Cluster) authenticate(s *saslConn, apiKey, apiVersion int16, d *wire.Decoder, e *wire.Encoder) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch {
+	case apiKey == wire.SaslHandshakeKey && apiVersion == 1:
+		mechanism := d.String()
+		var enabled []string
+		for name := range c.sasl {
+			enabled = append(enabled, name)
+		}
+		sort.Strings(enabled)
+
+		if _, ok := c.sasl[mechanism]; ok {
+			s.mechanism = mechanism
+			e.Int16(errNone)
+		} else {
+			e.Int16(errUnsupportedSaslMechanism)
+		}
+		e.StringArray(enabled)
+
+	case apiKey == wire.SaslAuthenticateKey && apiVersion == 0:
+		auth := d.Bytes()
+		verify, ok := c.sasl[s.mechanism]
+		switch {
+		case !ok:
+			e.Int16(errIllegalSaslState)
+			e.String("handshake required")
+			s.failed = true
+		case !verify(auth):
+			e.Int16(errSaslAuthenticationFailed)
+			e.String("invalid credentials")
+			s.failed = true
+		default:
+			e.Int16(errNone)
+			e.NullableString("")
+			s.authenticated = true
+		}
+		e.Bytes([]byte{})
+
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package kagtest
+
+import (
+	"sort"
+
+	"github.com/savaki/kag/internal/wire"
+)
+
+// clusterID is returned by Metadata version 2 on
+const clusterID = "kagtest"
+
+// SetApiVersion limits the versions of a request that the brokers accept and
+// advertise, e.g. to mimic an older release of kafka.  Versions outside of
+// those implemented by the brokers are ignored.  A max below min removes
+// support for the request.
+func (c *Cluster) SetApiVersion(apiKey, min, max int16) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if max < min {
+		delete(c.versions, apiKey)
+		return
+	}
+	c.versions[apiKey] = wire.ApiVersion{ApiKey: apiKey, MinVersion: min, MaxVersion: max}
+}
+
+// DisableApiVersions mimics brokers that predate the ApiVersions request,
+// i.e. kafka 0.9, which close connections that send it.  The brokers then
+// accept only the versions in wire.Legacy.
+func (c *Cluster) DisableApiVersions() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.versions = map[int16]wire.ApiVersion{}
+	for _, v := range wire.Legacy {
+		c.versions[v.ApiKey] = v
+	}
+}
+
+// SetRack sets the rack reported for the broker by Metadata version 1 on
+func (c *Cluster) SetRack(nodeID int32, rack string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.racks[nodeID] = rack
+}
+
+// defaultVersions returns the versions of each request the brokers implement
+func defaultVersions() map[int16]wire.ApiVersion {
+	versions := map[int16]wire.ApiVersion{}
+	for apiKey, v := range wire.Supported {
+		versions[apiKey] = v
+	}
+	versions[wire.SaslAuthenticateKey] = wire.ApiVersion{ApiKey: wire.SaslAuthenticateKey}
+	return versions
+}
+
+// supports returns true if the brokers both implement and accept the version
+// of the request
+func (c *Cluster) supports(apiKey, version int16) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	implemented, ok := wire.Supported[apiKey]
+	if !ok || version < implemented.MinVersion || version > implemented.MaxVersion {
+		return false
+	}
+	accepted, ok := c.versions[apiKey]
+	return ok && version >= accepted.MinVersion && version <= accepted.MaxVersion
+}
+
+func (c *Cluster) apiVersions() wire.ApiVersionsResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var resp wire.ApiVersionsResponse
+	for _, v := range c.versions {
+		resp.ApiVersions = append(resp.ApiVersions, v)
+	}
+	sort.Slice(resp.ApiVersions, func(i, j int) bool { return resp.ApiVersions[i].ApiKey < resp.ApiVersions[j].ApiKey })
+	return resp
+}
@@ -3,17 +3,17 @@ package kag
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
-	"reflect"
-	"sort"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/savaki/franz"
 )
 
+// retryDelay is the amount of time the Monitor waits before reconnecting
+// after a failed scrape
+const retryDelay = time.Minute
+
 type Observer interface {
 	Observe(groupID, topic string, partition int32, lag int64)
 }
@@ -24,11 +24,53 @@ func (fn ObserverFunc) Observe(groupID, topic string, partition int32, lag int64
 	fn(groupID, topic, partition, lag)
 }
 
+type stdoutObserver struct{}
+
+func (stdoutObserver) Observe(groupID, topic string, partition int32, lag int64) {
+	fmt.Printf("%v/%v/%v => %v\n", groupID, topic, partition, lag)
+}
+
+func (stdoutObserver) ObserveTimeLag(groupID, topic string, partition int32, lag time.Duration) {
+	fmt.Printf("timelag %v/%v/%v => %v\n", groupID, topic, partition, lag)
+}
+
+func (stdoutObserver) ObserveProduceRate(topic string, partition int32, rate float64) {
+	fmt.Printf("rate %v/%v => %.1f/s\n", topic, partition, rate)
+}
+
+func (stdoutObserver) ObserveForecast(groupID, topic string, partition int32, forecast Forecast) {
+	catchUp := forecast.CatchUp.String()
+	if forecast.Never {
+		catchUp = "never"
+	}
+	fmt.Printf("forecast %v/%v/%v => %.1f/s, catch up %v\n", groupID, topic, partition, forecast.ConsumeRate, catchUp)
+}
+
+func (stdoutObserver) ObserveClusterHealth(health ClusterHealth) {
+	fmt.Printf("cluster => %v under replicated, %v offline, %v non-preferred leader\n",
+		len(health.UnderReplicated), len(health.Offline), len(health.NonPreferredLeader))
+}
+
+func (stdoutObserver) ObserveStats(stats ScrapeStats) {
+	if stats.Err != nil {
+		fmt.Printf("kag => scrape failed after %v: %v\n", stats.Duration, stats.Err)
+		return
+	}
+	fmt.Printf("kag => scraped %v groups, %v partitions in %v\n", stats.Groups, stats.Partitions, stats.Duration)
+}
+
+func (stdoutObserver) ObserveRetention(groupID, topic string, partition int32, risk RetentionRisk) {
+	switch {
+	case risk.Lost:
+		fmt.Printf("retention %v/%v/%v => ALERT %v records lost to retention\n", groupID, topic, partition, -risk.Headroom)
+	case risk.Alert:
+		fmt.Printf("retention %v/%v/%v => ALERT %v until data loss\n", groupID, topic, partition, risk.TimeToLoss)
+	}
+}
+
 var (
-	Stdout Observer = ObserverFunc(func(groupID, topic string, partition int32, lag int64) {
-		fmt.Printf("%v/%v/%v => %v\n", groupID, topic, partition, lag)
-	})
-	Nop Observer = ObserverFunc(func(groupID, topic string, partition int32, lag int64) {})
+	Stdout Observer = stdoutObserver{}
+	Nop    Observer = ObserverFunc(func(groupID, topic string, partition int32, lag int64) {})
 )
 
 type Monitor struct {
@@ -36,127 +78,274 @@ type Monitor struct {
 	done         chan struct{}
 	err          error
 	config       Config
-	dialer       *franz.Dialer
+	client       *Client
+	reload       chan struct{}
 	topicOffsets chan topicOffsets
 	groupOffsets chan groupOffsets
+
+	mutex    sync.Mutex
+	snapshot *Snapshot
+	health   Health
+
+	// stats holds the performance of the most recent scrape and scrapes and
+	// errors the totals since the Monitor started
+	stats   ScrapeStats
+	scrapes int64
+	errors  int64
 }
 
-func (m *Monitor) debug(format string, args ...interface{}) {
-	if m.config.Debug == nil {
-		return
-	}
+// Snapshot returns the results of the most recent scrape or nil if no scrape
+// has completed yet
+func (m *Monitor) Snapshot() *Snapshot {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-	fmt.Fprintf(m.config.Debug, format, args...)
-	if !strings.HasSuffix(format, "\n") {
-		io.WriteString(m.config.Debug, "\n")
-	}
+	return m.snapshot
 }
 
-func (m *Monitor) connectAny(ctx context.Context) (*franz.Conn, error) {
-	for _, broker := range m.config.Brokers {
-		if conn, err := m.dialer.DialContext(ctx, "tcp", broker); err == nil {
-			m.debug("connected to broker, %v", broker)
-			return conn, nil
-		}
-	}
+func (m *Monitor) setSnapshot(snapshot *Snapshot) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.snapshot = snapshot
+	m.health.LastScrape = snapshot.Time
+	m.health.LastProgress = snapshot.Time
+	m.health.LastError = nil
+	m.health.Standby = false
+}
+
+// standby records that the Monitor skipped a scrape because another instance
+// leads the election group
+func (m *Monitor) standby() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-	return nil, errors.Errorf("unable to connect to any broker")
+	m.health.LastProgress = time.Now()
+	m.health.Standby = true
 }
 
 type ScanOut struct {
 	Offsets map[string]map[int32]int64
 }
 
+// errReconnect is returned by monitor when a change of configuration requires
+// new connections
+var errReconnect = errors.New("connection settings changed")
+
 func (m *Monitor) monitor(ctx context.Context) error {
-	conn, err := m.connectAny(ctx)
+	client, config := m.current()
+	started := time.Now()
+	s, err := client.openSession(ctx)
 	if err != nil {
+		m.observeStats(config, makeScrapeStats(started, nil, nil, err))
 		return err
 	}
-	defer conn.Close()
-
-	metadata, err := conn.MetadataV0(franz.MetadataRequestV0{})
-	if err != nil {
-		return errors.Wrapf(err, "unable to retrieve initial metadata")
+	defer s.Close()
+
+	var changed <-chan struct{}
+	var member *groupMember
+	switch {
+	case config.ShardGroup != "":
+		member = newGroupMember(client, config.ShardGroup, "shard", config.SessionTimeout)
+		member.assign = assignShards
+	case config.ElectionGroup != "":
+		member = newGroupMember(client, config.ElectionGroup, "election", config.SessionTimeout)
 	}
-
-	brokers := brokerArray{}
-	defer brokers.Close()
-
-	for _, broker := range metadata.Brokers {
-		addr := fmt.Sprintf("%v:%v", broker.Host, broker.Port)
-		m.debug("starting monitoring for broker, %v", addr)
-		conn, err := m.dialer.DialContext(ctx, "tcp", addr)
-		if err != nil {
-			return errors.Wrapf(err, "unable to connect to broker, %v", addr)
-		}
-		brokers = append(brokers, newBroker(broker.NodeID, conn, m.config.Debug))
+	if member != nil {
+		changed = member.changed
+
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		defer func() { cancel(); <-done }()
+		go func() {
+			defer close(done)
+			member.run(ctx)
+		}()
 	}
 
-	brokerList := metadata.Brokers
-	sort.Slice(brokerList, func(i, j int) bool { return brokerList[i].NodeID < brokerList[j].NodeID })
-
-	ticker := time.NewTicker(m.config.Interval)
-	defer ticker.Stop()
+	ticker := time.NewTicker(config.Interval)
+	defer func() { ticker.Stop() }()
 
 	for {
-		m.debug("retrieving metadata")
-		metadata, err := conn.MetadataV0(franz.MetadataRequestV0{})
-		if err != nil {
-			return errors.Wrapf(err, "unable to retrieve metadata")
+		if !activate(s, member, config) {
+			client.debug("standing by for group, %v", member.groupID)
+			m.standby()
+		} else if err := m.scrape(ctx, s, config); err != nil {
+			return err
 		}
 
-		found := metadata.Brokers
-		sort.Slice(found, func(i, j int) bool { return found[i].NodeID < found[j].NodeID })
-		if !reflect.DeepEqual(brokerList, found) {
-			return errors.Errorf("detected change in broker list")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-changed:
+		case <-m.reload:
+			next, nextConfig := m.current()
+			if connectionChanged(config, nextConfig) {
+				return errReconnect
+			}
+			client, config = next, nextConfig
+			s.client = client
+
+			ticker.Stop()
+			ticker = time.NewTicker(config.Interval)
 		}
+	}
+}
 
-		m.debug("fetching consumer group offsets")
-		groupOffsets, err := brokers.fetchGroupOffsets(ctx, metadata)
-		if err != nil {
-			return err
-		}
+// scrape scrapes the cluster then records and publishes the results
+func (m *Monitor) scrape(ctx context.Context, s *session, config Config) error {
+	started := time.Now()
+	snapshot, err := s.scrape(ctx)
+	if err != nil {
+		m.observeStats(config, makeScrapeStats(started, nil, s.requestStats(), err))
+		return err
+	}
+	previous := m.Snapshot()
+	if previous == nil {
+		previous = m.lastRecorded()
+	}
+	applyRates(previous, snapshot)
+	applyRetention(previous, snapshot, config.retentionAlert)
+	m.setSnapshot(snapshot)
 
-		m.debug("fetching newest topic offsets")
-		newest, err := brokers.fetchTopicOffsets(ctx, metadata, -1)
-		if err != nil {
-			return err
+	if config.History != nil {
+		if err := config.History.Append(snapshot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
 		}
+	}
 
-		m.debug("fetching oldest topic offsets")
-		oldest, err := brokers.fetchTopicOffsets(ctx, metadata, -2)
-		if err != nil {
-			return err
-		}
+	s.client.debug("publishing observations")
+	publish(config.Observer, snapshot, s.shard)
+	m.observeStats(config, makeScrapeStats(started, snapshot, s.requestStats(), nil))
+	return nil
+}
 
-		m.debug("removing topic partitions with zero records")
-		removeZeroEntries(newest, oldest)
+// publish publishes the observations of the snapshot that belong to the
+// shard, or all of them when shard is nil, to the observer
+func publish(observer Observer, snapshot *Snapshot, shard *shard) {
+	publishLag(observer, snapshot)
+	publishForecasts(observer, snapshot)
+	publishRetention(observer, snapshot)
+	if shard.publishesTopics() {
+		publishProduceRates(observer, snapshot)
+	}
+	if shard.leads() {
+		publishClusterHealth(observer, snapshot)
+	}
+	if v, ok := observer.(SnapshotObserver); ok {
+		v.ObserveSnapshot(snapshot)
+	}
+}
 
-		m.debug("publishing observations")
-		observeLag(m.config.Observer, newest, groupOffsets)
+// activate returns true if the Monitor should scrape given its membership of
+// the coordination group, if any, and sets the share of the cluster scraped
+// when sharding
+func activate(s *session, member *groupMember, config Config) bool {
+	if member == nil {
+		return true
+	}
 
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-ticker.C:
-		}
+	state := member.membership()
+	if !state.valid(time.Now()) {
+		return false
+	}
+	if config.ShardGroup == "" {
+		return state.Leader
+	}
+
+	shard, err := decodeShard(state.Assignment, config.ShardTopics)
+	if err != nil {
+		s.client.debug("group %v: %v", config.ShardGroup, err)
+		return false
+	}
+	if s.shard == nil || *s.shard != *shard {
+		s.client.debug("scraping shard %v of %v", shard.Index+1, shard.Count)
+	}
+	s.shard = shard
+	return true
+}
+
+// current returns the Client and Config most recently provided to the Monitor
+func (m *Monitor) current() (*Client, Config) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.client, m.config
+}
+
+// SetConfig replaces the configuration of a running Monitor.  Changes to the
+// settings used to connect, e.g. Brokers or TLS, cause the Monitor to
+// reconnect; all other changes take effect immediately.  Either way, the most
+// recent Snapshot and the Health of the Monitor are retained.
+func (m *Monitor) SetConfig(config Config) error {
+	if len(config.Brokers) == 0 && config.Discovery == nil {
+		return errors.Errorf("Brokers or Discovery not set")
 	}
 
+	client := NewClient(config)
+
+	m.mutex.Lock()
+	m.client = client
+	m.config = client.config
+	m.health.Interval = client.config.Interval
+	m.mutex.Unlock()
+
+	select {
+	case m.reload <- struct{}{}:
+	default:
+	}
 	return nil
 }
 
+// maxSeedAge limits, in polling intervals, how old the most recent sample in
+// History may be and still be used as the previous scrape
+const maxSeedAge = 10
+
+// lastRecorded returns the most recent scrape recorded in History or nil if
+// there is none or it is too old to derive rates from
+func (m *Monitor) lastRecorded() *Snapshot {
+	_, config := m.current()
+	if config.History == nil {
+		return nil
+	}
+
+	sample, ok, err := config.History.Latest()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil
+	}
+	if !ok || time.Since(sample.Time) > maxSeedAge*config.Interval {
+		return nil
+	}
+	return sample.Snapshot()
+}
+
+// History returns the History the Monitor records to or nil if none was
+// configured
+func (m *Monitor) History() *History {
+	_, config := m.current()
+	return config.History
+}
+
 func (m *Monitor) run(ctx context.Context) {
 	defer close(m.done)
 
 	for {
-		if err := m.monitor(ctx); err != nil {
+		err := m.monitor(ctx)
+		if err == errReconnect {
+			continue
+		}
+		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
+			m.progress(err)
 		}
 
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(time.Minute):
+		case <-time.After(retryDelay):
+		case <-m.reload:
 		}
 	}
 }
@@ -168,34 +357,23 @@ func (m *Monitor) Close() error {
 }
 
 func NewContext(ctx context.Context, config Config) *Monitor {
-	if len(config.Brokers) == 0 {
-		panic(errors.Errorf("Brokers not set"))
-	}
-	if config.Observer == nil {
-		config.Observer = Nop
-	}
-	if config.Interval == 0 {
-		config.Interval = DefaultInterval
+	if len(config.Brokers) == 0 && config.Discovery == nil {
+		panic(errors.Errorf("Brokers or Discovery not set"))
 	}
 
-	dialer := &franz.Dialer{
-		ClientID:      config.ClientID,
-		Timeout:       config.Timeout,
-		Deadline:      config.Deadline,
-		LocalAddr:     config.LocalAddr,
-		DualStack:     config.DualStack,
-		FallbackDelay: config.FallbackDelay,
-		KeepAlive:     config.KeepAlive,
-		Resolver:      config.Resolver,
-		TLS:           config.TLS,
-	}
+	client := NewClient(config)
 
 	ctx, cancel := context.WithCancel(ctx)
 	m := &Monitor{
 		cancel: cancel,
 		done:   make(chan struct{}),
-		dialer: dialer,
-		config: config,
+		reload: make(chan struct{}, 1),
+		client: client,
+		config: client.config,
+		health: Health{
+			Interval: client.config.Interval,
+			Started:  time.Now(),
+		},
 	}
 	go m.run(ctx)
 
@@ -0,0 +1,215 @@
+package kag
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/savaki/kag/kagtest"
+	"github.com/tj/assert"
+)
+
+// newCluster starts a fake cluster with a topic, records and a committed
+// consumer group
+func newCluster(t *testing.T) *kagtest.Cluster {
+	cluster, err := kagtest.NewCluster(3)
+	assert.Nil(t, err)
+
+	assert.Nil(t, cluster.CreateTopic("orders", 2, 2))
+	_, err = cluster.Produce("orders", 0, 10)
+	assert.Nil(t, err)
+	_, err = cluster.Produce("orders", 1, 5)
+	assert.Nil(t, err)
+	assert.Nil(t, cluster.Commit("billing", "orders", 0, 4))
+	assert.Nil(t, cluster.Commit("billing", "orders", 1, 5))
+
+	return cluster
+}
+
+func testConfig(cluster *kagtest.Cluster) Config {
+	return Config{
+		Brokers:  cluster.Addrs()[:1],
+		ClientID: "kag-test",
+		Cluster:  "test",
+		Interval: 50 * time.Millisecond,
+		Timeout:  time.Second,
+	}
+}
+
+func TestClientScrape(t *testing.T) {
+	cluster := newCluster(t)
+	defer cluster.Close()
+
+	config := testConfig(cluster)
+	config.TimeLag = true
+	client := NewClient(config)
+
+	snapshot, err := client.Scrape(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, snapshot.Brokers, 3)
+	assert.Equal(t, map[string]map[int32]int64{"orders": {0: 10, 1: 5}}, snapshot.Newest)
+	assert.Equal(t, map[string]map[int32]int64{"orders": {0: 0, 1: 0}}, snapshot.Oldest)
+	assert.Equal(t, map[string]map[string]map[int32]int64{"billing": {"orders": {0: 6, 1: 0}}}, snapshot.Lag)
+	assert.Contains(t, snapshot.TimeLag["billing"]["orders"], int32(0))
+	assert.Empty(t, snapshot.ClusterHealth().Offline)
+
+	t.Run("kill broker", func(t *testing.T) {
+		assert.Nil(t, cluster.KillBroker(1))
+		defer cluster.RestartBroker(1)
+
+		client := NewClient(Config{Brokers: cluster.Addrs(), ClientID: "kag-test", Timeout: time.Second})
+		snapshot, err := client.Scrape(context.Background())
+		assert.Nil(t, err)
+		assert.Len(t, snapshot.Brokers, 2)
+
+		health := snapshot.ClusterHealth()
+		assert.Empty(t, health.Offline)
+		assert.Equal(t, []TopicPartition{{Topic: "orders", Partition: 0}}, health.UnderReplicated)
+		assert.Equal(t, map[string]map[int32]int64{"orders": {0: 10, 1: 5}}, snapshot.Newest)
+	})
+
+	t.Run("describe group", func(t *testing.T) {
+		description, err := client.DescribeGroup(context.Background(), "billing")
+		assert.Nil(t, err)
+		assert.Equal(t, "Empty", description.State)
+		assert.Empty(t, description.Members)
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		err := client.CommitOffsets(context.Background(), "billing", map[string]map[int32]int64{"orders": {0: 8}})
+		assert.Nil(t, err)
+
+		offset, ok := cluster.Committed("billing", "orders", 0)
+		assert.True(t, ok)
+		assert.EqualValues(t, 8, offset)
+	})
+}
+
+func TestMonitor(t *testing.T) {
+	cluster := newCluster(t)
+	defer cluster.Close()
+
+	var mutex sync.Mutex
+	lag := map[int32]int64{}
+	config := testConfig(cluster)
+	config.Observer = ObserverFunc(func(groupID, topic string, partition int32, v int64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		lag[partition] = v
+	})
+
+	monitor := New(config)
+	defer monitor.Close()
+
+	observed := func() map[int32]int64 {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		v := map[int32]int64{}
+		for partition, item := range lag {
+			v[partition] = item
+		}
+		return v
+	}
+	waitFor(t, func() bool { return observed()[0] == 6 })
+
+	// lag follows produced records and committed offsets
+	_, err := cluster.Produce("orders", 1, 3)
+	assert.Nil(t, err)
+	assert.Nil(t, cluster.Commit("billing", "orders", 0, 10))
+	waitFor(t, func() bool {
+		v := observed()
+		return v[0] == 0 && v[1] == 3
+	})
+
+	assert.Nil(t, monitor.Health().Ready(time.Now()))
+}
+
+func TestMonitorElection(t *testing.T) {
+	cluster := newCluster(t)
+	defer cluster.Close()
+
+	config := testConfig(cluster)
+	config.Observer = Nop
+	config.ElectionGroup = "kag-election"
+	config.SessionTimeout = 300 * time.Millisecond
+
+	a, b := New(config), New(config)
+	defer b.Close()
+
+	active := func(monitors ...*Monitor) []*Monitor {
+		var v []*Monitor
+		for _, m := range monitors {
+			if health := m.Health(); !health.Standby && !health.LastScrape.IsZero() {
+				v = append(v, m)
+			}
+		}
+		return v
+	}
+	waitFor(t, func() bool {
+		return len(active(a, b)) == 1 && a.Health().LastProgress.After(time.Time{}) && b.Health().LastProgress.After(time.Time{})
+	})
+
+	// exactly one instance scrapes while both are running
+	leader, standby := a, b
+	if active(a, b)[0] == b {
+		leader, standby = b, a
+	}
+	time.Sleep(5 * config.Interval)
+	assert.True(t, standby.Health().Standby)
+	assert.Nil(t, standby.Snapshot())
+
+	// the standby takes over once the leader leaves
+	leader.Close()
+	waitFor(t, func() bool { return len(active(standby)) == 1 })
+	assert.NotNil(t, standby.Snapshot())
+
+	description, err := NewClient(config).DescribeGroup(context.Background(), config.ElectionGroup)
+	assert.Nil(t, err)
+	assert.Len(t, description.Members, 1)
+}
+
+func TestMonitorShards(t *testing.T) {
+	cluster := newCluster(t)
+	defer cluster.Close()
+
+	for _, groupID := range []string{"a", "b", "c", "d", "e", "f"} {
+		assert.Nil(t, cluster.Commit(groupID, "orders", 0, 1))
+	}
+
+	config := testConfig(cluster)
+	config.Observer = Nop
+	config.ShardGroup = "kag-shards"
+	config.SessionTimeout = 300 * time.Millisecond
+
+	a, b := New(config), New(config)
+	defer a.Close()
+	defer b.Close()
+
+	// together the instances cover every group exactly once
+	waitFor(t, func() bool {
+		sa, sb := a.Snapshot(), b.Snapshot()
+		if sa == nil || sb == nil {
+			return false
+		}
+		for groupID := range sa.Lag {
+			if _, ok := sb.Lag[groupID]; ok {
+				return false
+			}
+		}
+		return len(sa.Lag)+len(sb.Lag) == 7 && len(sa.Lag) > 0 && len(sb.Lag) > 0
+	})
+}
+
+// waitFor polls fn until it returns true, failing the test after a few
+// seconds
+func waitFor(t *testing.T, fn func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
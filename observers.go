@@ -0,0 +1,93 @@
+package kag
+
+import (
+	"io"
+	"time"
+)
+
+// MultiObserver returns an Observer that publishes to each of observers.
+// The optional observer interfaces, e.g. RateObserver, are forwarded to the
+// observers that implement them.  Close closes each observer that implements
+// io.Closer.
+func MultiObserver(observers ...Observer) Observer {
+	if len(observers) == 1 {
+		return observers[0]
+	}
+	return multiObserver(observers)
+}
+
+type multiObserver []Observer
+
+func (m multiObserver) Observe(groupID, topic string, partition int32, lag int64) {
+	for _, o := range m {
+		o.Observe(groupID, topic, partition, lag)
+	}
+}
+
+func (m multiObserver) ObserveTimeLag(groupID, topic string, partition int32, lag time.Duration) {
+	for _, o := range m {
+		if v, ok := o.(TimeLagObserver); ok {
+			v.ObserveTimeLag(groupID, topic, partition, lag)
+		}
+	}
+}
+
+func (m multiObserver) ObserveProduceRate(topic string, partition int32, rate float64) {
+	for _, o := range m {
+		if v, ok := o.(RateObserver); ok {
+			v.ObserveProduceRate(topic, partition, rate)
+		}
+	}
+}
+
+func (m multiObserver) ObserveForecast(groupID, topic string, partition int32, forecast Forecast) {
+	for _, o := range m {
+		if v, ok := o.(RateObserver); ok {
+			v.ObserveForecast(groupID, topic, partition, forecast)
+		}
+	}
+}
+
+func (m multiObserver) ObserveClusterHealth(health ClusterHealth) {
+	for _, o := range m {
+		if v, ok := o.(ClusterObserver); ok {
+			v.ObserveClusterHealth(health)
+		}
+	}
+}
+
+func (m multiObserver) ObserveRetention(groupID, topic string, partition int32, risk RetentionRisk) {
+	for _, o := range m {
+		if v, ok := o.(RetentionObserver); ok {
+			v.ObserveRetention(groupID, topic, partition, risk)
+		}
+	}
+}
+
+func (m multiObserver) ObserveStats(stats ScrapeStats) {
+	for _, o := range m {
+		if v, ok := o.(StatsObserver); ok {
+			v.ObserveStats(stats)
+		}
+	}
+}
+
+func (m multiObserver) ObserveSnapshot(snapshot *Snapshot) {
+	for _, o := range m {
+		if v, ok := o.(SnapshotObserver); ok {
+			v.ObserveSnapshot(snapshot)
+		}
+	}
+}
+
+func (m multiObserver) Close() error {
+	var err error
+	for _, o := range m {
+		if v, ok := o.(io.Closer); ok {
+			if e := v.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
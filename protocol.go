@@ -0,0 +1,46 @@
+package kag
+
+import (
+	"github.com/savaki/kag/internal/wire"
+)
+
+// request sends a request using the highest version supported by both the
+// broker and kag
+func request(conn *wire.Conn, apiKey int16, req func(*wire.Encoder, int16), resp func(*wire.Decoder, int16)) error {
+	version, err := conn.Version(apiKey)
+	if err != nil {
+		return err
+	}
+
+	return conn.Do(apiKey, version,
+		func(e *wire.Encoder) {
+			if req != nil {
+				req(e, version)
+			}
+		},
+		func(d *wire.Decoder) error {
+			resp(d, version)
+			return nil
+		},
+	)
+}
+
+// do sends a request that kag implements in a single version.  Fails with
+// wire.UnsupportedVersionError if the broker does not support that version.
+func do(conn *wire.Conn, apiKey int16, req func(*wire.Encoder), resp func(*wire.Decoder) error) error {
+	version, err := conn.Version(apiKey)
+	if err != nil {
+		return err
+	}
+	return conn.Do(apiKey, version, req, resp)
+}
+
+// requestMetadata retrieves the brokers of the cluster and the partitions of
+// every topic
+func requestMetadata(conn *wire.Conn) (*wire.MetadataResponse, error) {
+	resp := &wire.MetadataResponse{}
+	if err := request(conn, wire.MetadataKey, wire.MetadataRequest{}.Encode, resp.Decode); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
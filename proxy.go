@@ -0,0 +1,260 @@
+package kag
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Proxy opens connections to the brokers through a proxy.  See Config.Proxy.
+type Proxy interface {
+	// Addr returns the address of the proxy kag connects to
+	Addr() string
+
+	// Connect asks the proxy, over conn, to connect to the broker at addr.
+	// Once Connect returns, conn carries the connection to the broker.
+	Connect(ctx context.Context, conn net.Conn, addr string) error
+}
+
+// SOCKS5Proxy returns a Proxy that connects through the SOCKS5 proxy at
+// addr.  Username and password are sent only if username is set.  Broker
+// host names are resolved by the proxy.
+func SOCKS5Proxy(addr, username, password string) Proxy {
+	return socks5Proxy{addr: addr, username: username, password: password}
+}
+
+// HTTPProxy returns a Proxy that connects through the HTTP proxy at addr
+// using CONNECT.  Basic authorization is sent only if username is set.
+func HTTPProxy(addr, username, password string) Proxy {
+	return httpProxy{addr: addr, username: username, password: password}
+}
+
+// ParseProxy returns the Proxy described by a url of the form
+// socks5://[user:password@]host:port or http://[user:password@]host:port
+func ParseProxy(rawurl string) (Proxy, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid proxy url")
+	}
+
+	var username, password string
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return SOCKS5Proxy(proxyAddr(u, "1080"), username, password), nil
+	case "http":
+		return HTTPProxy(proxyAddr(u, "80"), username, password), nil
+	default:
+		return nil, errors.Errorf("unsupported proxy scheme, %v.  valid schemes socks5, http", u.Scheme)
+	}
+}
+
+// proxyAddr returns the host and port of u, defaulting the port if needed
+func proxyAddr(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// proxyDeadline applies the deadline of ctx, if any, to conn while
+// connecting through the proxy and returns a func that clears it
+func proxyDeadline(ctx context.Context, conn net.Conn) func() {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return func() { conn.SetDeadline(time.Time{}) }
+}
+
+type socks5Proxy struct {
+	addr     string
+	username string
+	password string
+}
+
+// SOCKS5 constants, see RFC 1928 and RFC 1929
+const (
+	socks5Version      = 5
+	socks5NoAuth       = 0
+	socks5UserPassword = 2
+	socks5NoAcceptable = 0xff
+	socks5Connect      = 1
+	socks5IPv4         = 1
+	socks5Domain       = 3
+	socks5IPv6         = 4
+)
+
+// socks5Errors holds the messages of the SOCKS5 reply codes
+var socks5Errors = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "ttl expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+func (p socks5Proxy) Addr() string {
+	return p.addr
+}
+
+func (p socks5Proxy) Connect(ctx context.Context, conn net.Conn, addr string) error {
+	defer proxyDeadline(ctx, conn)()
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return errors.Errorf("invalid port, %v", portStr)
+	}
+
+	method := byte(socks5NoAuth)
+	if p.username != "" {
+		method = socks5UserPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return errors.Wrapf(err, "unable to write to socks5 proxy")
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return errors.Wrapf(err, "unable to read from socks5 proxy")
+	}
+	if buf[0] != socks5Version {
+		return errors.Errorf("unexpected socks version, %v", buf[0])
+	}
+	if buf[1] == socks5NoAcceptable || buf[1] != method {
+		return errors.Errorf("socks5 proxy rejected authentication method")
+	}
+
+	if method == socks5UserPassword {
+		if len(p.username) > 255 || len(p.password) > 255 {
+			return errors.Errorf("socks5 username and password must be at most 255 bytes")
+		}
+		req := []byte{1, byte(len(p.username))}
+		req = append(req, p.username...)
+		req = append(req, byte(len(p.password)))
+		req = append(req, p.password...)
+		if _, err := conn.Write(req); err != nil {
+			return errors.Wrapf(err, "unable to write to socks5 proxy")
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return errors.Wrapf(err, "unable to read from socks5 proxy")
+		}
+		if buf[1] != 0 {
+			return errors.Errorf("socks5 proxy rejected username and password")
+		}
+	}
+
+	req := []byte{socks5Version, socks5Connect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.Errorf("host name too long, %v", host)
+		}
+		req = append(req, socks5Domain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5IPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5IPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return errors.Wrapf(err, "unable to write to socks5 proxy")
+	}
+
+	// reply holds version, reply code, reserved, and address type
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return errors.Wrapf(err, "unable to read from socks5 proxy")
+	}
+	if reply[1] != 0 {
+		msg, ok := socks5Errors[reply[1]]
+		if !ok {
+			msg = "error " + strconv.Itoa(int(reply[1]))
+		}
+		return errors.Errorf("socks5 proxy unable to connect to %v: %v", addr, msg)
+	}
+
+	// the bound address and port are not used
+	var n int
+	switch reply[3] {
+	case socks5IPv4:
+		n = net.IPv4len
+	case socks5IPv6:
+		n = net.IPv6len
+	case socks5Domain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return errors.Wrapf(err, "unable to read from socks5 proxy")
+		}
+		n = int(buf[0])
+	default:
+		return errors.Errorf("unexpected socks5 address type, %v", reply[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, n+2)); err != nil {
+		return errors.Wrapf(err, "unable to read from socks5 proxy")
+	}
+	return nil
+}
+
+type httpProxy struct {
+	addr     string
+	username string
+	password string
+}
+
+func (p httpProxy) Addr() string {
+	return p.addr
+}
+
+func (p httpProxy) Connect(ctx context.Context, conn net.Conn, addr string) error {
+	defer proxyDeadline(ctx, conn)()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if p.username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(p.username + ":" + p.password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return errors.Wrapf(err, "unable to write to http proxy")
+	}
+
+	r := bufio.NewReader(conn)
+	// the body of a successful CONNECT is the tunnel and must not be read
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read from http proxy")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("http proxy unable to connect to %v: %v", addr, resp.Status)
+	}
+
+	// brokers never speak first, so anything buffered would be lost
+	if r.Buffered() > 0 {
+		return errors.Errorf("http proxy sent unexpected data after connecting to %v", addr)
+	}
+	return nil
+}
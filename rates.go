@@ -0,0 +1,130 @@
+package kag
+
+import (
+	"time"
+)
+
+// Forecast describes how quickly a consumer group is working through a
+// single topic partition
+type Forecast struct {
+	// ProduceRate holds the records per second appended to the partition
+	ProduceRate float64
+
+	// ConsumeRate holds the records per second committed by the group
+	ConsumeRate float64
+
+	// CatchUp holds the time until the group's lag reaches zero if both rates
+	// hold steady; zero if the group has no lag
+	CatchUp time.Duration
+
+	// Never is true when the group has lag and is consuming no faster than
+	// the partition is being produced to
+	Never bool
+}
+
+// RateObserver may optionally be implemented by an Observer to receive the
+// produce and consume rates computed between successive scrapes
+type RateObserver interface {
+	ObserveProduceRate(topic string, partition int32, rate float64)
+	ObserveForecast(groupID, topic string, partition int32, forecast Forecast)
+}
+
+// applyRates computes the produce rate of each partition and the forecast of
+// each group partition from the change in offsets since the previous
+// snapshot.  Partitions whose offsets moved backwards, e.g. because a topic
+// was recreated or a group was reset, are skipped.
+func applyRates(previous, current *Snapshot) {
+	if previous == nil {
+		return
+	}
+	elapsed := current.Time.Sub(previous.Time).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	current.ProduceRate = map[string]map[int32]float64{}
+	for topic, partitions := range current.Newest {
+		for partition, newest := range partitions {
+			before, ok := previous.Newest[topic][partition]
+			if !ok || before < 0 || newest < before {
+				continue
+			}
+			if current.ProduceRate[topic] == nil {
+				current.ProduceRate[topic] = map[int32]float64{}
+			}
+			current.ProduceRate[topic][partition] = float64(newest-before) / elapsed
+		}
+	}
+
+	current.Forecast = map[string]map[string]map[int32]Forecast{}
+	for groupID, topics := range current.Lag {
+		for topic, partitions := range topics {
+			for partition, lag := range partitions {
+				produceRate, ok := current.ProduceRate[topic][partition]
+				if !ok {
+					continue
+				}
+				committed, ok := current.Groups[groupID][topic][partition]
+				if !ok || committed < 0 {
+					continue
+				}
+				before, ok := previous.Groups[groupID][topic][partition]
+				if !ok || committed < before {
+					continue
+				}
+
+				forecast := Forecast{
+					ProduceRate: produceRate,
+					ConsumeRate: float64(committed-before) / elapsed,
+				}
+				if lag > 0 {
+					if net := forecast.ConsumeRate - forecast.ProduceRate; net > 0 {
+						forecast.CatchUp = time.Duration(float64(lag) / net * float64(time.Second))
+					} else {
+						forecast.Never = true
+					}
+				}
+
+				if current.Forecast[groupID] == nil {
+					current.Forecast[groupID] = map[string]map[int32]Forecast{}
+				}
+				if current.Forecast[groupID][topic] == nil {
+					current.Forecast[groupID][topic] = map[int32]Forecast{}
+				}
+				current.Forecast[groupID][topic][partition] = forecast
+			}
+		}
+	}
+}
+
+// publishProduceRates publishes the produce rates recorded in the snapshot
+// to the observer if it implements RateObserver
+func publishProduceRates(observer Observer, snapshot *Snapshot) {
+	v, ok := observer.(RateObserver)
+	if !ok {
+		return
+	}
+
+	for topic, partitions := range snapshot.ProduceRate {
+		for partition, rate := range partitions {
+			v.ObserveProduceRate(topic, partition, rate)
+		}
+	}
+}
+
+// publishForecasts publishes the forecasts recorded in the snapshot to the
+// observer if it implements RateObserver
+func publishForecasts(observer Observer, snapshot *Snapshot) {
+	v, ok := observer.(RateObserver)
+	if !ok {
+		return
+	}
+
+	for groupID, topics := range snapshot.Forecast {
+		for topic, partitions := range topics {
+			for partition, forecast := range partitions {
+				v.ObserveForecast(groupID, topic, partition, forecast)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package kag
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+func TestApplyRates(t *testing.T) {
+	now := time.Now()
+	previous := &Snapshot{
+		Time:   now.Add(-10 * time.Second),
+		Newest: map[string]map[int32]int64{"t": {0: 100, 1: 100, 2: 500}},
+		Groups: map[string]map[string]map[int32]int64{
+			"fast":  {"t": {0: 50}},
+			"slow":  {"t": {1: 50}},
+			"reset": {"t": {2: 400}},
+			"new":   {"t": {0: -1, 1: 50}},
+		},
+	}
+	current := &Snapshot{
+		Time:   now,
+		Newest: map[string]map[int32]int64{"t": {0: 200, 1: 200, 2: 600}},
+		Groups: map[string]map[string]map[int32]int64{
+			"fast":  {"t": {0: 180}},
+			"slow":  {"t": {1: 100}},
+			"reset": {"t": {2: 0}},
+			"new":   {"t": {0: -1, 1: 150}},
+		},
+		Lag: map[string]map[string]map[int32]int64{
+			"fast":  {"t": {0: 20}},
+			"slow":  {"t": {1: 100}},
+			"reset": {"t": {2: 600}},
+			"new":   {"t": {0: 200, 1: 50}},
+		},
+	}
+
+	applyRates(previous, current)
+
+	assert.Equal(t, map[int32]float64{0: 10, 1: 10, 2: 10}, current.ProduceRate["t"])
+
+	fast := current.Forecast["fast"]["t"][0]
+	assert.Equal(t, float64(13), fast.ConsumeRate)
+	assert.False(t, fast.Never)
+	assert.InDelta(t, float64(20)/3, fast.CatchUp.Seconds(), 0.001)
+
+	slow := current.Forecast["slow"]["t"][1]
+	assert.Equal(t, float64(5), slow.ConsumeRate)
+	assert.True(t, slow.Never)
+
+	_, ok := current.Forecast["reset"]
+	assert.False(t, ok, "offsets that moved backwards are skipped")
+
+	_, ok = current.Forecast["new"]["t"][0]
+	assert.False(t, ok, "partitions never committed are skipped")
+	assert.Contains(t, current.Forecast["new"]["t"], int32(1))
+}
+
+func TestApplyRatesFirstScrape(t *testing.T) {
+	current := &Snapshot{Time: time.Now()}
+	applyRates(nil, current)
+	assert.Nil(t, current.ProduceRate)
+	assert.Nil(t, current.Forecast)
+}
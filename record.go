@@ -0,0 +1,398 @@
+package kag
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RecordingVersion identifies the format written by Recorder
+const RecordingVersion = 1
+
+// SnapshotObserver may optionally be implemented by an Observer to receive
+// each Snapshot once it has been published
+type SnapshotObserver interface {
+	ObserveSnapshot(snapshot *Snapshot)
+}
+
+// recordedScrape holds a single scrape of a recording.  Brokers and Topics
+// are only written when they differ from the previous scrape recorded by the
+// same Recorder.
+type recordedScrape struct {
+	Version int                            `json:"version"`
+	Cluster string                         `json:"cluster"`
+	Time    time.Time                      `json:"time"`
+	Brokers []BrokerMetadata               `json:"brokers,omitempty"`
+	Topics  map[string][]PartitionMetadata `json:"topics,omitempty"`
+
+	// ClusterID, Controller, and Internal are written with Brokers and
+	// Topics; Controller is a pointer as recordings made before it was
+	// added omit it
+	ClusterID  string          `json:"cluster_id,omitempty"`
+	Controller *int32          `json:"controller,omitempty"`
+	Internal   map[string]bool `json:"internal,omitempty"`
+
+	Newest  map[string]map[int32]int64                    `json:"newest,omitempty"`
+	Oldest  map[string]map[int32]int64                    `json:"oldest,omitempty"`
+	Groups  map[string]map[string]map[int32]int64         `json:"groups,omitempty"`
+	TimeLag map[string]map[string]map[int32]time.Duration `json:"time_lag,omitempty"`
+}
+
+// Recorder writes each scrape to a recording that may later be replayed with
+// Replayer.  Each scrape is written as a separate gzip member holding one
+// json line, so recordings may be appended to across restarts and a crash
+// loses at most the scrape being written; OpenRecorder discards that partial
+// scrape before appending.  Recorder implements
+// SnapshotObserver so that it may be combined with other observers using
+// MultiObserver.  Recorder is safe for concurrent use.
+type Recorder struct {
+	mutex    sync.Mutex
+	w        io.Writer
+	closer   io.Closer
+	buf      *bytes.Buffer
+	gz       *gzip.Writer
+	previous *Snapshot
+	err      error
+}
+
+// NewRecorder returns a Recorder that writes to w
+func NewRecorder(w io.Writer) *Recorder {
+	buf := bytes.NewBuffer(nil)
+	return &Recorder{
+		w:   w,
+		buf: buf,
+		gz:  gzip.NewWriter(buf),
+	}
+}
+
+// OpenRecorder returns a Recorder that appends to the named file, creating
+// it if needed.  A scrape left incomplete by a crash is truncated so that the
+// scrapes appended after it remain readable.
+func OpenRecorder(filename string) (*Recorder, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open recording, %v", filename)
+	}
+
+	n, err := completeMembers(f)
+	if err == nil {
+		err = f.Truncate(n)
+	}
+	if err == nil {
+		_, err = f.Seek(n, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "unable to open recording, %v", filename)
+	}
+
+	r := NewRecorder(f)
+	r.closer = f
+	return r, nil
+}
+
+// countingReader counts the bytes read through it and keeps the first error
+// other than io.EOF.  It implements io.ByteReader so that gzip reads no
+// further than the end of each member.
+type countingReader struct {
+	r   *bufio.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.keep(err)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	c.keep(err)
+	return b, err
+}
+
+func (c *countingReader) keep(err error) {
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+}
+
+// completeMembers returns the length of the longest prefix of r made up of
+// complete gzip members
+func completeMembers(r io.Reader) (int64, error) {
+	var (
+		cr    = &countingReader{r: bufio.NewReader(r)}
+		gz    = &gzip.Reader{}
+		valid int64
+	)
+	for {
+		err := gz.Reset(cr)
+		if err == nil {
+			gz.Multistream(false)
+			_, err = io.Copy(ioutil.Discard, gz)
+		}
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		if err != nil {
+			return valid, nil
+		}
+		valid = cr.n
+	}
+}
+
+// Observe implements Observer; lag is recorded by ObserveSnapshot
+func (r *Recorder) Observe(groupID, topic string, partition int32, lag int64) {}
+
+// ObserveSnapshot records the snapshot.  Errors are reported to stderr once.
+func (r *Recorder) ObserveSnapshot(snapshot *Snapshot) {
+	if err := r.Record(snapshot); err != nil {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		if r.err == nil {
+			r.err = err
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// Record writes the offsets, metadata, and time lag of the snapshot
+func (r *Recorder) Record(snapshot *Snapshot) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	line := recordedScrape{
+		Version: RecordingVersion,
+		Cluster: snapshot.Cluster,
+		Time:    snapshot.Time.UTC(),
+		Newest:  snapshot.Newest,
+		Oldest:  snapshot.Oldest,
+		Groups:  snapshot.Groups,
+		TimeLag: snapshot.TimeLag,
+	}
+	if r.metadataChanged(snapshot) {
+		controller := snapshot.Controller
+		line.Brokers = snapshot.Brokers
+		line.Topics = snapshot.Topics
+		line.ClusterID = snapshot.ClusterID
+		line.Controller = &controller
+		line.Internal = snapshot.Internal
+	}
+
+	r.buf.Reset()
+	r.gz.Reset(r.buf)
+	if err := json.NewEncoder(r.gz).Encode(line); err != nil {
+		return errors.Wrapf(err, "unable to encode recording")
+	}
+	if err := r.gz.Close(); err != nil {
+		return errors.Wrapf(err, "unable to encode recording")
+	}
+	if _, err := r.w.Write(r.buf.Bytes()); err != nil {
+		return errors.Wrapf(err, "unable to write recording")
+	}
+	r.previous = snapshot
+	return nil
+}
+
+// metadataChanged returns true if the metadata of the snapshot differs from
+// that of the previously recorded snapshot
+func (r *Recorder) metadataChanged(snapshot *Snapshot) bool {
+	p := r.previous
+	return p == nil ||
+		p.ClusterID != snapshot.ClusterID ||
+		p.Controller != snapshot.Controller ||
+		!reflect.DeepEqual(p.Brokers, snapshot.Brokers) ||
+		!reflect.DeepEqual(p.Topics, snapshot.Topics) ||
+		!reflect.DeepEqual(p.Internal, snapshot.Internal)
+}
+
+// Close closes the file opened by OpenRecorder
+func (r *Recorder) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// RecordingReader reads the snapshots of a recording
+type RecordingReader struct {
+	decoder    *json.Decoder
+	brokers    []BrokerMetadata
+	topics     map[string][]PartitionMetadata
+	clusterID  string
+	controller int32
+	internal   map[string]bool
+}
+
+// NewRecordingReader returns a reader of the recording in r
+func NewRecordingReader(r io.Reader) (*RecordingReader, error) {
+	gz, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read recording")
+	}
+	return &RecordingReader{
+		decoder:    json.NewDecoder(gz),
+		controller: -1,
+	}, nil
+}
+
+// Next returns the next snapshot of the recording with its lag recomputed
+// from the recorded offsets.  Returns io.EOF at the end of the recording;
+// a recording cut short, e.g. by a crash, ends at the last complete scrape.
+func (r *RecordingReader) Next() (*Snapshot, error) {
+	var line recordedScrape
+	if err := r.decoder.Decode(&line); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, errors.Wrapf(err, "unable to read recording")
+	}
+
+	if line.Version < 1 || line.Version > RecordingVersion {
+		return nil, errors.Errorf("unsupported recording version, %v", line.Version)
+	}
+	if line.Topics != nil || line.Brokers != nil || line.Controller != nil {
+		r.brokers, r.topics = line.Brokers, line.Topics
+		r.clusterID, r.internal = line.ClusterID, line.Internal
+		r.controller = -1
+		if line.Controller != nil {
+			r.controller = *line.Controller
+		}
+	}
+	if line.Newest == nil {
+		line.Newest = map[string]map[int32]int64{}
+	}
+	if line.Oldest == nil {
+		line.Oldest = map[string]map[int32]int64{}
+	}
+	if line.Groups == nil {
+		line.Groups = map[string]map[string]map[int32]int64{}
+	}
+
+	groups := groupOffsets{}
+	for groupID, topics := range line.Groups {
+		groups[groupID] = topics
+	}
+	return &Snapshot{
+		Cluster:    line.Cluster,
+		Time:       line.Time,
+		Brokers:    r.brokers,
+		ClusterID:  r.clusterID,
+		Controller: r.controller,
+		Topics:     r.topics,
+		Internal:   r.internal,
+		Newest:     line.Newest,
+		Oldest:     line.Oldest,
+		Groups:     line.Groups,
+		Lag:        makeLag(line.Newest, line.Oldest, groups),
+		TimeLag:    line.TimeLag,
+	}, nil
+}
+
+// Replayer publishes a recording to Config.Observer as the Monitor that made
+// it would have, deriving rates, forecasts, retention risk, and cluster
+// health from the recorded scrapes.  Config.RetentionAlert and Config.Groups
+// apply; connection settings are ignored.
+type Replayer struct {
+	config Config
+
+	mutex    sync.Mutex
+	snapshot *Snapshot
+	health   Health
+}
+
+// NewReplayer returns a Replayer publishing to config.Observer
+func NewReplayer(config Config) *Replayer {
+	config = applyDefaults(config)
+	return &Replayer{
+		config: config,
+		health: Health{
+			Interval: config.Interval,
+			Started:  time.Now(),
+		},
+	}
+}
+
+// Run replays the recording until it ends or the context is cancelled.  The
+// time between recorded scrapes is divided by speed, so 1 replays in real
+// time and 60 replays an hour a minute; zero replays as fast as possible.
+func (r *Replayer) Run(ctx context.Context, recording *RecordingReader, speed float64) error {
+	if speed < 0 {
+		return errors.Errorf("speed must not be negative")
+	}
+
+	var previous *Snapshot
+	for {
+		snapshot, err := recording.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if previous != nil && speed > 0 {
+			if delay := time.Duration(float64(snapshot.Time.Sub(previous.Time)) / speed); delay > 0 {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(delay):
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		// rates are only derived between scrapes of the same cluster
+		if previous != nil && previous.Cluster != snapshot.Cluster {
+			previous = nil
+		}
+		applyRates(previous, snapshot)
+		applyRetention(previous, snapshot, r.config.retentionAlert)
+
+		r.mutex.Lock()
+		r.snapshot = snapshot
+		r.health.LastScrape = time.Now()
+		r.health.LastProgress = r.health.LastScrape
+		r.mutex.Unlock()
+
+		publish(r.config.Observer, snapshot, nil)
+		previous = snapshot
+	}
+}
+
+// Snapshot returns the most recently replayed scrape or nil if none
+func (r *Replayer) Snapshot() *Snapshot {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.snapshot
+}
+
+// Health reports the progress of the replay in the manner of Monitor.Health
+func (r *Replayer) Health() Health {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.health
+}
@@ -0,0 +1,130 @@
+package kag
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+func TestRecording(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	topics := map[string][]PartitionMetadata{
+		"topic": {{Partition: 0, Leader: 1, Replicas: []int32{1, 2}, Isr: []int32{1, 2}}},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	recorder := NewRecorder(buf)
+	for i := int64(0); i < 3; i++ {
+		snapshot := makeHistorySnapshot(start.Add(time.Duration(i)*time.Minute), 100+60*i, 10)
+		snapshot.Cluster = "local"
+		snapshot.Brokers = []BrokerMetadata{{NodeID: 1}, {NodeID: 2}}
+		snapshot.Topics = topics
+		snapshot.Controller = 1
+		if i == 2 {
+			snapshot.Controller = 2
+			snapshot.Topics = map[string][]PartitionMetadata{
+				"topic": {{Partition: 0, Leader: 2, Replicas: []int32{1, 2}, Isr: []int32{2}}},
+			}
+		}
+		assert.Nil(t, recorder.Record(snapshot))
+	}
+	assert.Nil(t, recorder.Close())
+
+	// a scrape cut short by a crash is skipped
+	data := buf.Bytes()
+	data = append(data, data[:20]...)
+
+	t.Run("read", func(t *testing.T) {
+		r, err := NewRecordingReader(bytes.NewReader(data))
+		assert.Nil(t, err)
+
+		var snapshots []*Snapshot
+		for {
+			snapshot, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+			snapshots = append(snapshots, snapshot)
+		}
+
+		assert.Len(t, snapshots, 3)
+		assert.Equal(t, "local", snapshots[1].Cluster)
+		assert.True(t, start.Add(time.Minute).Equal(snapshots[1].Time))
+		assert.Equal(t, topics, snapshots[1].Topics)
+		assert.Len(t, snapshots[1].Brokers, 2)
+		assert.EqualValues(t, 160, snapshots[1].Newest["topic"][0])
+		assert.EqualValues(t, 10, snapshots[1].Lag["group"]["topic"][0])
+		assert.Equal(t, []int32{2}, snapshots[2].Topics["topic"][0].Isr)
+		assert.EqualValues(t, 1, snapshots[1].Controller)
+		assert.EqualValues(t, 2, snapshots[2].Controller)
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		r, err := NewRecordingReader(bytes.NewReader(data))
+		assert.Nil(t, err)
+
+		lag := lagRecorder{}
+		replayer := NewReplayer(Config{Observer: lag})
+		assert.Nil(t, replayer.Run(context.Background(), r, 0))
+
+		snapshot := replayer.Snapshot()
+		assert.EqualValues(t, 10, lag["group"]["topic"][0])
+		assert.Equal(t, 1.0, snapshot.ProduceRate["topic"][0])
+		assert.Equal(t, 1.0, snapshot.Forecast["group"]["topic"][0].ConsumeRate)
+		assert.Equal(t, []TopicPartition{{Topic: "topic", Partition: 0}}, snapshot.ClusterHealth().UnderReplicated)
+	})
+}
+
+func TestOpenRecorderAfterCrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kag-recording")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "recording.gz")
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	record := func(i int64) {
+		recorder, err := OpenRecorder(filename)
+		assert.Nil(t, err)
+		defer recorder.Close()
+
+		snapshot := makeHistorySnapshot(start.Add(time.Duration(i)*time.Minute), 100+60*i, 10)
+		assert.Nil(t, recorder.Record(snapshot))
+	}
+
+	record(0)
+	record(1)
+
+	// crash while writing the second scrape
+	info, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(filename, info.Size()-10))
+
+	record(2)
+	record(3)
+
+	f, err := os.Open(filename)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	r, err := NewRecordingReader(f)
+	assert.Nil(t, err)
+
+	var times []time.Time
+	for {
+		snapshot, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		times = append(times, snapshot.Time)
+	}
+	assert.Equal(t, []time.Time{start, start.Add(2 * time.Minute), start.Add(3 * time.Minute)}, times)
+}
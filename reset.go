@@ -0,0 +1,289 @@
+package kag
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/savaki/franz"
+	"github.com/savaki/kag/internal/wire"
+)
+
+// ResetStrategy identifies how the target offsets of a reset are computed
+type ResetStrategy int
+
+const (
+	// ResetToEarliest moves the group to the oldest available offset
+	ResetToEarliest ResetStrategy = iota + 1
+
+	// ResetToLatest moves the group to the newest offset
+	ResetToLatest
+
+	// ResetToDatetime moves the group to the first offset at or after ResetSpec.Time
+	ResetToDatetime
+
+	// ResetToOffset moves the group to ResetSpec.Offset
+	ResetToOffset
+
+	// ResetShiftBy moves the group ResetSpec.Shift offsets from the committed offset
+	ResetShiftBy
+
+	// ResetFromOffsets moves the group to the offsets in ResetSpec.Offsets
+	ResetFromOffsets
+)
+
+// ResetSpec describes how the offsets of a consumer group should be reset
+type ResetSpec struct {
+	Strategy ResetStrategy
+
+	// Time is used by ResetToDatetime
+	Time time.Time
+
+	// Offset is used by ResetToOffset
+	Offset int64
+
+	// Shift is used by ResetShiftBy; negative values rewind the group
+	Shift int64
+
+	// Offsets holds the target offset by topic and partition for ResetFromOffsets
+	Offsets map[string]map[int32]int64
+}
+
+// OffsetReset describes the reset of a single topic partition
+type OffsetReset struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+
+	// Current holds the committed offset or -1 if none has been committed
+	Current int64 `json:"current"`
+
+	// Target holds the offset the group will be moved to
+	Target int64 `json:"target"`
+
+	Oldest int64 `json:"oldest"`
+	Newest int64 `json:"newest"`
+
+	// Note describes any adjustment made to the requested target
+	Note string `json:"note,omitempty"`
+}
+
+// ResetPlan holds the offsets that will be committed for a consumer group
+type ResetPlan struct {
+	GroupID string        `json:"group"`
+	State   string        `json:"state"`
+	Members int           `json:"members"`
+	Resets  []OffsetReset `json:"resets"`
+}
+
+// Offsets returns the target offsets of the plan by topic and partition
+func (p *ResetPlan) Offsets() map[string]map[int32]int64 {
+	offsets := topicOffsets{}
+	for _, r := range p.Resets {
+		offsets.add(r.Topic, r.Partition, r.Target)
+	}
+	return offsets
+}
+
+// errActiveMembers is returned when attempting to modify the offsets of a
+// group that has active members
+func errActiveMembers(groupID string, members int) error {
+	return errors.Errorf("consumer group, %v, has %v active members; stop all consumers before changing offsets", groupID, members)
+}
+
+// PlanOffsetReset computes the offsets that a reset of the consumer group
+// would commit without committing them.  If topics is empty, every topic the
+// group has committed offsets for is reset.
+func (c *Client) PlanOffsetReset(ctx context.Context, groupID string, topics []string, spec ResetSpec) (*ResetPlan, error) {
+	description, err := c.DescribeGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := c.openSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	snapshot, err := s.scrape(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var atTime topicOffsets
+	if spec.Strategy == ResetToDatetime {
+		if atTime, err = s.offsetsAt(ctx, spec.Time.UnixNano()/int64(time.Millisecond)); err != nil {
+			return nil, err
+		}
+	}
+
+	return planReset(snapshot, atTime, description, topics, spec)
+}
+
+func planReset(snapshot *Snapshot, atTime topicOffsets, description GroupDescription, topics []string, spec ResetSpec) (*ResetPlan, error) {
+	groupID := description.GroupID
+	if len(topics) == 0 {
+		source := snapshot.Groups[groupID]
+		if spec.Strategy == ResetFromOffsets {
+			source = spec.Offsets
+		}
+		for topic := range source {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+	}
+	if len(topics) == 0 {
+		return nil, errors.Errorf("consumer group, %v, has no committed offsets; specify a topic", groupID)
+	}
+
+	plan := &ResetPlan{
+		GroupID: groupID,
+		State:   description.State,
+		Members: len(description.Members),
+	}
+	for _, topic := range topics {
+		partitions, ok := snapshot.Topics[topic]
+		if !ok {
+			return nil, errors.Errorf("topic not found, %v", topic)
+		}
+
+		if spec.Strategy == ResetFromOffsets {
+			for partition := range spec.Offsets[topic] {
+				if int(partition) >= len(partitions) || partition < 0 {
+					return nil, errors.Errorf("partition not found, %v/%v", topic, partition)
+				}
+			}
+		}
+
+		for _, p := range partitions {
+			current, ok := snapshot.Groups[groupID][topic][p.Partition]
+			if !ok {
+				current = -1
+			}
+
+			oldest, ok := snapshot.Oldest[topic][p.Partition]
+			if !ok {
+				return nil, errors.Errorf("oldest offset not found, %v/%v", topic, p.Partition)
+			}
+			newest, ok := snapshot.Newest[topic][p.Partition]
+			if !ok {
+				return nil, errors.Errorf("newest offset not found, %v/%v", topic, p.Partition)
+			}
+
+			r := OffsetReset{
+				Topic:     topic,
+				Partition: p.Partition,
+				Current:   current,
+				Oldest:    oldest,
+				Newest:    newest,
+			}
+
+			switch spec.Strategy {
+			case ResetToEarliest:
+				r.Target = r.Oldest
+			case ResetToLatest:
+				r.Target = r.Newest
+			case ResetToDatetime:
+				offset, ok := atTime[topic][p.Partition]
+				if !ok {
+					return nil, errors.Errorf("unable to find offset by time for %v/%v", topic, p.Partition)
+				}
+				if offset < 0 {
+					// no record at or after the time
+					offset = r.Newest
+				}
+				r.Target = offset
+			case ResetToOffset:
+				r.Target = spec.Offset
+			case ResetShiftBy:
+				if current < 0 {
+					return nil, errors.Errorf("unable to shift %v/%v; no committed offset", topic, p.Partition)
+				}
+				r.Target = current + spec.Shift
+			case ResetFromOffsets:
+				offset, ok := spec.Offsets[topic][p.Partition]
+				if !ok {
+					continue
+				}
+				r.Target = offset
+			default:
+				return nil, errors.Errorf("unknown reset strategy, %v", spec.Strategy)
+			}
+
+			if r.Target < r.Oldest {
+				r.Note = fmt.Sprintf("%v is before oldest offset; clamped", r.Target)
+				r.Target = r.Oldest
+			}
+			if r.Target > r.Newest {
+				r.Note = fmt.Sprintf("%v is after newest offset; clamped", r.Target)
+				r.Target = r.Newest
+			}
+
+			plan.Resets = append(plan.Resets, r)
+		}
+	}
+
+	return plan, nil
+}
+
+// ExecuteOffsetReset commits the offsets of the plan.  The reset is refused if
+// the group has gained active members since the plan was made.
+func (c *Client) ExecuteOffsetReset(ctx context.Context, plan *ResetPlan) error {
+	return c.CommitOffsets(ctx, plan.GroupID, plan.Offsets())
+}
+
+// CommitOffsets commits offsets on behalf of a consumer group with no active
+// members
+func (c *Client) CommitOffsets(ctx context.Context, groupID string, offsets map[string]map[int32]int64) error {
+	description, err := c.DescribeGroup(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	if n := len(description.Members); n > 0 {
+		return errActiveMembers(groupID, n)
+	}
+
+	addr := fmt.Sprintf("%v:%v", description.Coordinator.Host, description.Coordinator.Port)
+	conn, err := c.dial(ctx, addr)
+	if err != nil {
+		return errors.Wrapf(err, "unable to connect to coordinator, %v", addr)
+	}
+	defer conn.Close()
+
+	req := wire.OffsetCommitRequest{
+		GroupID:       groupID,
+		GenerationID:  -1,
+		RetentionTime: -1,
+	}
+	for topic, partitions := range offsets {
+		item := wire.OffsetCommitTopic{Topic: topic}
+		for partition, offset := range partitions {
+			item.Partitions = append(item.Partitions, wire.OffsetCommitPartition{
+				Partition: partition,
+				Offset:    offset,
+			})
+		}
+		req.Topics = append(req.Topics, item)
+	}
+
+	var resp wire.OffsetCommitResponse
+	err = do(conn, wire.OffsetCommitKey, req.Encode, func(d *wire.Decoder) error {
+		resp.Decode(d)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to commit offsets for consumer group, %v", groupID)
+	}
+
+	for _, t := range resp.Topics {
+		for _, p := range t.Partitions {
+			if p.ErrorCode != 0 {
+				return errors.Wrapf(franz.Error(p.ErrorCode), "unable to commit offset for %v/%v", t.Topic, p.Partition)
+			}
+		}
+	}
+
+	return nil
+}
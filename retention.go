@@ -0,0 +1,126 @@
+package kag
+
+import (
+	"time"
+)
+
+// RetentionRisk describes how close a lagging consumer group is to having
+// unconsumed records of a partition deleted by retention
+type RetentionRisk struct {
+	// Headroom holds the number of records between the oldest available
+	// offset and the committed offset; negative if records have already been
+	// lost
+	Headroom int64
+
+	// OldestRate holds the records per second that retention is removing
+	// from the partition i.e. the rate the oldest offset advances
+	OldestRate float64
+
+	// ConsumeRate holds the records per second committed by the group
+	ConsumeRate float64
+
+	// TimeToLoss holds the estimated time until retention deletes a record
+	// the group has not yet consumed; zero if records have already been lost
+	TimeToLoss time.Duration
+
+	// Never is true when the group is consuming at least as fast as retention
+	// is removing records
+	Never bool
+
+	// Lost is true when the committed offset is older than the oldest
+	// available offset
+	Lost bool
+
+	// Alert is true when records have been lost or TimeToLoss is less than
+	// Config.RetentionAlert
+	Alert bool
+}
+
+// RetentionObserver may optionally be implemented by an Observer to receive
+// the retention risk of each lagging group partition
+type RetentionObserver interface {
+	ObserveRetention(groupID, topic string, partition int32, risk RetentionRisk)
+}
+
+// applyRetention computes the retention risk of each lagging group partition
+// from the change in offsets since the previous snapshot.  window returns the
+// alert window of each group; zero disables alerting.
+func applyRetention(previous, current *Snapshot, window func(groupID string) time.Duration) {
+	if previous == nil {
+		return
+	}
+	elapsed := current.Time.Sub(previous.Time).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	current.Retention = map[string]map[string]map[int32]RetentionRisk{}
+	for groupID, topics := range current.Lag {
+		for topic, partitions := range topics {
+			for partition, lag := range partitions {
+				if lag <= 0 {
+					continue
+				}
+
+				oldest, ok := current.Oldest[topic][partition]
+				if !ok {
+					continue
+				}
+				before, ok := previous.Oldest[topic][partition]
+				if !ok || oldest < before {
+					continue
+				}
+				committed := current.Groups[groupID][topic][partition]
+				if committed < 0 {
+					// nothing committed so nothing to lose
+					continue
+				}
+				consumed, ok := previous.Groups[groupID][topic][partition]
+				if !ok || committed < consumed {
+					continue
+				}
+
+				risk := RetentionRisk{
+					Headroom:    committed - oldest,
+					OldestRate:  float64(oldest-before) / elapsed,
+					ConsumeRate: float64(committed-consumed) / elapsed,
+				}
+				switch shrink := risk.OldestRate - risk.ConsumeRate; {
+				case risk.Headroom < 0:
+					risk.Lost = true
+					risk.Alert = true
+				case shrink <= 0:
+					risk.Never = true
+				default:
+					risk.TimeToLoss = time.Duration(float64(risk.Headroom) / shrink * float64(time.Second))
+					risk.Alert = window(groupID) > 0 && risk.TimeToLoss < window(groupID)
+				}
+
+				if current.Retention[groupID] == nil {
+					current.Retention[groupID] = map[string]map[int32]RetentionRisk{}
+				}
+				if current.Retention[groupID][topic] == nil {
+					current.Retention[groupID][topic] = map[int32]RetentionRisk{}
+				}
+				current.Retention[groupID][topic][partition] = risk
+			}
+		}
+	}
+}
+
+// publishRetention publishes the retention risk recorded in the snapshot to
+// the observer if it implements RetentionObserver
+func publishRetention(observer Observer, snapshot *Snapshot) {
+	v, ok := observer.(RetentionObserver)
+	if !ok {
+		return
+	}
+
+	for groupID, topics := range snapshot.Retention {
+		for topic, partitions := range topics {
+			for partition, risk := range partitions {
+				v.ObserveRetention(groupID, topic, partition, risk)
+			}
+		}
+	}
+}
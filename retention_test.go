@@ -0,0 +1,54 @@
+package kag
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+func TestApplyRetention(t *testing.T) {
+	now := time.Now()
+	previous := &Snapshot{
+		Time:   now.Add(-10 * time.Second),
+		Oldest: map[string]map[int32]int64{"t": {0: 1000, 1: 1000, 2: 1000, 3: 1000, 4: 0}},
+		Groups: map[string]map[string]map[int32]int64{
+			"g": {"t": {0: 1100, 1: 2000, 2: 1000, 3: 5000, 4: -1}},
+		},
+	}
+	current := &Snapshot{
+		Time:   now,
+		Oldest: map[string]map[int32]int64{"t": {0: 1100, 1: 1100, 2: 1100, 3: 1100, 4: 0}},
+		Groups: map[string]map[string]map[int32]int64{
+			"g": {"t": {0: 1150, 1: 2100, 2: 1050, 3: 5000, 4: -1}},
+		},
+		Lag: map[string]map[string]map[int32]int64{
+			"g": {"t": {0: 10, 1: 10, 2: 10, 3: 0, 4: 20}},
+		},
+	}
+
+	applyRetention(previous, current, func(string) time.Duration { return time.Minute })
+	risks := current.Retention["g"]["t"]
+
+	// retention advances 10/s, consumer 5/s; 50 records of headroom
+	assert.Equal(t, int64(50), risks[0].Headroom)
+	assert.Equal(t, 10*time.Second, risks[0].TimeToLoss)
+	assert.True(t, risks[0].Alert)
+
+	// consumer keeps pace with retention
+	assert.True(t, risks[1].Never)
+	assert.False(t, risks[1].Alert)
+
+	// committed offset already deleted
+	assert.True(t, risks[2].Lost)
+	assert.True(t, risks[2].Alert)
+
+	_, ok := risks[3]
+	assert.False(t, ok, "groups without lag carry no risk")
+
+	_, ok = risks[4]
+	assert.False(t, ok, "partitions never committed carry no risk")
+
+	applyRetention(previous, current, Config{}.retentionAlert)
+	assert.False(t, current.Retention["g"]["t"][0].Alert, "zero window disables alerting")
+}
@@ -0,0 +1,377 @@
+package kag
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/savaki/kag/internal/wire"
+)
+
+// SASL mechanisms supported by kag
+const (
+	MechanismPlain       = "PLAIN"
+	MechanismScramSHA256 = "SCRAM-SHA-256"
+	MechanismScramSHA512 = "SCRAM-SHA-512"
+	MechanismOAuthBearer = "OAUTHBEARER"
+)
+
+// minScramIterations matches the minimum iteration count accepted by kafka
+const minScramIterations = 4096
+
+// SASLMechanism authenticates each connection to the brokers.  See Config.SASL.
+type SASLMechanism interface {
+	// Name returns the mechanism requested in the SaslHandshake e.g. PLAIN
+	Name() string
+
+	// Start begins the authentication of a new connection, returning the
+	// state of the exchange and the first message sent to the broker
+	Start(ctx context.Context) (SASLExchange, []byte, error)
+}
+
+// SASLExchange holds the state of the authentication of a single connection
+type SASLExchange interface {
+	// Next is called with each message returned by the broker.  Returns the
+	// next message to send or done once authentication is complete.
+	Next(challenge []byte) (response []byte, done bool, err error)
+}
+
+// PlainMechanism returns the SASL/PLAIN mechanism.  PLAIN sends the
+// password in the clear and should only be used over TLS.
+func PlainMechanism(username, password string) SASLMechanism {
+	return plainMechanism{username: username, password: password}
+}
+
+type plainMechanism struct {
+	username string
+	password string
+}
+
+func (m plainMechanism) Name() string {
+	return MechanismPlain
+}
+
+func (m plainMechanism) Start(ctx context.Context) (SASLExchange, []byte, error) {
+	return doneExchange{}, []byte("\x00" + m.username + "\x00" + m.password), nil
+}
+
+// doneExchange completes once the broker accepts the first message
+type doneExchange struct{}
+
+func (doneExchange) Next(challenge []byte) ([]byte, bool, error) {
+	return nil, true, nil
+}
+
+// ScramMechanism returns the SASL/SCRAM mechanism of RFC 5802 using either
+// MechanismScramSHA256 or MechanismScramSHA512
+func ScramMechanism(mechanism, username, password string) (SASLMechanism, error) {
+	if scramHash(mechanism) == nil {
+		return nil, errors.Errorf("unknown scram mechanism, %v", mechanism)
+	}
+	return scramMechanism{mechanism: mechanism, username: username, password: password}, nil
+}
+
+type scramMechanism struct {
+	mechanism string
+	username  string
+	password  string
+}
+
+// scramHash returns the hash of the mechanism or nil if unknown
+func scramHash(mechanism string) func() hash.Hash {
+	switch mechanism {
+	case MechanismScramSHA256:
+		return sha256.New
+	case MechanismScramSHA512:
+		return sha512.New
+	default:
+		return nil
+	}
+}
+
+func (m scramMechanism) Name() string {
+	return m.mechanism
+}
+
+func (m scramMechanism) Start(ctx context.Context) (SASLExchange, []byte, error) {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to generate scram nonce")
+	}
+	return m.start(base64.StdEncoding.EncodeToString(nonce))
+}
+
+func (m scramMechanism) start(nonce string) (SASLExchange, []byte, error) {
+	username := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(m.username)
+	exchange := &scramExchange{
+		hash:        scramHash(m.mechanism),
+		password:    m.password,
+		nonce:       nonce,
+		clientFirst: "n=" + username + ",r=" + nonce,
+	}
+	return exchange, []byte("n,," + exchange.clientFirst), nil
+}
+
+// scramExchange holds the state of a single SCRAM authentication
+type scramExchange struct {
+	hash        func() hash.Hash
+	password    string
+	nonce       string
+	clientFirst string
+
+	// serverSignature is set once the client proof has been sent
+	serverSignature []byte
+}
+
+func (s *scramExchange) Next(challenge []byte) ([]byte, bool, error) {
+	attrs := scramAttributes(string(challenge))
+	if msg, ok := attrs["e"]; ok {
+		return nil, false, errors.Errorf("scram authentication failed, %v", msg)
+	}
+
+	if s.serverSignature != nil {
+		signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+		if err != nil || !hmac.Equal(signature, s.serverSignature) {
+			return nil, false, errors.Errorf("scram server signature mismatch")
+		}
+		return nil, true, nil
+	}
+
+	nonce := attrs["r"]
+	if !strings.HasPrefix(nonce, s.nonce) || len(nonce) == len(s.nonce) {
+		return nil, false, errors.Errorf("scram server nonce does not extend the client nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "invalid scram salt")
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "invalid scram iteration count")
+	}
+	if iterations < minScramIterations {
+		return nil, false, errors.Errorf("scram iteration count, %v, is less than %v", iterations, minScramIterations)
+	}
+
+	clientFinal := "c=biws,r=" + nonce
+	authMessage := []byte(s.clientFirst + "," + string(challenge) + "," + clientFinal)
+
+	salted := scramHi(s.hash, []byte(s.password), salt, iterations)
+	clientKey := scramHMAC(s.hash, salted, []byte("Client Key"))
+	storedKey := s.hash()
+	storedKey.Write(clientKey)
+	clientSignature := scramHMAC(s.hash, storedKey.Sum(nil), authMessage)
+
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+
+	serverKey := scramHMAC(s.hash, salted, []byte("Server Key"))
+	s.serverSignature = scramHMAC(s.hash, serverKey, authMessage)
+
+	return []byte(clientFinal + ",p=" + base64.StdEncoding.EncodeToString(proof)), false, nil
+}
+
+// scramAttributes parses the comma separated attributes of a server message
+func scramAttributes(msg string) map[string]string {
+	attrs := map[string]string{}
+	for _, item := range strings.Split(msg, ",") {
+		if len(item) >= 2 && item[1] == '=' {
+			attrs[item[:1]] = item[2:]
+		}
+	}
+	return attrs
+}
+
+func scramHMAC(h func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// scramHi is the Hi function of RFC 5802; PBKDF2 with an output the size of
+// the hash
+func scramHi(h func() hash.Hash, password, salt []byte, iterations int) []byte {
+	var one [4]byte
+	binary.BigEndian.PutUint32(one[:], 1)
+
+	u := scramHMAC(h, password, append(append([]byte{}, salt...), one[:]...))
+	result := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		u = scramHMAC(h, password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+// OAuthToken holds a bearer token and any SASL extensions sent with it
+type OAuthToken struct {
+	Value      string
+	Extensions map[string]string
+}
+
+// TokenProvider supplies the tokens used by OAuthBearerMechanism.  Token is
+// called for each new connection so that providers may refresh expiring
+// tokens.
+type TokenProvider interface {
+	Token(ctx context.Context) (OAuthToken, error)
+}
+
+// TokenProviderFunc adapts a func to the TokenProvider interface
+type TokenProviderFunc func(ctx context.Context) (OAuthToken, error)
+
+func (fn TokenProviderFunc) Token(ctx context.Context) (OAuthToken, error) {
+	return fn(ctx)
+}
+
+// StaticToken returns a TokenProvider that always returns token
+func StaticToken(token string) TokenProvider {
+	return staticToken(token)
+}
+
+type staticToken string
+
+func (s staticToken) Token(ctx context.Context) (OAuthToken, error) {
+	return OAuthToken{Value: string(s)}, nil
+}
+
+// TokenFile returns a TokenProvider that reads the token from the named file
+// for each connection so that tokens rotated by another process are used
+func TokenFile(filename string) TokenProvider {
+	return tokenFile(filename)
+}
+
+type tokenFile string
+
+func (f tokenFile) Token(ctx context.Context) (OAuthToken, error) {
+	data, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return OAuthToken{}, errors.Wrapf(err, "unable to read token file, %v", string(f))
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return OAuthToken{}, errors.Errorf("token file, %v, is empty", string(f))
+	}
+	return OAuthToken{Value: token}, nil
+}
+
+// OAuthBearerMechanism returns the SASL/OAUTHBEARER mechanism of RFC 7628
+// using tokens from provider
+func OAuthBearerMechanism(provider TokenProvider) SASLMechanism {
+	return oauthBearerMechanism{provider: provider}
+}
+
+type oauthBearerMechanism struct {
+	provider TokenProvider
+}
+
+func (m oauthBearerMechanism) Name() string {
+	return MechanismOAuthBearer
+}
+
+func (m oauthBearerMechanism) Start(ctx context.Context) (SASLExchange, []byte, error) {
+	token, err := m.provider.Token(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to obtain oauth token")
+	}
+
+	var keys []string
+	for key := range token.Extensions {
+		if key == "auth" {
+			return nil, nil, errors.Errorf("oauth extension, auth, is reserved")
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf := bytes.NewBufferString("n,,\x01auth=Bearer " + token.Value + "\x01")
+	for _, key := range keys {
+		buf.WriteString(key + "=" + token.Extensions[key] + "\x01")
+	}
+	buf.WriteString("\x01")
+
+	return doneExchange{}, buf.Bytes(), nil
+}
+
+// authenticate performs the SASL handshake and authentication on a newly
+// opened connection using version 1 of SaslHandshake and version 0 of
+// SaslAuthenticate, available since kafka 1.0
+func (c *Client) authenticate(ctx context.Context, conn net.Conn) error {
+	mechanism := c.config.SASL
+
+	timeout := c.requestTimeout()
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	w := wire.NewConn(conn, c.clientID(), timeout)
+
+	var code int16
+	var enabled []string
+	err := w.Do(wire.SaslHandshakeKey, 1,
+		func(e *wire.Encoder) {
+			e.String(mechanism.Name())
+		},
+		func(d *wire.Decoder) error {
+			code = d.Int16()
+			enabled = d.StringArray()
+			return nil
+		},
+	)
+	if err != nil {
+		return errors.Wrapf(err, "sasl handshake failed")
+	}
+	if code != 0 {
+		return errors.Errorf("sasl mechanism, %v, is not enabled; broker supports %v", mechanism.Name(), strings.Join(enabled, ", "))
+	}
+
+	exchange, msg, err := mechanism.Start(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		var challenge []byte
+		var message string
+		err := w.Do(wire.SaslAuthenticateKey, 0,
+			func(e *wire.Encoder) {
+				e.Bytes(msg)
+			},
+			func(d *wire.Decoder) error {
+				code = d.Int16()
+				message = d.String()
+				challenge = d.Bytes()
+				return nil
+			},
+		)
+		if err != nil {
+			return errors.Wrapf(err, "sasl authentication failed")
+		}
+		if code != 0 {
+			return errors.Errorf("sasl authentication failed, %v (%v)", message, code)
+		}
+
+		var done bool
+		msg, done, err = exchange.Next(challenge)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
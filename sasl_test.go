@@ -0,0 +1,112 @@
+package kag
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestScram(t *testing.T) {
+	// test vector from RFC 7677
+	m, err := ScramMechanism(MechanismScramSHA256, "user", "pencil")
+	assert.Nil(t, err)
+
+	exchange, msg, err := m.(scramMechanism).start("rOprNGfwEbeRWgbNEkqO")
+	assert.Nil(t, err)
+	assert.Equal(t, "n,,n=user,r=rOprNGfwEbeRWgbNEkqO", string(msg))
+
+	msg, done, err := exchange.Next([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+	assert.Nil(t, err)
+	assert.False(t, done)
+	assert.Equal(t, "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", string(msg))
+
+	t.Run("server signature", func(t *testing.T) {
+		_, _, err := exchange.Next([]byte("v=AAAA"))
+		assert.NotNil(t, err)
+	})
+
+	_, done, err = exchange.Next([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="))
+	assert.Nil(t, err)
+	assert.True(t, done)
+
+	t.Run("nonce", func(t *testing.T) {
+		exchange, _, err := m.(scramMechanism).start("abc")
+		assert.Nil(t, err)
+		_, _, err = exchange.Next([]byte("r=xyz,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+		assert.NotNil(t, err)
+	})
+
+	_, err = ScramMechanism("SCRAM-MD5", "user", "pencil")
+	assert.NotNil(t, err)
+}
+
+func TestSASL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kag")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "token")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("secret\n"), 0600))
+
+	testCases := map[string]struct {
+		Mechanism SASLMechanism
+		OK        bool
+	}{
+		"plain": {
+			Mechanism: PlainMechanism("alice", "password"),
+			OK:        true,
+		},
+		"plain invalid password": {
+			Mechanism: PlainMechanism("alice", "wrong"),
+		},
+		"oauthbearer": {
+			Mechanism: OAuthBearerMechanism(StaticToken("secret")),
+			OK:        true,
+		},
+		"oauthbearer token file": {
+			Mechanism: OAuthBearerMechanism(TokenFile(filename)),
+			OK:        true,
+		},
+		"oauthbearer invalid token": {
+			Mechanism: OAuthBearerMechanism(StaticToken("guess")),
+		},
+		"mechanism not enabled": {
+			Mechanism: mustScram(t, MechanismScramSHA512, "alice", "password"),
+		},
+	}
+
+	cluster := newCluster(t)
+	defer cluster.Close()
+	cluster.RequirePlain("alice", "password")
+	cluster.RequireOAuthBearer("secret")
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			config := testConfig(cluster)
+			config.SASL = tc.Mechanism
+
+			snapshot, err := NewClient(config).Scrape(context.Background())
+			if !tc.OK {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, map[string]map[string]map[int32]int64{"billing": {"orders": {0: 6, 1: 0}}}, snapshot.Lag)
+		})
+	}
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		_, err := NewClient(testConfig(cluster)).Scrape(context.Background())
+		assert.NotNil(t, err)
+	})
+}
+
+func mustScram(t *testing.T, mechanism, username, password string) SASLMechanism {
+	m, err := ScramMechanism(mechanism, username, password)
+	assert.Nil(t, err)
+	return m
+}
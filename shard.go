@@ -0,0 +1,96 @@
+package kag
+
+import (
+	"hash/fnv"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/savaki/kag/internal/wire"
+)
+
+// shard identifies the share of the cluster monitored by one of the kag
+// instances in Config.ShardGroup.  Consumer groups, or topics when Topics is
+// true, are divided among the instances by hash of their name.
+type shard struct {
+	Index  int32
+	Count  int32
+	Topics bool
+}
+
+// owns returns true if the name hashes to the shard
+func (s *shard) owns(name string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int32(h.Sum32()%uint32(s.Count)) == s.Index
+}
+
+// ownsGroup returns true if the shard monitors the consumer group
+func (s *shard) ownsGroup(groupID string) bool {
+	return s == nil || s.Topics || s.owns(groupID)
+}
+
+// ownsTopic returns true if the shard monitors the topic
+func (s *shard) ownsTopic(topic string) bool {
+	return s == nil || !s.Topics || s.owns(topic)
+}
+
+// leads returns true if the shard publishes metrics that describe the
+// cluster as a whole e.g. ClusterHealth
+func (s *shard) leads() bool {
+	return s == nil || s.Index == 0
+}
+
+// publishesTopics returns true if the shard publishes topic level metrics
+// e.g. produce rates
+func (s *shard) publishesTopics() bool {
+	return s == nil || s.Topics || s.Index == 0
+}
+
+// assignShards numbers the members of the shard group in order of member id
+func assignShards(memberIDs []string) map[string][]byte {
+	sorted := append([]string{}, memberIDs...)
+	sort.Strings(sorted)
+
+	assignments := map[string][]byte{}
+	for i, memberID := range sorted {
+		e := &wire.Encoder{}
+		e.Int32(int32(i))
+		e.Int32(int32(len(sorted)))
+		assignments[memberID] = e.Encoded()
+	}
+	return assignments
+}
+
+// decodeShard decodes an assignment made by assignShards
+func decodeShard(assignment []byte, topics bool) (*shard, error) {
+	d := wire.NewDecoder(assignment)
+	s := &shard{
+		Index:  d.Int32(),
+		Count:  d.Int32(),
+		Topics: topics,
+	}
+	if err := d.Err(); err != nil {
+		return nil, errors.Wrapf(err, "invalid shard assignment")
+	}
+	if s.Count <= 0 || s.Index < 0 || s.Index >= s.Count {
+		return nil, errors.Errorf("invalid shard assignment, %v of %v", s.Index, s.Count)
+	}
+	return s, nil
+}
+
+// filterMetadata returns a copy of the metadata holding only the topics the
+// shard monitors
+func (s *shard) filterMetadata(metadata *wire.MetadataResponse) *wire.MetadataResponse {
+	if s == nil || !s.Topics {
+		return metadata
+	}
+
+	filtered := *metadata
+	filtered.Topics = nil
+	for _, topic := range metadata.Topics {
+		if s.ownsTopic(topic.Topic) {
+			filtered.Topics = append(filtered.Topics, topic)
+		}
+	}
+	return &filtered
+}
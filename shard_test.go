@@ -0,0 +1,65 @@
+package kag
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/savaki/kag/internal/wire"
+	"github.com/tj/assert"
+)
+
+func TestShards(t *testing.T) {
+	assignments := assignShards([]string{"c", "a", "b"})
+	assert.Len(t, assignments, 3)
+
+	var shards []*shard
+	for i, memberID := range []string{"a", "b", "c"} {
+		s, err := decodeShard(assignments[memberID], false)
+		assert.Nil(t, err)
+		assert.Equal(t, &shard{Index: int32(i), Count: 3}, s)
+		shards = append(shards, s)
+	}
+
+	// every group is owned by exactly one shard
+	for i := 0; i < 100; i++ {
+		groupID := fmt.Sprintf("group-%v", i)
+		owners := 0
+		for _, s := range shards {
+			if s.ownsGroup(groupID) {
+				owners++
+			}
+			assert.True(t, s.ownsTopic(groupID))
+		}
+		assert.Equal(t, 1, owners)
+	}
+
+	assert.True(t, shards[0].leads())
+	assert.False(t, shards[1].leads())
+	assert.False(t, shards[1].publishesTopics())
+
+	var none *shard
+	assert.True(t, none.ownsGroup("group"))
+	assert.True(t, none.ownsTopic("topic"))
+	assert.True(t, none.leads())
+
+	_, err := decodeShard([]byte{}, false)
+	assert.NotNil(t, err)
+}
+
+func TestShardFilterMetadata(t *testing.T) {
+	metadata := &wire.MetadataResponse{}
+	for i := 0; i < 20; i++ {
+		metadata.Topics = append(metadata.Topics, wire.MetadataTopic{
+			Topic: fmt.Sprintf("topic-%v", i),
+		})
+	}
+
+	a := &shard{Index: 0, Count: 2, Topics: true}
+	b := &shard{Index: 1, Count: 2, Topics: true}
+	assert.Equal(t, len(metadata.Topics), len(a.filterMetadata(metadata).Topics)+len(b.filterMetadata(metadata).Topics))
+	assert.Len(t, metadata.Topics, 20)
+	assert.True(t, b.publishesTopics())
+
+	var none *shard
+	assert.Equal(t, metadata, none.filterMetadata(metadata))
+}
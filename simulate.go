@@ -0,0 +1,578 @@
+package kag
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Types of ScenarioEvent
+const (
+	// EventStall stops a group committing offsets
+	EventStall = "stall"
+
+	// EventRebalance stops a group committing offsets while its partitions
+	// are reassigned; lasts DefaultRebalanceDuration unless Duration is set
+	EventRebalance = "rebalance"
+
+	// EventRate changes the produce rate of a topic or, when Group is set,
+	// the consume rate of a group
+	EventRate = "rate"
+
+	// EventTruncate removes all but the newest Records of each partition of
+	// a topic, as retention would
+	EventTruncate = "truncate"
+
+	// EventBrokerDown stops a broker.  Partitions it led fail over to another
+	// in sync replica, or go offline if there is none.
+	EventBrokerDown = "broker_down"
+)
+
+// DefaultRebalanceDuration is the duration of an EventRebalance that does
+// not set one
+const DefaultRebalanceDuration = 30 * time.Second
+
+// Scenario describes the synthetic activity of a cluster generated by a
+// Simulator.  Offsets advance at the rates given, adjusted by events.
+type Scenario struct {
+	// Brokers holds the number of brokers; defaults to 3
+	Brokers int
+
+	// Duration ends the simulation; zero runs until the context is cancelled
+	Duration time.Duration
+
+	Topics []ScenarioTopic
+	Groups []ScenarioGroup
+	Events []ScenarioEvent
+}
+
+// ScenarioTopic describes a topic of a Scenario
+type ScenarioTopic struct {
+	Name string
+
+	// Partitions defaults to 1
+	Partitions int
+
+	// ReplicationFactor defaults to 3 or the number of brokers if fewer
+	ReplicationFactor int
+
+	// ProduceRate holds the records per second appended to each partition
+	ProduceRate float64
+
+	// Retention removes records once they are older than this; zero keeps
+	// every record
+	Retention time.Duration
+}
+
+// ScenarioGroup describes a consumer group of a Scenario
+type ScenarioGroup struct {
+	Name string
+
+	// Topics holds the topics the group consumes
+	Topics []string
+
+	// ConsumeRate holds the records per second committed on each partition.
+	// A group never commits beyond the newest offset.
+	ConsumeRate float64
+
+	// Lag holds the lag of each partition at the start of the simulation
+	Lag int64
+}
+
+// ScenarioEvent changes the activity of a Scenario for a period
+type ScenarioEvent struct {
+	// Type holds one of EventStall, EventRebalance, EventRate, EventTruncate,
+	// or EventBrokerDown
+	Type string
+
+	// At holds the time from the start of the simulation the event begins
+	At time.Duration
+
+	// Duration holds how long the event lasts; zero lasts until the end of
+	// the simulation.  Ignored by EventTruncate.
+	Duration time.Duration
+
+	// Group identifies the group of stall, rebalance, and rate events
+	Group string
+
+	// Topic identifies the topic of truncate and rate events
+	Topic string
+
+	// Broker identifies the node id, from 1, of broker_down events
+	Broker int32
+
+	// Rate holds the new rate of rate events
+	Rate float64
+
+	// Records holds the records kept by truncate events
+	Records int64
+}
+
+func (e ScenarioEvent) active(t time.Duration) bool {
+	return t >= e.At && (e.Duration == 0 || t < e.At+e.Duration)
+}
+
+// offsetMark records the newest offset of a partition at a point in time
+type offsetMark struct {
+	At     time.Duration
+	Offset float64
+}
+
+type simPartition struct {
+	newest float64
+	oldest float64
+
+	// marks holds the newest offset at each step, from which retention and
+	// time lag are derived
+	marks []offsetMark
+}
+
+// producedAt returns the time the record at offset was produced
+func (p *simPartition) producedAt(offset float64) time.Duration {
+	for i := 1; i < len(p.marks); i++ {
+		a, b := p.marks[i-1], p.marks[i]
+		if b.Offset <= offset {
+			continue
+		}
+		if a.Offset >= offset {
+			return a.At
+		}
+		return a.At + time.Duration(float64(b.At-a.At)*(offset-a.Offset)/(b.Offset-a.Offset))
+	}
+	return p.marks[len(p.marks)-1].At
+}
+
+// trim discards the marks no longer needed to place offsets from floor on
+func (p *simPartition) trim(floor float64) {
+	i := 0
+	for i+1 < len(p.marks) && p.marks[i+1].Offset <= floor {
+		i++
+	}
+	p.marks = p.marks[i:]
+}
+
+// Simulator publishes synthetic scrapes, generated from a Scenario, to the
+// Config.Observer without connecting to Kafka.  Rates, forecasts, retention
+// risk, cluster health, and History are derived as for a Monitor.
+type Simulator struct {
+	scenario Scenario
+	config   Config
+	start    time.Time
+	topics   map[string][]*simPartition
+	groups   map[string]map[string][]float64
+
+	// truncated records the truncate events that have been applied
+	truncated map[int]bool
+
+	mutex    sync.Mutex
+	elapsed  time.Duration
+	snapshot *Snapshot
+	health   Health
+}
+
+// NewSimulator validates the scenario and returns a Simulator whose clock
+// starts now
+func NewSimulator(scenario Scenario, config Config) (*Simulator, error) {
+	config = applyDefaults(config)
+	if scenario.Brokers == 0 {
+		scenario.Brokers = 3
+	}
+	if scenario.Brokers < 0 {
+		return nil, errors.Errorf("invalid scenario, brokers must be positive")
+	}
+
+	s := &Simulator{
+		scenario:  scenario,
+		config:    config,
+		start:     time.Now(),
+		topics:    map[string][]*simPartition{},
+		groups:    map[string]map[string][]float64{},
+		truncated: map[int]bool{},
+		health: Health{
+			Interval: config.Interval,
+			Started:  time.Now(),
+		},
+	}
+
+	lags := map[string]int64{}
+	for _, group := range scenario.Groups {
+		for _, topic := range group.Topics {
+			if group.Lag > lags[topic] {
+				lags[topic] = group.Lag
+			}
+		}
+	}
+
+	for i := range scenario.Topics {
+		topic := &s.scenario.Topics[i]
+		if topic.Name == "" {
+			return nil, errors.Errorf("invalid scenario, topic name required")
+		}
+		if _, ok := s.topics[topic.Name]; ok {
+			return nil, errors.Errorf("invalid scenario, duplicate topic, %v", topic.Name)
+		}
+		if topic.Partitions == 0 {
+			topic.Partitions = 1
+		}
+		if topic.ReplicationFactor == 0 {
+			topic.ReplicationFactor = 3
+		}
+		if topic.ReplicationFactor > scenario.Brokers {
+			topic.ReplicationFactor = scenario.Brokers
+		}
+		if topic.Partitions < 0 || topic.ReplicationFactor < 0 || topic.ProduceRate < 0 || topic.Retention < 0 {
+			return nil, errors.Errorf("invalid scenario, topic %v: partitions, replication factor, rate, and retention may not be negative", topic.Name)
+		}
+
+		// records already on the topic are taken to have been produced at the
+		// produce rate before the simulation began
+		newest := float64(lags[topic.Name])
+		var partitions []*simPartition
+		for p := 0; p < topic.Partitions; p++ {
+			partition := &simPartition{newest: newest}
+			if topic.ProduceRate > 0 && newest > 0 {
+				before := time.Duration(newest / topic.ProduceRate * float64(time.Second))
+				partition.marks = append(partition.marks, offsetMark{At: -before})
+			}
+			partition.marks = append(partition.marks, offsetMark{Offset: newest})
+			partitions = append(partitions, partition)
+		}
+		s.topics[topic.Name] = partitions
+	}
+
+	for _, group := range scenario.Groups {
+		if group.Name == "" {
+			return nil, errors.Errorf("invalid scenario, group name required")
+		}
+		if _, ok := s.groups[group.Name]; ok {
+			return nil, errors.Errorf("invalid scenario, duplicate group, %v", group.Name)
+		}
+		if group.ConsumeRate < 0 || group.Lag < 0 {
+			return nil, errors.Errorf("invalid scenario, group %v: rate and lag may not be negative", group.Name)
+		}
+
+		topics := map[string][]float64{}
+		for _, topic := range group.Topics {
+			partitions, ok := s.topics[topic]
+			if !ok {
+				return nil, errors.Errorf("invalid scenario, group %v consumes unknown topic, %v", group.Name, topic)
+			}
+			for _, partition := range partitions {
+				topics[topic] = append(topics[topic], partition.newest-float64(group.Lag))
+			}
+		}
+		s.groups[group.Name] = topics
+	}
+
+	for i := range scenario.Events {
+		event := &s.scenario.Events[i]
+		if event.At < 0 || event.Duration < 0 {
+			return nil, errors.Errorf("invalid scenario, event %v: times may not be negative", i+1)
+		}
+
+		_, group := s.groups[event.Group]
+		_, topic := s.topics[event.Topic]
+		switch event.Type {
+		case EventStall, EventRebalance:
+			if !group {
+				return nil, errors.Errorf("invalid scenario, %v event requires a known group, %v", event.Type, event.Group)
+			}
+			if event.Type == EventRebalance && event.Duration == 0 {
+				event.Duration = DefaultRebalanceDuration
+			}
+		case EventRate:
+			if group == topic {
+				return nil, errors.Errorf("invalid scenario, rate event requires one of a known group or topic")
+			}
+			if event.Rate < 0 {
+				return nil, errors.Errorf("invalid scenario, rate event may not be negative")
+			}
+		case EventTruncate:
+			if !topic {
+				return nil, errors.Errorf("invalid scenario, truncate event requires a known topic, %v", event.Topic)
+			}
+			if event.Records < 0 {
+				return nil, errors.Errorf("invalid scenario, truncate event may not keep negative records")
+			}
+		case EventBrokerDown:
+			if event.Broker < 1 || int(event.Broker) > scenario.Brokers {
+				return nil, errors.Errorf("invalid scenario, broker_down event requires a broker between 1 and %v", scenario.Brokers)
+			}
+		default:
+			return nil, errors.Errorf("invalid scenario, unknown event type, %v", event.Type)
+		}
+	}
+
+	return s, nil
+}
+
+// produceRate returns the produce rate of the topic at time t
+func (s *Simulator) produceRate(topic ScenarioTopic, t time.Duration) float64 {
+	rate := topic.ProduceRate
+	for _, event := range s.scenario.Events {
+		if event.Type == EventRate && event.Group == "" && event.Topic == topic.Name && event.active(t) {
+			rate = event.Rate
+		}
+	}
+	return rate
+}
+
+// consumeRate returns the consume rate of the group at time t
+func (s *Simulator) consumeRate(group ScenarioGroup, t time.Duration) float64 {
+	rate := group.ConsumeRate
+	for _, event := range s.scenario.Events {
+		if event.Group != group.Name || !event.active(t) {
+			continue
+		}
+		switch event.Type {
+		case EventStall, EventRebalance:
+			return 0
+		case EventRate:
+			rate = event.Rate
+		}
+	}
+	return rate
+}
+
+// Step advances the simulation by d then publishes and returns the resulting
+// scrape.  Step must not be called concurrently.
+func (s *Simulator) Step(d time.Duration) *Snapshot {
+	s.mutex.Lock()
+	previous := s.snapshot
+	from := s.elapsed
+	to := from + d
+	s.elapsed = to
+	s.mutex.Unlock()
+
+	seconds := d.Seconds()
+	for _, topic := range s.scenario.Topics {
+		rate := s.produceRate(topic, from)
+		for _, p := range s.topics[topic.Name] {
+			p.newest += rate * seconds
+			p.marks = append(p.marks, offsetMark{At: to, Offset: p.newest})
+		}
+	}
+
+	for i, event := range s.scenario.Events {
+		if event.Type != EventTruncate || event.At > to || s.truncated[i] {
+			continue
+		}
+		s.truncated[i] = true
+		for _, p := range s.topics[event.Topic] {
+			p.oldest = math.Max(p.oldest, p.newest-float64(event.Records))
+		}
+	}
+
+	for _, topic := range s.scenario.Topics {
+		if topic.Retention == 0 {
+			continue
+		}
+		for _, p := range s.topics[topic.Name] {
+			if expired := to - topic.Retention; expired > p.marks[0].At {
+				p.oldest = math.Max(p.oldest, offsetAt(p.marks, expired))
+			}
+		}
+	}
+
+	for _, group := range s.scenario.Groups {
+		rate := s.consumeRate(group, from)
+		for topic, committed := range s.groups[group.Name] {
+			for i := range committed {
+				committed[i] = math.Min(committed[i]+rate*seconds, s.topics[topic][i].newest)
+			}
+		}
+	}
+
+	snapshot := s.makeSnapshot(to)
+	for topic, partitions := range s.topics {
+		for i, p := range partitions {
+			floor := p.oldest
+			for _, topics := range s.groups {
+				if committed, ok := topics[topic]; ok {
+					floor = math.Min(floor, committed[i])
+				}
+			}
+			p.trim(floor)
+		}
+	}
+
+	applyRates(previous, snapshot)
+	applyRetention(previous, snapshot, s.config.retentionAlert)
+
+	s.mutex.Lock()
+	s.snapshot = snapshot
+	s.health.LastScrape = time.Now()
+	s.health.LastProgress = s.health.LastScrape
+	s.mutex.Unlock()
+
+	if s.config.History != nil {
+		if err := s.config.History.Append(snapshot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	publish(s.config.Observer, snapshot, nil)
+
+	return snapshot
+}
+
+// offsetAt returns the newest offset at time t by interpolating between marks
+func offsetAt(marks []offsetMark, t time.Duration) float64 {
+	for i := 1; i < len(marks); i++ {
+		a, b := marks[i-1], marks[i]
+		if b.At < t {
+			continue
+		}
+		if b.At == a.At {
+			return b.Offset
+		}
+		return a.Offset + (b.Offset-a.Offset)*float64(t-a.At)/float64(b.At-a.At)
+	}
+	return marks[len(marks)-1].Offset
+}
+
+// live returns true if the broker is up at time t
+func (s *Simulator) live(nodeID int32, t time.Duration) bool {
+	for _, event := range s.scenario.Events {
+		if event.Type == EventBrokerDown && event.Broker == nodeID && event.active(t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Simulator) makeSnapshot(t time.Duration) *Snapshot {
+	snapshot := &Snapshot{
+		Cluster:    s.config.Cluster,
+		Time:       s.start.Add(t),
+		Controller: -1,
+		Topics:     map[string][]PartitionMetadata{},
+		Newest:     map[string]map[int32]int64{},
+		Oldest:     map[string]map[int32]int64{},
+		Groups:     map[string]map[string]map[int32]int64{},
+		TimeLag:    map[string]map[string]map[int32]time.Duration{},
+	}
+
+	for i := 0; i < s.scenario.Brokers; i++ {
+		nodeID := int32(i + 1)
+		if !s.live(nodeID, t) {
+			continue
+		}
+		if snapshot.Controller == -1 {
+			snapshot.Controller = nodeID
+		}
+		snapshot.Brokers = append(snapshot.Brokers, BrokerMetadata{
+			NodeID: nodeID,
+			Host:   fmt.Sprintf("broker-%v.simulated", nodeID),
+			Port:   9092,
+		})
+	}
+
+	newest, oldest := topicOffsets{}, topicOffsets{}
+	for _, topic := range s.scenario.Topics {
+		for i, p := range s.topics[topic.Name] {
+			partition := int32(i)
+			metadata := PartitionMetadata{Partition: partition, Leader: -1, Isr: []int32{}}
+			for r := 0; r < topic.ReplicationFactor; r++ {
+				nodeID := int32((i+r)%s.scenario.Brokers + 1)
+				metadata.Replicas = append(metadata.Replicas, nodeID)
+				if s.live(nodeID, t) {
+					metadata.Isr = append(metadata.Isr, nodeID)
+				}
+			}
+			if len(metadata.Isr) > 0 {
+				metadata.Leader = metadata.Isr[0]
+			}
+			snapshot.Topics[topic.Name] = append(snapshot.Topics[topic.Name], metadata)
+
+			newest.add(topic.Name, partition, int64(p.newest))
+			oldest.add(topic.Name, partition, int64(p.oldest))
+		}
+	}
+	snapshot.Newest = newest.copy()
+	snapshot.Oldest = oldest.copy()
+
+	groups := groupOffsets{}
+	for groupID, topics := range s.groups {
+		offsets := topicOffsets{}
+		for topic, committed := range topics {
+			for i, offset := range committed {
+				offsets.add(topic, int32(i), int64(offset))
+
+				p := s.topics[topic][i]
+				if offset >= math.Floor(p.newest) {
+					continue
+				}
+				if snapshot.TimeLag[groupID] == nil {
+					snapshot.TimeLag[groupID] = map[string]map[int32]time.Duration{}
+				}
+				if snapshot.TimeLag[groupID][topic] == nil {
+					snapshot.TimeLag[groupID][topic] = map[int32]time.Duration{}
+				}
+				snapshot.TimeLag[groupID][topic][int32(i)] = t - p.producedAt(offset)
+			}
+		}
+		groups[groupID] = offsets
+		snapshot.Groups[groupID] = offsets.copy()
+	}
+
+	snapshot.Lag = makeLag(newest, oldest, groups)
+
+	return snapshot
+}
+
+// Run steps the simulation every Config.Interval until the scenario ends or
+// the context is cancelled.  Simulated time advances speed times faster than
+// real time; each step covers Config.Interval * speed.
+func (s *Simulator) Run(ctx context.Context, speed float64) error {
+	if speed <= 0 {
+		return errors.Errorf("speed must be positive")
+	}
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	step := time.Duration(float64(s.config.Interval) * speed)
+	s.Step(0)
+	for {
+		if s.Elapsed() >= s.scenario.Duration && s.scenario.Duration > 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.Step(step)
+		}
+	}
+}
+
+// Elapsed returns the simulated time since the start of the scenario
+func (s *Simulator) Elapsed() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.elapsed
+}
+
+// Snapshot returns the most recent simulated scrape or nil if none
+func (s *Simulator) Snapshot() *Snapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.snapshot
+}
+
+// Health reports the progress of the simulation in the manner of
+// Monitor.Health
+func (s *Simulator) Health() Health {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.health
+}
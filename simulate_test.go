@@ -0,0 +1,107 @@
+package kag
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+func TestSimulator(t *testing.T) {
+	scenario := Scenario{
+		Topics: []ScenarioTopic{
+			{Name: "orders", Partitions: 2, ProduceRate: 10},
+		},
+		Groups: []ScenarioGroup{
+			{Name: "billing", Topics: []string{"orders"}, ConsumeRate: 10, Lag: 100},
+			{Name: "shipping", Topics: []string{"orders"}, ConsumeRate: 5},
+		},
+		Events: []ScenarioEvent{
+			{Type: EventStall, At: time.Minute, Duration: time.Minute, Group: "billing"},
+			{Type: EventBrokerDown, At: 2 * time.Minute, Broker: 1},
+			{Type: EventTruncate, At: 3 * time.Minute, Topic: "orders", Records: 50},
+		},
+	}
+
+	lag := lagRecorder{}
+	simulator, err := NewSimulator(scenario, Config{Observer: lag})
+	assert.Nil(t, err)
+
+	snapshot := simulator.Step(0)
+	assert.EqualValues(t, 100, snapshot.Newest["orders"][0])
+	assert.EqualValues(t, 100, snapshot.Lag["billing"]["orders"][0])
+	assert.EqualValues(t, 0, snapshot.Lag["shipping"]["orders"][0])
+	assert.Equal(t, 10*time.Second, snapshot.TimeLag["billing"]["orders"][0])
+	assert.Len(t, snapshot.Brokers, 3)
+
+	snapshot = simulator.Step(time.Minute)
+	assert.EqualValues(t, 700, snapshot.Newest["orders"][1])
+	assert.EqualValues(t, 100, snapshot.Lag["billing"]["orders"][1])
+	assert.EqualValues(t, 300, snapshot.Lag["shipping"]["orders"][1])
+	assert.Equal(t, 10.0, snapshot.ProduceRate["orders"][1])
+	assert.Equal(t, 5.0, snapshot.Forecast["shipping"]["orders"][1].ConsumeRate)
+	assert.True(t, snapshot.Forecast["shipping"]["orders"][1].Never)
+
+	// billing stalls for a minute
+	snapshot = simulator.Step(time.Minute)
+	assert.EqualValues(t, 700, snapshot.Lag["billing"]["orders"][0])
+	assert.Equal(t, 70*time.Second, snapshot.TimeLag["billing"]["orders"][0])
+	assert.EqualValues(t, 700, lag["billing"]["orders"][0])
+
+	// broker 1 is down
+	health := snapshot.ClusterHealth()
+	assert.Len(t, snapshot.Brokers, 2)
+	assert.Equal(t, []TopicPartition{{Topic: "orders", Partition: 0}, {Topic: "orders", Partition: 1}}, health.UnderReplicated)
+	assert.Equal(t, []TopicPartition{{Topic: "orders", Partition: 0}}, health.NonPreferredLeader)
+
+	// the truncation leaves both groups behind the oldest offset
+	snapshot = simulator.Step(time.Minute)
+	assert.EqualValues(t, 1850, snapshot.Oldest["orders"][0])
+	assert.True(t, snapshot.Retention["billing"]["orders"][0].Lost)
+	assert.Equal(t, 3*time.Minute, simulator.Elapsed())
+}
+
+func TestSimulatorRetention(t *testing.T) {
+	scenario := Scenario{
+		Topics: []ScenarioTopic{{Name: "orders", ProduceRate: 1, Retention: time.Minute}},
+		Groups: []ScenarioGroup{{Name: "billing", Topics: []string{"orders"}}},
+	}
+	simulator, err := NewSimulator(scenario, Config{})
+	assert.Nil(t, err)
+
+	simulator.Step(0)
+	for i := 0; i < 5; i++ {
+		simulator.Step(time.Minute)
+	}
+	snapshot := simulator.Step(time.Minute)
+	assert.EqualValues(t, 360, snapshot.Newest["orders"][0])
+	assert.EqualValues(t, 300, snapshot.Oldest["orders"][0])
+	assert.Equal(t, 6*time.Minute, snapshot.TimeLag["billing"]["orders"][0])
+}
+
+func TestScenarioValidation(t *testing.T) {
+	testCases := map[string]Scenario{
+		"unknown topic": {
+			Groups: []ScenarioGroup{{Name: "billing", Topics: []string{"orders"}}},
+		},
+		"duplicate topic": {
+			Topics: []ScenarioTopic{{Name: "orders"}, {Name: "orders"}},
+		},
+		"unknown event": {
+			Events: []ScenarioEvent{{Type: "explode"}},
+		},
+		"unknown broker": {
+			Events: []ScenarioEvent{{Type: EventBrokerDown, Broker: 4}},
+		},
+		"stall without group": {
+			Events: []ScenarioEvent{{Type: EventStall}},
+		},
+	}
+
+	for label, scenario := range testCases {
+		t.Run(label, func(t *testing.T) {
+			_, err := NewSimulator(scenario, Config{})
+			assert.NotNil(t, err)
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package kag
+
+import (
+	"sort"
+	"time"
+
+	"github.com/savaki/kag/internal/wire"
+)
+
+// BrokerMetadata describes a single broker in the cluster
+type BrokerMetadata struct {
+	NodeID int32  `json:"node_id"`
+	Host   string `json:"host"`
+	Port   int32  `json:"port"`
+
+	// Rack holds the rack of the broker if configured; requires kafka 0.10.0
+	Rack string `json:"rack,omitempty"`
+}
+
+// PartitionMetadata describes the replica assignment of a single topic partition
+type PartitionMetadata struct {
+	Partition int32
+	Leader    int32
+	Replicas  []int32
+	Isr       []int32
+}
+
+// Snapshot holds the results of a single scrape of the kafka cluster.  Once
+// published by the Monitor, a Snapshot must be treated as read-only.
+type Snapshot struct {
+	// Cluster holds the name of the cluster that was scraped
+	Cluster string
+
+	// Time the scrape completed
+	Time time.Time
+
+	// Brokers holds the brokers in the cluster sorted by NodeID
+	Brokers []BrokerMetadata
+
+	// ClusterID holds the id assigned to the cluster by kafka; empty before
+	// kafka 0.10.1
+	ClusterID string
+
+	// Controller holds the node id of the controller broker or -1 if unknown,
+	// as it is before kafka 0.10.0
+	Controller int32
+
+	// Topics holds the partition metadata for each topic sorted by partition
+	Topics map[string][]PartitionMetadata
+
+	// Internal holds the topics, e.g. __consumer_offsets, that the brokers
+	// report as internal; empty before kafka 0.10.0
+	Internal map[string]bool
+
+	// Newest holds the newest offset for each topic partition
+	Newest map[string]map[int32]int64
+
+	// Oldest holds the oldest offset for each topic partition
+	Oldest map[string]map[int32]int64
+
+	// Groups holds the committed offset for each group, topic, and partition
+	Groups map[string]map[string]map[int32]int64
+
+	// Lag holds the lag that was published to the Observer for each group,
+	// topic, and partition
+	Lag map[string]map[string]map[int32]int64
+
+	// TimeLag holds the time elapsed between the scrape and the timestamp of
+	// the oldest unconsumed record for each group, topic, and partition.
+	// Only populated when Config.TimeLag is enabled; partitions whose
+	// records could not be read are omitted.
+	TimeLag map[string]map[string]map[int32]time.Duration
+
+	// ProduceRate holds the records per second appended to each topic
+	// partition since the previous scrape.  Only populated by the Monitor
+	// from the second scrape on.
+	ProduceRate map[string]map[int32]float64
+
+	// Forecast holds the consume rate and catch up forecast for each group,
+	// topic, and partition.  Only populated by the Monitor from the second
+	// scrape on.
+	Forecast map[string]map[string]map[int32]Forecast
+
+	// Retention holds the retention risk for each lagging group, topic, and
+	// partition.  Only populated by the Monitor from the second scrape on.
+	Retention map[string]map[string]map[int32]RetentionRisk
+}
+
+// GroupIDs returns the sorted list of consumer groups in the Snapshot
+func (s *Snapshot) GroupIDs() []string {
+	var groupIDs []string
+	for groupID := range s.Groups {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Strings(groupIDs)
+	return groupIDs
+}
+
+// TopicNames returns the sorted list of topics in the Snapshot
+func (s *Snapshot) TopicNames() []string {
+	var topics []string
+	for topic := range s.Topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// TotalLag returns the sum of the lag across all partitions consumed by the group
+func (s *Snapshot) TotalLag(groupID string) (total int64) {
+	for _, partitions := range s.Lag[groupID] {
+		for _, lag := range partitions {
+			total += lag
+		}
+	}
+	return
+}
+
+// publishLag publishes the lag recorded in the snapshot to the observer
+func publishLag(observer Observer, snapshot *Snapshot) {
+	for groupID, topics := range snapshot.Lag {
+		for topic, partitions := range topics {
+			for partition, lag := range partitions {
+				observer.Observe(groupID, topic, partition, lag)
+			}
+		}
+	}
+
+	if v, ok := observer.(TimeLagObserver); ok {
+		for groupID, topics := range snapshot.TimeLag {
+			for topic, partitions := range topics {
+				for partition, lag := range partitions {
+					v.ObserveTimeLag(groupID, topic, partition, lag)
+				}
+			}
+		}
+	}
+}
+
+func (t topicOffsets) copy() topicOffsets {
+	out := topicOffsets{}
+	for topic, partitions := range t {
+		for partition, offset := range partitions {
+			out.add(topic, partition, offset)
+		}
+	}
+	return out
+}
+
+type lagRecorder map[string]map[string]map[int32]int64
+
+func (l lagRecorder) Observe(groupID, topic string, partition int32, lag int64) {
+	topics, ok := l[groupID]
+	if !ok {
+		topics = map[string]map[int32]int64{}
+		l[groupID] = topics
+	}
+
+	partitions, ok := topics[topic]
+	if !ok {
+		partitions = map[int32]int64{}
+		topics[topic] = partitions
+	}
+
+	partitions[partition] = lag
+}
+
+func makeBrokerMetadata(in []wire.MetadataBroker) []BrokerMetadata {
+	var brokers []BrokerMetadata
+	for _, broker := range in {
+		brokers = append(brokers, BrokerMetadata{
+			NodeID: broker.NodeID,
+			Host:   broker.Host,
+			Port:   broker.Port,
+			Rack:   broker.Rack,
+		})
+	}
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].NodeID < brokers[j].NodeID })
+	return brokers
+}
+
+func makeTopicMetadata(in []wire.MetadataTopic) map[string][]PartitionMetadata {
+	topics := map[string][]PartitionMetadata{}
+	for _, topic := range in {
+		var partitions []PartitionMetadata
+		for _, partition := range topic.Partitions {
+			partitions = append(partitions, PartitionMetadata{
+				Partition: partition.Partition,
+				Leader:    partition.Leader,
+				Replicas:  append([]int32(nil), partition.Replicas...),
+				Isr:       append([]int32(nil), partition.Isr...),
+			})
+		}
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i].Partition < partitions[j].Partition })
+		topics[topic.Topic] = partitions
+	}
+	return topics
+}
+
+func makeSnapshot(cluster string, metadata *wire.MetadataResponse, newest, oldest topicOffsets, groups groupOffsets) *Snapshot {
+	snapshot := &Snapshot{
+		Cluster:    cluster,
+		Time:       time.Now(),
+		Brokers:    makeBrokerMetadata(metadata.Brokers),
+		ClusterID:  metadata.ClusterID,
+		Controller: metadata.ControllerID,
+		Topics:     makeTopicMetadata(metadata.Topics),
+		Newest:     newest.copy(),
+		Oldest:     oldest.copy(),
+		Groups:     map[string]map[string]map[int32]int64{},
+	}
+	for _, topic := range metadata.Topics {
+		if topic.Internal {
+			if snapshot.Internal == nil {
+				snapshot.Internal = map[string]bool{}
+			}
+			snapshot.Internal[topic.Topic] = true
+		}
+	}
+	for groupID, topics := range groups {
+		snapshot.Groups[groupID] = topics.copy()
+	}
+
+	snapshot.Lag = makeLag(newest, oldest, groups)
+
+	return snapshot
+}
+
+// makeLag returns the lag of each group partition.  Partitions holding no
+// records are skipped.
+func makeLag(newest, oldest topicOffsets, groups groupOffsets) map[string]map[string]map[int32]int64 {
+	lag := lagRecorder{}
+	trimmed := newest.copy()
+	removeZeroEntries(trimmed, oldest)
+	observeLag(lag, trimmed, groups)
+	return lag
+}
@@ -0,0 +1,162 @@
+package kag
+
+import (
+	"sort"
+	"time"
+
+	"github.com/savaki/kag/internal/wire"
+)
+
+// ScrapeStats describes how kag itself performed during a single scrape
+type ScrapeStats struct {
+	// Cluster holds the name of the cluster that was scraped
+	Cluster string
+
+	// Time the scrape completed or failed
+	Time time.Time
+
+	// Duration holds the time taken by the scrape, including connecting to
+	// the brokers when a scrape fails to connect
+	Duration time.Duration
+
+	// Err holds the error that failed the scrape, if any
+	Err error
+
+	// Brokers, Groups, Topics, and Partitions count what was scraped; zero
+	// when the scrape failed
+	Brokers    int
+	Groups     int
+	Topics     int
+	Partitions int
+
+	// Requests holds the requests sent to each broker during the scrape
+	// sorted by NodeID
+	Requests []BrokerRequests
+
+	// Scrapes and Errors count the scrapes attempted and failed since the
+	// Monitor started
+	Scrapes int64
+	Errors  int64
+}
+
+// BrokerRequests summarizes the requests sent to a single broker
+type BrokerRequests struct {
+	// NodeID holds the node id of the broker or -1 for the bootstrap broker,
+	// whose node id is not known
+	NodeID   int32
+	Requests int
+	Errors   int
+
+	// Latency holds the mean time taken by a request and MaxLatency the
+	// longest any one took
+	Latency    time.Duration
+	MaxLatency time.Duration
+}
+
+// StatsObserver may optionally be implemented by an Observer to receive the
+// performance of kag itself after each scrape, successful or not
+type StatsObserver interface {
+	ObserveStats(stats ScrapeStats)
+}
+
+// Stats returns the performance of the most recent scrape.  Stats is the
+// zero value until the first scrape completes or fails.
+func (m *Monitor) Stats() ScrapeStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.stats
+}
+
+// observeStats adds the totals to stats then records and publishes them
+func (m *Monitor) observeStats(config Config, stats ScrapeStats) {
+	m.mutex.Lock()
+	m.scrapes++
+	if stats.Err != nil {
+		m.errors++
+	}
+	stats.Cluster = config.Cluster
+	stats.Scrapes = m.scrapes
+	stats.Errors = m.errors
+	m.stats = stats
+	m.mutex.Unlock()
+
+	if v, ok := config.Observer.(StatsObserver); ok {
+		v.ObserveStats(stats)
+	}
+}
+
+// makeScrapeStats returns the stats of a scrape that started at started and
+// produced snapshot, which is nil if the scrape failed
+func makeScrapeStats(started time.Time, snapshot *Snapshot, requests []BrokerRequests, err error) ScrapeStats {
+	now := time.Now()
+	stats := ScrapeStats{
+		Time:     now,
+		Duration: now.Sub(started),
+		Err:      err,
+		Requests: requests,
+	}
+	if snapshot != nil {
+		stats.Brokers = len(snapshot.Brokers)
+		stats.Groups = len(snapshot.Groups)
+		stats.Topics = len(snapshot.Newest)
+		for _, partitions := range snapshot.Newest {
+			stats.Partitions += len(partitions)
+		}
+	}
+	return stats
+}
+
+// requestStats returns, and resets, the requests sent over each connection
+// of the session
+func (s *session) requestStats() []BrokerRequests {
+	byNode := map[int32]wire.Stats{}
+	add := func(nodeID int32, conn *wire.Conn) {
+		v := byNode[nodeID]
+		v.Add(conn.Stats())
+		byNode[nodeID] = v
+	}
+
+	add(-1, s.conn)
+	for _, b := range s.brokers {
+		add(b.nodeID, b.conn)
+	}
+	s.mutex.Lock()
+	for nodeID, conn := range s.fetchers {
+		add(nodeID, conn)
+	}
+	for nodeID, stats := range s.retired {
+		v := byNode[nodeID]
+		v.Add(stats)
+		byNode[nodeID] = v
+	}
+	s.retired = nil
+	s.mutex.Unlock()
+
+	var requests []BrokerRequests
+	for nodeID, v := range byNode {
+		if v.Requests == 0 {
+			continue
+		}
+		requests = append(requests, BrokerRequests{
+			NodeID:     nodeID,
+			Requests:   v.Requests,
+			Errors:     v.Errors,
+			Latency:    v.Latency / time.Duration(v.Requests),
+			MaxLatency: v.MaxLatency,
+		})
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].NodeID < requests[j].NodeID })
+	return requests
+}
+
+// retireStats keeps the requests sent over a fetcher that is being closed;
+// the caller must hold the mutex
+func (s *session) retireStats(nodeID int32, conn *wire.Conn) {
+	if s.retired == nil {
+		s.retired = map[int32]wire.Stats{}
+	}
+	v := s.retired[nodeID]
+	v.Add(conn.Stats())
+	s.retired[nodeID] = v
+}
@@ -0,0 +1,87 @@
+package kag
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+// statsRecorder records the stats published after each scrape
+type statsRecorder struct {
+	mutex sync.Mutex
+	stats []ScrapeStats
+}
+
+func (r *statsRecorder) Observe(groupID, topic string, partition int32, lag int64) {}
+
+func (r *statsRecorder) ObserveStats(stats ScrapeStats) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stats = append(r.stats, stats)
+}
+
+func (r *statsRecorder) Last() (ScrapeStats, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.stats) == 0 {
+		return ScrapeStats{}, false
+	}
+	return r.stats[len(r.stats)-1], true
+}
+
+func TestMonitorStats(t *testing.T) {
+	cluster := newCluster(t)
+	defer cluster.Close()
+
+	recorder := &statsRecorder{}
+	config := testConfig(cluster)
+	config.Observer = recorder
+
+	monitor := New(config)
+	defer monitor.Close()
+
+	waitFor(t, func() bool {
+		stats, ok := recorder.Last()
+		return ok && stats.Scrapes >= 2
+	})
+
+	stats, _ := recorder.Last()
+	assert.Nil(t, stats.Err)
+	assert.Equal(t, "test", stats.Cluster)
+	assert.Equal(t, 3, stats.Brokers)
+	assert.Equal(t, 1, stats.Groups)
+	assert.Equal(t, 1, stats.Topics)
+	assert.Equal(t, 2, stats.Partitions)
+	assert.EqualValues(t, 0, stats.Errors)
+	assert.True(t, stats.Duration > 0)
+
+	// every request of the scrape is counted against the broker it was sent to
+	assert.NotEmpty(t, stats.Requests)
+	for _, v := range stats.Requests {
+		assert.True(t, v.Requests > 0)
+		assert.True(t, v.Latency <= v.MaxLatency)
+	}
+	assert.True(t, monitor.Stats().Scrapes >= stats.Scrapes)
+
+	t.Run("scrape fails", func(t *testing.T) {
+		recorder := &statsRecorder{}
+		config := testConfig(cluster)
+		config.Brokers = []string{"127.0.0.1:1"}
+		config.Observer = recorder
+
+		monitor := New(config)
+		defer monitor.Close()
+
+		waitFor(t, func() bool {
+			stats, ok := recorder.Last()
+			return ok && stats.Errors >= 1
+		})
+
+		stats, _ := recorder.Last()
+		assert.NotNil(t, stats.Err)
+		assert.Equal(t, 0, stats.Groups)
+		assert.Equal(t, stats.Scrapes, stats.Errors)
+	})
+}
@@ -0,0 +1,319 @@
+package kag
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/savaki/kag/internal/wire"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultFetchMaxBytes is the default limit on the bytes read per partition
+// when Config.TimeLag is enabled
+const DefaultFetchMaxBytes = 64 * 1024
+
+// TimeLagObserver may optionally be implemented by an Observer to receive
+// the time lag of each group partition when Config.TimeLag is enabled
+type TimeLagObserver interface {
+	ObserveTimeLag(groupID, topic string, partition int32, lag time.Duration)
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// timestampCache holds the timestamps, in ms, of the records read by a
+// session by partition and offset.  The timestamp of a record never changes,
+// so entries are retained across polls for as long as some group remains at
+// that offset.
+type timestampCache map[partitionKey]map[int64]int64
+
+// readTimeLag computes, for each group partition with lag, the time elapsed
+// between the snapshot and the timestamp of the oldest unconsumed record.
+// Each partition is read at most once per poll; partitions that can't be read,
+// and offsets beyond what a single read returns, are omitted.
+func (s *session) readTimeLag(ctx context.Context, snapshot *Snapshot) map[string]map[string]map[int32]time.Duration {
+	timeLag := lagDurations{}
+	wanted := map[partitionKey]map[int64]bool{}
+	targets := map[string]map[partitionKey]int64{}
+
+	for groupID, topics := range snapshot.Groups {
+		for topic, partitions := range topics {
+			for partition, committed := range partitions {
+				newest, ok := snapshot.Newest[topic][partition]
+				if !ok || newest < 0 || committed < 0 {
+					continue
+				}
+				if committed >= newest {
+					timeLag.add(groupID, topic, partition, 0)
+					continue
+				}
+
+				target := committed
+				if oldest := snapshot.Oldest[topic][partition]; target < oldest {
+					target = oldest
+				}
+
+				key := partitionKey{topic: topic, partition: partition}
+				if wanted[key] == nil {
+					wanted[key] = map[int64]bool{}
+				}
+				wanted[key][target] = true
+				if targets[groupID] == nil {
+					targets[groupID] = map[partitionKey]int64{}
+				}
+				targets[groupID][key] = target
+			}
+		}
+	}
+
+	cache := timestampCache{}
+	missing := map[partitionKey][]int64{}
+	for key, offsets := range wanted {
+		for offset := range offsets {
+			if ts, ok := s.timestamps[key][offset]; ok {
+				cache.add(key, offset, ts)
+			} else {
+				missing[key] = append(missing[key], offset)
+			}
+		}
+		sort.Slice(missing[key], func(i, j int) bool { return missing[key][i] < missing[key][j] })
+	}
+	s.timestamps = cache
+
+	leaders := map[partitionKey]int32{}
+	for topic, partitions := range snapshot.Topics {
+		for _, p := range partitions {
+			leaders[partitionKey{topic: topic, partition: p.Partition}] = p.Leader
+		}
+	}
+
+	requests := map[int32][]wire.FetchPartition{}
+	topics := map[int32][]string{}
+	for key, offsets := range missing {
+		leader := leaders[key]
+		requests[leader] = append(requests[leader], wire.FetchPartition{
+			Partition:   key.partition,
+			FetchOffset: offsets[0],
+			MaxBytes:    s.client.config.FetchMaxBytes,
+		})
+		topics[leader] = append(topics[leader], key.topic)
+	}
+
+	results := make(chan map[partitionKey][]wire.RecordTimestamp, len(requests))
+	group, _ := errgroup.WithContext(ctx)
+	for item := range requests {
+		nodeID := item
+		conn, err := s.fetcher(ctx, nodeID)
+		if err != nil {
+			s.client.debug("unable to read time lag from broker, %v: %v", nodeID, err)
+			continue
+		}
+		if err := checkFetchTimestamps(conn); err != nil {
+			s.client.warnTimeLag(nodeID, err)
+			continue
+		}
+		group.Go(func() error {
+			found, err := fetchTimestamps(conn, topics[nodeID], requests[nodeID], s.client.config.FetchMaxBytes)
+			if err != nil {
+				s.client.debug("unable to read time lag from broker, %v: %v", nodeID, err)
+				s.closeFetcher(nodeID)
+				return nil
+			}
+			results <- found
+			return nil
+		})
+	}
+	group.Wait()
+	close(results)
+
+	found := map[partitionKey][]wire.RecordTimestamp{}
+	for item := range results {
+		for key, timestamps := range item {
+			found[key] = timestamps
+		}
+	}
+
+	// each partition is read once, from its lowest missing offset.  Offsets
+	// beyond what was read are left for a later poll; once the lower offsets
+	// are cached, the reads move on to them.
+	for key, offsets := range missing {
+		for _, offset := range offsets {
+			if ts, ok := wire.FindTimestamp(found[key], offset); ok {
+				cache.add(key, offset, ts)
+			}
+		}
+	}
+
+	now := snapshot.Time.UnixNano() / int64(time.Millisecond)
+	for groupID, keys := range targets {
+		for key, offset := range keys {
+			ts, ok := cache[key][offset]
+			if !ok {
+				continue
+			}
+			lag := time.Duration(now-ts) * time.Millisecond
+			if lag < 0 {
+				lag = 0
+			}
+			timeLag.add(groupID, key.topic, key.partition, lag)
+		}
+	}
+
+	return timeLag
+}
+
+func (c timestampCache) add(key partitionKey, offset, ts int64) {
+	offsets, ok := c[key]
+	if !ok {
+		offsets = map[int64]int64{}
+		c[key] = offsets
+	}
+	offsets[offset] = ts
+}
+
+// fetchTimestamps reads the records at the requested offsets and returns
+// their timestamps by partition.  topics[i] holds the topic of partitions[i].
+func fetchTimestamps(conn *wire.Conn, topics []string, partitions []wire.FetchPartition, maxBytes int32) (map[partitionKey][]wire.RecordTimestamp, error) {
+	total := int64(maxBytes) * int64(len(partitions))
+	if total > math.MaxInt32 {
+		total = math.MaxInt32
+	}
+	req := wire.FetchRequest{
+		ReplicaID: -1,
+		MaxBytes:  int32(total),
+	}
+	index := map[string]int{}
+	for i, p := range partitions {
+		n, ok := index[topics[i]]
+		if !ok {
+			n = len(req.Topics)
+			index[topics[i]] = n
+			req.Topics = append(req.Topics, wire.FetchTopic{Topic: topics[i]})
+		}
+		req.Topics[n].Partitions = append(req.Topics[n].Partitions, p)
+	}
+
+	var resp wire.FetchResponse
+	if err := request(conn, wire.FetchKey, req.Encode, resp.Decode); err != nil {
+		return nil, err
+	}
+
+	found := map[partitionKey][]wire.RecordTimestamp{}
+	for _, t := range resp.Topics {
+		for _, p := range t.Partitions {
+			if p.ErrorCode != 0 {
+				continue
+			}
+			found[partitionKey{topic: t.Topic, partition: p.Partition}] = wire.RecordTimestamps(p.Records)
+		}
+	}
+	return found, nil
+}
+
+// fetchTimestampsVersion is the first version of Fetch to return v1 messages,
+// the first to carry timestamps; introduced by kafka 0.10.0
+const fetchTimestampsVersion = 2
+
+// checkFetchTimestamps fails if the broker can't return record timestamps
+func checkFetchTimestamps(conn *wire.Conn) error {
+	version, err := conn.Version(wire.FetchKey)
+	if err != nil {
+		return err
+	}
+	if version < fetchTimestampsVersion {
+		return errors.Errorf("broker supports %v v%v; record timestamps require v%v (kafka 0.10.0)",
+			wire.ApiName(wire.FetchKey), version, fetchTimestampsVersion)
+	}
+	return nil
+}
+
+// timeLagWarnings records the brokers whose time lag was reported
+// unavailable so each is only reported once
+type timeLagWarnings struct {
+	mutex  sync.Mutex
+	warned map[int32]bool
+}
+
+// warnTimeLag reports, once per broker, that time lag can't be read from
+// the partitions the broker leads
+func (c *Client) warnTimeLag(nodeID int32, err error) {
+	w := c.timeLagWarnings
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.warned[nodeID] {
+		c.debug("unable to read time lag from broker, %v: %v", nodeID, err)
+		return
+	}
+	if w.warned == nil {
+		w.warned = map[int32]bool{}
+	}
+	w.warned[nodeID] = true
+	fmt.Fprintf(os.Stderr, "time lag unavailable for broker, %v: %v\n", nodeID, err)
+}
+
+// fetcher returns the connection used to read records from the broker,
+// dialing it on first use
+func (s *session) fetcher(ctx context.Context, nodeID int32) (*wire.Conn, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if conn, ok := s.fetchers[nodeID]; ok {
+		return conn, nil
+	}
+
+	for _, broker := range s.brokerList {
+		if broker.NodeID != nodeID {
+			continue
+		}
+
+		conn, err := s.client.dial(ctx, fmt.Sprintf("%v:%v", broker.Host, broker.Port))
+		if err != nil {
+			return nil, err
+		}
+		if s.fetchers == nil {
+			s.fetchers = map[int32]*wire.Conn{}
+		}
+		s.fetchers[nodeID] = conn
+		return conn, nil
+	}
+
+	return nil, errors.Errorf("unknown broker, %v", nodeID)
+}
+
+func (s *session) closeFetcher(nodeID int32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if conn, ok := s.fetchers[nodeID]; ok {
+		s.retireStats(nodeID, conn)
+		conn.Close()
+		delete(s.fetchers, nodeID)
+	}
+}
+
+type lagDurations map[string]map[string]map[int32]time.Duration
+
+func (l lagDurations) add(groupID, topic string, partition int32, lag time.Duration) {
+	topics, ok := l[groupID]
+	if !ok {
+		topics = map[string]map[int32]time.Duration{}
+		l[groupID] = topics
+	}
+
+	partitions, ok := topics[topic]
+	if !ok {
+		partitions = map[int32]time.Duration{}
+		topics[topic] = partitions
+	}
+
+	partitions[partition] = lag
+}
@@ -0,0 +1,110 @@
+package kag
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/savaki/franz"
+	"github.com/savaki/kag/internal/wire"
+	"golang.org/x/sync/errgroup"
+)
+
+// TimeOffset holds the offset of the first record of a partition whose
+// timestamp is at or after the requested time
+type TimeOffset struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+
+	// Offset holds the offset of the record or -1 if no record in the
+	// partition has a timestamp at or after the requested time
+	Offset int64 `json:"offset"`
+
+	// Timestamp holds the timestamp of the record; zero if Offset is -1
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// OffsetsForTime returns, for each partition of the topics, the first offset
+// whose timestamp is at or after t.  If topics is empty, all topics are
+// returned.
+func (c *Client) OffsetsForTime(ctx context.Context, t time.Time, topics ...string) ([]TimeOffset, error) {
+	s, err := c.openSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	metadata, err := requestMetadata(s.conn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to retrieve metadata")
+	}
+
+	if len(topics) > 0 {
+		wanted := map[string]bool{}
+		for _, topic := range topics {
+			wanted[topic] = true
+		}
+
+		filtered := &wire.MetadataResponse{Brokers: metadata.Brokers}
+		for _, topic := range metadata.Topics {
+			if wanted[topic.Topic] {
+				filtered.Topics = append(filtered.Topics, topic)
+				delete(wanted, topic.Topic)
+			}
+		}
+		for topic := range wanted {
+			return nil, errors.Errorf("topic not found, %v", topic)
+		}
+		metadata = filtered
+	}
+
+	ms := t.UnixNano() / int64(time.Millisecond)
+	results := make(chan wire.ListOffsetsResponse, len(s.brokers))
+
+	group, _ := errgroup.WithContext(ctx)
+	for _, item := range s.brokers {
+		broker := item
+		group.Go(func() error {
+			resp, err := broker.listOffsets(metadata, ms)
+			if err == nil {
+				results <- resp
+			}
+			return err
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	close(results)
+
+	var offsets []TimeOffset
+	for resp := range results {
+		for _, topic := range resp.Topics {
+			for _, p := range topic.Partitions {
+				if p.ErrorCode != 0 {
+					return nil, errors.Wrapf(franz.Error(p.ErrorCode), "unable to list offsets for %v/%v", topic.Topic, p.Partition)
+				}
+
+				item := TimeOffset{
+					Topic:     topic.Topic,
+					Partition: p.Partition,
+					Offset:    p.Offset,
+				}
+				if p.Offset >= 0 && p.Timestamp >= 0 {
+					item.Timestamp = time.Unix(0, p.Timestamp*int64(time.Millisecond)).UTC()
+				}
+				offsets = append(offsets, item)
+			}
+		}
+	}
+
+	sort.Slice(offsets, func(i, j int) bool {
+		if offsets[i].Topic != offsets[j].Topic {
+			return offsets[i].Topic < offsets[j].Topic
+		}
+		return offsets[i].Partition < offsets[j].Partition
+	})
+
+	return offsets, nil
+}
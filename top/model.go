@@ -0,0 +1,428 @@
+// Package top implements the interactive terminal dashboard behind kag top
+package top
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/savaki/kag"
+)
+
+// historySize is the number of polls retained for trends and sparklines
+const historySize = 20
+
+const (
+	levelGroups = iota
+	levelTopics
+	levelPartitions
+)
+
+const (
+	sortByLag = iota
+	sortByName
+	sortByTrend
+)
+
+var sortNames = []string{"lag", "name", "trend"}
+
+// Source provides the most recent Snapshot e.g. *kag.Monitor
+type Source interface {
+	Snapshot() *kag.Snapshot
+}
+
+type row struct {
+	Name    string
+	Lag     int64
+	Detail  string
+	History []int64
+}
+
+func (r row) trend() int64 {
+	if n := len(r.History); n >= 2 {
+		return r.History[n-1] - r.History[n-2]
+	}
+	return 0
+}
+
+// Model holds the state of the dashboard.  Model is not safe for concurrent use.
+type Model struct {
+	snapshot *kag.Snapshot
+	history  map[string][]int64
+
+	level   int
+	group   string
+	topic   string
+	cursor  int
+	sortBy  int
+	reverse bool
+
+	filter  string
+	editing bool
+
+	Width  int
+	Height int
+}
+
+// NewModel returns an empty Model
+func NewModel() *Model {
+	return &Model{
+		history: map[string][]int64{},
+		Width:   80,
+		Height:  24,
+	}
+}
+
+// Update records the snapshot if it is newer than the one currently displayed
+func (m *Model) Update(snapshot *kag.Snapshot) {
+	if snapshot == nil || (m.snapshot != nil && !snapshot.Time.After(m.snapshot.Time)) {
+		return
+	}
+	m.snapshot = snapshot
+
+	// only the keys present in the snapshot are carried forward, so the
+	// history of deleted groups, topics, and partitions is dropped
+	history := make(map[string][]int64, len(m.history))
+	for groupID, topics := range snapshot.Lag {
+		var groupLag int64
+		for topic, partitions := range topics {
+			var topicLag int64
+			for partition, lag := range partitions {
+				m.record(history, historyKey(groupID, topic, strconv.Itoa(int(partition))), lag)
+				topicLag += lag
+			}
+			m.record(history, historyKey(groupID, topic), topicLag)
+			groupLag += topicLag
+		}
+		m.record(history, historyKey(groupID), groupLag)
+	}
+	m.history = history
+}
+
+// record appends lag to the history of key and stores the result in history
+func (m *Model) record(history map[string][]int64, key string, lag int64) {
+	values := append(m.history[key], lag)
+	if len(values) > historySize {
+		values = values[len(values)-historySize:]
+	}
+	history[key] = values
+}
+
+func historyKey(parts ...string) string {
+	return strings.Join(parts, "\x00")
+}
+
+func (m *Model) rows() []row {
+	if m.snapshot == nil {
+		return nil
+	}
+
+	var rows []row
+	switch m.level {
+	case levelGroups:
+		for groupID, topics := range m.snapshot.Lag {
+			rows = append(rows, row{
+				Name:    groupID,
+				Lag:     m.snapshot.TotalLag(groupID),
+				Detail:  fmt.Sprintf("%d topics", len(topics)),
+				History: m.history[historyKey(groupID)],
+			})
+		}
+
+	case levelTopics:
+		for topic, partitions := range m.snapshot.Lag[m.group] {
+			var lag int64
+			for _, v := range partitions {
+				lag += v
+			}
+			rows = append(rows, row{
+				Name:    topic,
+				Lag:     lag,
+				Detail:  fmt.Sprintf("%d partitions", len(partitions)),
+				History: m.history[historyKey(m.group, topic)],
+			})
+		}
+
+	case levelPartitions:
+		for partition, lag := range m.snapshot.Lag[m.group][m.topic] {
+			p := strconv.Itoa(int(partition))
+			rows = append(rows, row{
+				Name: p,
+				Lag:  lag,
+				Detail: fmt.Sprintf("committed %d, newest %d",
+					m.snapshot.Groups[m.group][m.topic][partition],
+					m.snapshot.Newest[m.topic][partition]),
+				History: m.history[historyKey(m.group, m.topic, p)],
+			})
+		}
+	}
+
+	if m.filter != "" {
+		filtered := rows[:0]
+		for _, r := range rows {
+			if strings.Contains(r.Name, m.filter) {
+				filtered = append(filtered, r)
+			}
+		}
+		rows = filtered
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if m.reverse {
+			a, b = b, a
+		}
+		switch m.sortBy {
+		case sortByLag:
+			if a.Lag != b.Lag {
+				return a.Lag > b.Lag
+			}
+		case sortByTrend:
+			if a.trend() != b.trend() {
+				return a.trend() > b.trend()
+			}
+		}
+		if m.level == levelPartitions {
+			x, _ := strconv.Atoi(a.Name)
+			y, _ := strconv.Atoi(b.Name)
+			return x < y
+		}
+		return a.Name < b.Name
+	})
+
+	return rows
+}
+
+// HandleKey applies the key press to the Model and returns true if the
+// dashboard should exit
+func (m *Model) HandleKey(key Key) bool {
+	if m.editing {
+		switch key {
+		case KeyEnter:
+			m.editing = false
+		case KeyEscape:
+			m.editing = false
+			m.filter = ""
+		case KeyBackspace:
+			if n := len(m.filter); n > 0 {
+				_, size := utf8.DecodeLastRuneInString(m.filter)
+				m.filter = m.filter[:n-size]
+			}
+		default:
+			if r, size := utf8.DecodeRuneInString(string(key)); size == len(key) && r >= ' ' {
+				m.filter += string(key)
+			}
+		}
+		m.cursor = 0
+		return false
+	}
+
+	switch key {
+	case "q", KeyCtrlC:
+		return true
+	case KeyUp, "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case KeyDown, "j":
+		m.cursor++
+	case KeyEnter, KeyRight, "l":
+		m.drillDown()
+	case KeyEscape, KeyBackspace, KeyLeft, "h":
+		m.drillUp()
+	case "/":
+		m.editing = true
+		m.filter = ""
+	case "s":
+		m.sortBy = (m.sortBy + 1) % len(sortNames)
+	case "r":
+		m.reverse = !m.reverse
+	}
+
+	if rows := m.rows(); m.cursor >= len(rows) {
+		m.cursor = len(rows) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+	return false
+}
+
+func (m *Model) drillDown() {
+	rows := m.rows()
+	if m.cursor >= len(rows) || m.level == levelPartitions {
+		return
+	}
+
+	switch m.level {
+	case levelGroups:
+		m.group = rows[m.cursor].Name
+	case levelTopics:
+		m.topic = rows[m.cursor].Name
+	}
+	m.level++
+	m.cursor = 0
+	m.filter = ""
+}
+
+func (m *Model) drillUp() {
+	if m.level == levelGroups {
+		m.filter = ""
+		return
+	}
+
+	var name string
+	switch m.level {
+	case levelTopics:
+		name, m.group = m.group, ""
+	case levelPartitions:
+		name, m.topic = m.topic, ""
+	}
+	m.level--
+	m.filter = ""
+	m.cursor = 0
+	for index, r := range m.rows() {
+		if r.Name == name {
+			m.cursor = index
+		}
+	}
+}
+
+// Render draws the full screen to w
+func (m *Model) Render(w io.Writer) {
+	lines := []string{m.header(), m.breadcrumb(), ""}
+
+	columns := []string{"GROUP", "TOPIC", "PARTITION"}
+	lines = append(lines, fmt.Sprintf("  %-40s %14s %5s  %-*s  %s",
+		columns[m.level], "LAG", "TREND", historySize, "HISTORY", "DETAIL"))
+
+	rows := m.rows()
+	visible := m.Height - len(lines) - 2
+	if visible < 1 {
+		visible = 1
+	}
+	offset := 0
+	if m.cursor >= visible {
+		offset = m.cursor - visible + 1
+	}
+
+	for index := offset; index < len(rows) && index < offset+visible; index++ {
+		r := rows[index]
+		line := fmt.Sprintf("  %-40s %14d %5s  %-*s  %s",
+			truncate(r.Name, 40), r.Lag, arrow(r.trend()), historySize, sparkline(r.History), r.Detail)
+		if index == m.cursor {
+			line = "\x1b[7m" + pad(line, m.Width) + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+	if m.snapshot == nil {
+		lines = append(lines, "  waiting for first scrape ...")
+	}
+
+	for len(lines) < m.Height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, m.footer())
+
+	io.WriteString(w, "\x1b[H\x1b[2J")
+	for index, line := range lines {
+		io.WriteString(w, truncate(line, m.Width))
+		if index < len(lines)-1 {
+			io.WriteString(w, "\r\n")
+		}
+	}
+}
+
+func (m *Model) header() string {
+	var cluster, scraped string
+	if m.snapshot != nil {
+		cluster = m.snapshot.Cluster
+		scraped = m.snapshot.Time.Format(time.Stamp)
+	}
+
+	order := "desc"
+	if m.reverse {
+		order = "asc"
+	}
+	return fmt.Sprintf("kag top - cluster %v - last scrape %v - sort %v %v",
+		cluster, scraped, sortNames[m.sortBy], order)
+}
+
+func (m *Model) breadcrumb() string {
+	path := []string{"groups"}
+	if m.group != "" {
+		path = append(path, m.group)
+	}
+	if m.topic != "" {
+		path = append(path, m.topic)
+	}
+
+	line := strings.Join(path, " > ")
+	if m.editing {
+		line += "   filter: " + m.filter + "_"
+	} else if m.filter != "" {
+		line += "   filter: " + m.filter
+	}
+	return line
+}
+
+func (m *Model) footer() string {
+	return "\x1b[7m up/down move  enter drill down  esc back  / filter  s sort  r reverse  q quit \x1b[0m"
+}
+
+func arrow(delta int64) string {
+	switch {
+	case delta > 0:
+		return "↑"
+	case delta < 0:
+		return "↓"
+	default:
+		return "→"
+	}
+}
+
+var ticks = []rune("▁▂▃▄▅▆▇█")
+
+func sparkline(values []int64) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	out := make([]rune, 0, len(values))
+	for _, v := range values {
+		index := 0
+		if max > min {
+			index = int((v - min) * int64(len(ticks)-1) / (max - min))
+		}
+		out = append(out, ticks[index])
+	}
+	return string(out)
+}
+
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width || strings.Contains(s, "\x1b") {
+		return s
+	}
+	return string([]rune(s)[:width])
+}
+
+func pad(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
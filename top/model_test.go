@@ -0,0 +1,88 @@
+package top
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/savaki/kag"
+	"github.com/tj/assert"
+)
+
+func TestParseKeys(t *testing.T) {
+	assert.Equal(t, []Key{KeyUp, KeyDown, "q", KeyEnter, KeyEscape}, parseKeys([]byte("\x1b[A\x1b[Bq\r\x1b")))
+}
+
+func TestSparkline(t *testing.T) {
+	assert.Equal(t, "", sparkline(nil))
+	assert.Equal(t, "▁▁", sparkline([]int64{5, 5}))
+	assert.Equal(t, "▁▄█", sparkline([]int64{0, 50, 100}))
+}
+
+func TestModel(t *testing.T) {
+	snapshot := func(at time.Time, a, b int64) *kag.Snapshot {
+		return &kag.Snapshot{
+			Time: at,
+			Lag: map[string]map[string]map[int32]int64{
+				"a": {"topic": {0: a}},
+				"b": {"topic": {0: b, 1: b}},
+			},
+		}
+	}
+
+	now := time.Now()
+	model := NewModel()
+	model.Update(snapshot(now, 10, 1))
+	model.Update(snapshot(now.Add(time.Minute), 5, 2))
+
+	rows := model.rows()
+	assert.Equal(t, "a", rows[0].Name)
+	assert.Equal(t, []int64{10, 5}, rows[0].History)
+	assert.Equal(t, int64(4), rows[1].Lag)
+
+	model.HandleKey("s") // sort by name
+	model.HandleKey("r") // reverse
+	assert.Equal(t, "b", model.rows()[0].Name)
+
+	model.HandleKey(KeyEnter)
+	assert.Equal(t, "b", model.group)
+	model.HandleKey(KeyEnter)
+	assert.Equal(t, "topic", model.topic)
+	assert.Len(t, model.rows(), 2)
+
+	model.HandleKey(KeyEscape)
+	model.HandleKey(KeyEscape)
+	model.HandleKey("/")
+	model.HandleKey("a")
+	model.HandleKey(KeyEnter)
+	assert.Len(t, model.rows(), 1)
+
+	buf := bytes.NewBuffer(nil)
+	model.Render(buf)
+	assert.Contains(t, buf.String(), "filter: a")
+	assert.True(t, model.HandleKey("q"))
+}
+
+func TestModelPrunesHistory(t *testing.T) {
+	now := time.Now()
+	model := NewModel()
+	model.Update(&kag.Snapshot{
+		Time: now,
+		Lag: map[string]map[string]map[int32]int64{
+			"a": {"topic": {0: 10}},
+			"b": {"topic": {0: 1, 1: 1}},
+		},
+	})
+	assert.Len(t, model.history, 7)
+
+	// group b has been deleted
+	model.Update(&kag.Snapshot{
+		Time: now.Add(time.Minute),
+		Lag: map[string]map[string]map[int32]int64{
+			"a": {"topic": {0: 5}},
+		},
+	})
+	assert.Len(t, model.history, 3)
+	assert.Equal(t, []int64{10, 5}, model.history[historyKey("a")])
+	assert.NotContains(t, model.history, historyKey("b"))
+}
@@ -0,0 +1,135 @@
+package top
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Key identifies a single key press.  Printable keys are represented by
+// their text.
+type Key string
+
+const (
+	KeyUp        Key = "up"
+	KeyDown      Key = "down"
+	KeyLeft      Key = "left"
+	KeyRight     Key = "right"
+	KeyEnter     Key = "enter"
+	KeyEscape    Key = "escape"
+	KeyBackspace Key = "backspace"
+	KeyCtrlC     Key = "ctrl-c"
+)
+
+// ReadKeys reads key presses from a terminal in raw mode until r returns an
+// error
+func ReadKeys(r io.Reader) <-chan Key {
+	keys := make(chan Key, 16)
+
+	go func() {
+		defer close(keys)
+
+		buf := make([]byte, 64)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, key := range parseKeys(buf[:n]) {
+				keys <- key
+			}
+		}
+	}()
+
+	return keys
+}
+
+func parseKeys(data []byte) (keys []Key) {
+	for len(data) > 0 {
+		switch {
+		case len(data) >= 3 && data[0] == 0x1b && (data[1] == '[' || data[1] == 'O'):
+			switch data[2] {
+			case 'A':
+				keys = append(keys, KeyUp)
+			case 'B':
+				keys = append(keys, KeyDown)
+			case 'C':
+				keys = append(keys, KeyRight)
+			case 'D':
+				keys = append(keys, KeyLeft)
+			}
+			data = data[3:]
+			continue
+
+		case data[0] == 0x1b:
+			keys = append(keys, KeyEscape)
+		case data[0] == '\r' || data[0] == '\n':
+			keys = append(keys, KeyEnter)
+		case data[0] == 0x7f || data[0] == 0x08:
+			keys = append(keys, KeyBackspace)
+		case data[0] == 0x03:
+			keys = append(keys, KeyCtrlC)
+		case data[0] >= ' ':
+			keys = append(keys, Key(data[:1]))
+		}
+		data = data[1:]
+	}
+	return
+}
+
+// Options configures Run
+type Options struct {
+	// Interval between refreshes of the dashboard
+	Interval time.Duration
+
+	// Size optionally returns the width and height of the terminal
+	Size func() (width, height int, err error)
+}
+
+// Run draws the dashboard to w, refreshing from source every interval and
+// handling key presses until the user quits or the context is canceled
+func Run(ctx context.Context, source Source, keys <-chan Key, w io.Writer, options Options) error {
+	model := NewModel()
+	resize := func() {
+		if options.Size == nil {
+			return
+		}
+		if width, height, err := options.Size(); err == nil && width > 0 && height > 0 {
+			model.Width, model.Height = width, height
+		}
+	}
+
+	// poll more frequently than the scrape interval so that new data is
+	// displayed promptly once the scrape completes
+	refresh := options.Interval / 10
+	if refresh < 250*time.Millisecond {
+		refresh = 250 * time.Millisecond
+	}
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	resize()
+	model.Update(source.Snapshot())
+	model.Render(w)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ticker.C:
+			current := model.snapshot
+			model.Update(source.Snapshot())
+			if model.snapshot != current {
+				resize()
+				model.Render(w)
+			}
+
+		case key, ok := <-keys:
+			if !ok || model.HandleKey(key) {
+				return nil
+			}
+			model.Render(w)
+		}
+	}
+}
@@ -1,18 +1,21 @@
 package kag
 
 import (
-	"github.com/savaki/franz"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	"github.com/savaki/kag/internal/wire"
 )
 
-func makeTopics(in []*franz.MetadataResponseV0Topic) []franz.OffsetFetchRequestV3Topic {
-	var topics []franz.OffsetFetchRequestV3Topic
+func makeTopics(in []wire.MetadataTopic) []wire.OffsetFetchTopic {
+	var topics []wire.OffsetFetchTopic
 
 	for _, topic := range in {
-		item := franz.OffsetFetchRequestV3Topic{
-			Topic: topic.TopicName,
+		item := wire.OffsetFetchTopic{
+			Topic: topic.Topic,
 		}
 		for _, partition := range topic.Partitions {
-			item.Partitions = append(item.Partitions, partition.PartitionID)
+			item.Partitions = append(item.Partitions, partition.Partition)
 		}
 
 		topics = append(topics, item)
@@ -20,16 +23,16 @@ func makeTopics(in []*franz.MetadataResponseV0Topic) []franz.OffsetFetchRequestV
 	return topics
 }
 
-func makeListOffsetRequestV1(nodeID int32, metadata *franz.MetadataResponseV0, offset int64) franz.ListOffsetRequestV1 {
-	out := franz.ListOffsetRequestV1{}
+func makeListOffsetsRequest(nodeID int32, metadata *wire.MetadataResponse, offset int64) wire.ListOffsetsRequest {
+	out := wire.ListOffsetsRequest{ReplicaID: -1}
 
 	for _, topic := range metadata.Topics {
-		if topic.TopicName == "__consumer_offsets" {
+		if topic.Topic == "__consumer_offsets" {
 			continue
 		}
 
-		item := franz.ListOffsetRequestV1Topic{
-			TopicName: topic.TopicName,
+		item := wire.ListOffsetsTopic{
+			Topic: topic.Topic,
 		}
 
 		for _, partition := range topic.Partitions {
@@ -37,9 +40,9 @@ func makeListOffsetRequestV1(nodeID int32, metadata *franz.MetadataResponseV0, o
 				continue
 			}
 
-			item.Partitions = append(item.Partitions, franz.ListOffsetRequestV1Partition{
-				Partition: partition.PartitionID,
-				Time:      offset,
+			item.Partitions = append(item.Partitions, wire.ListOffsetsPartition{
+				Partition: partition.Partition,
+				Timestamp: offset,
 			})
 		}
 
@@ -51,10 +54,10 @@ func makeListOffsetRequestV1(nodeID int32, metadata *franz.MetadataResponseV0, o
 	return out
 }
 
-func removeEmpty(in []franz.OffsetFetchResponseV3Response) (responses []franz.OffsetFetchResponseV3Response) {
+func removeEmpty(in []wire.OffsetFetchTopicResponse) (responses []wire.OffsetFetchTopicResponse) {
 	for _, item := range in {
 		present := false
-		for _, partition := range item.PartitionResponses {
+		for _, partition := range item.Partitions {
 			if partition.Offset != -1 {
 				present = true
 				break
@@ -110,3 +113,59 @@ func removeZeroEntries(oldest, newest topicOffsets) {
 		}
 	}
 }
+
+// decodeMemberAssignment decodes the partition assignments of a member of a
+// group that uses the consumer protocol
+func decodeMemberAssignment(data []byte) (map[string][]int32, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	errShort := errors.Errorf("member assignment truncated")
+	next := func(n int) ([]byte, error) {
+		if n < 0 {
+			return nil, errors.Errorf("member assignment has negative length, %v", n)
+		}
+		if len(data) < n {
+			return nil, errShort
+		}
+		v := data[:n]
+		data = data[n:]
+		return v, nil
+	}
+
+	if _, err := next(2); err != nil { // version
+		return nil, err
+	}
+	v, err := next(4)
+	if err != nil {
+		return nil, err
+	}
+
+	assignments := map[string][]int32{}
+	for topics := int32(binary.BigEndian.Uint32(v)); topics > 0; topics-- {
+		v, err := next(2)
+		if err != nil {
+			return nil, err
+		}
+		name, err := next(int(int16(binary.BigEndian.Uint16(v))))
+		if err != nil {
+			return nil, err
+		}
+		v, err = next(4)
+		if err != nil {
+			return nil, err
+		}
+
+		topic := string(name)
+		for partitions := int32(binary.BigEndian.Uint32(v)); partitions > 0; partitions-- {
+			v, err := next(4)
+			if err != nil {
+				return nil, err
+			}
+			assignments[topic] = append(assignments[topic], int32(binary.BigEndian.Uint32(v)))
+		}
+	}
+
+	return assignments, nil
+}
@@ -102,3 +102,31 @@ func TestObserveLag(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeMemberAssignment(t *testing.T) {
+	data := []byte{
+		0, 0, // version
+		0, 0, 0, 1, // topics
+		0, 1, 'a', // topic
+		0, 0, 0, 2, // partitions
+		0, 0, 0, 3,
+		0, 0, 0, 5,
+		0, 0, 0, 0, // user data
+	}
+
+	assignments, err := decodeMemberAssignment(data)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int32{"a": {3, 5}}, assignments)
+
+	_, err = decodeMemberAssignment(data[:12])
+	assert.NotNil(t, err)
+
+	negative := []byte{
+		0, 0, // version
+		0, 0, 0, 1, // topics
+		0xff, 0xfe, // topic length -2
+		0, 0, 0, 0,
+	}
+	_, err = decodeMemberAssignment(negative)
+	assert.NotNil(t, err)
+}
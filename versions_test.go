@@ -0,0 +1,91 @@
+package kag
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/savaki/kag/internal/wire"
+	"github.com/savaki/kag/kagtest"
+	"github.com/tj/assert"
+)
+
+func TestNegotiation(t *testing.T) {
+	testCases := map[string]struct {
+		Setup      func(cluster *kagtest.Cluster)
+		ClusterID  string
+		Controller int32
+		Internal   bool
+		Rack       string
+		TimeLag    bool
+
+		// OffsetsForTime requires ListOffsets v1, kafka 0.10.1
+		OffsetsForTime bool
+	}{
+		"current": {
+			ClusterID:  "kagtest",
+			Controller: 1,
+			Internal:   true,
+			Rack:       "us-east-1a",
+			TimeLag:    true,
+
+			OffsetsForTime: true,
+		},
+		"kafka 0.10.0": {
+			Setup: func(cluster *kagtest.Cluster) {
+				cluster.SetApiVersion(wire.FetchKey, 0, 2)
+				cluster.SetApiVersion(wire.ListOffsetsKey, 0, 0)
+				cluster.SetApiVersion(wire.MetadataKey, 0, 1)
+				cluster.SetApiVersion(wire.OffsetFetchKey, 0, 1)
+			},
+			Controller: 1,
+			Internal:   true,
+			Rack:       "us-east-1a",
+			TimeLag:    true,
+		},
+		"kafka 0.9": {
+			Setup: func(cluster *kagtest.Cluster) {
+				cluster.DisableApiVersions()
+			},
+			Controller: -1,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			cluster := newCluster(t)
+			defer cluster.Close()
+			assert.Nil(t, cluster.CreateTopic("__consumer_offsets", 1, 1))
+			cluster.SetRack(1, "us-east-1a")
+			if tc.Setup != nil {
+				tc.Setup(cluster)
+			}
+
+			config := testConfig(cluster)
+			config.TimeLag = true
+			client := NewClient(config)
+
+			snapshot, err := client.Scrape(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, map[string]map[int32]int64{"orders": {0: 10, 1: 5}}, snapshot.Newest)
+			assert.Equal(t, map[string]map[string]map[int32]int64{"billing": {"orders": {0: 6, 1: 0}}}, snapshot.Lag)
+			assert.Equal(t, tc.ClusterID, snapshot.ClusterID)
+			assert.Equal(t, tc.Controller, snapshot.Controller)
+			assert.Equal(t, tc.Internal, snapshot.Internal["__consumer_offsets"])
+			assert.Equal(t, tc.Rack, snapshot.Brokers[0].Rack)
+			_, ok := snapshot.TimeLag["billing"]["orders"][0]
+			assert.Equal(t, tc.TimeLag, ok)
+
+			description, err := client.DescribeGroup(context.Background(), "billing")
+			assert.Nil(t, err)
+			assert.Equal(t, "Empty", description.State)
+
+			_, err = client.OffsetsForTime(context.Background(), time.Now(), "orders")
+			if tc.OffsetsForTime {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}